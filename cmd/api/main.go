@@ -20,7 +20,15 @@ func main() {
 	log.Printf("Starting server on port %s\n", configs.Env("API_PORT", "8080"))
 	r := routes.HandleRequests()
 
-	err := r.Run(":" + configs.Env("API_PORT", "8080"))
+	// Reload keys configuration file on change
+	stopWatching, err := configs.WatchConfig()
+	if err != nil {
+		log.Printf("Couldn't watch the config file, changes require a restart: %s\n", err.Error())
+	} else {
+		defer stopWatching()
+	}
+
+	err = r.Run(":" + configs.Env("API_PORT", "8080"))
 	if err != nil {
 		log.Fatalf("Couldn't start server due to: %s\n", err.Error())
 	}
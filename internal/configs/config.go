@@ -3,10 +3,10 @@ package configs
 import (
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 
 	"github.com/joho/godotenv"
-	"github.com/spf13/viper"
 )
 
 // getConfigPath returns the path to the config files
@@ -27,24 +27,43 @@ func getConfigPath() string {
 
 // LoadConfig loadConfig loads the config file
 func LoadConfig() {
-	viper.SetConfigName("keys")
-	viper.SetConfigType("json")
-	viper.AddConfigPath(getConfigPath())
-	viper.AutomaticEnv()
-
-	if err := viper.ReadInConfig(); err != nil {
+	if err := LoadConfigFile(filepath.Join(getConfigPath(), "keys.json")); err != nil {
 		log.Fatalf("Error reading config file: %v", err)
 	}
 }
 
+// LoadConfigFile loads and validates the config file at the given path, then swaps it in as the current snapshot.
+// If the file fails to load or validate, the current snapshot stays active
+func LoadConfigFile(path string) error {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	snapshot, err := readSnapshot(path)
+	if err != nil {
+		return err
+	}
+	current.Store(snapshot)
+	return nil
+}
+
+// ReloadConfig reads the loaded config file again, so changes made to it are seen immediately
+func ReloadConfig() error {
+	return LoadConfigFile(ConfigFile())
+}
+
+// ConfigFile returns the path of the loaded config file
+func ConfigFile() string {
+	return Current().path
+}
+
 // Config returns the value of the key in the config file
 func Config(key string, fallback string) any {
-	value := viper.Get(key)
-	if value == "" {
-		return fallback
-	}
-	return value
+	return Current().Config(key, fallback)
+}
 
+// VirtualKeyConfig returns the configuration of the virtual key in the config file, or nil if the key doesn't exist
+func VirtualKeyConfig(virtualKey string) map[string]interface{} {
+	return Current().VirtualKeyConfig(virtualKey)
 }
 
 // LoadEnv loadEnv loads the .env file
@@ -0,0 +1,118 @@
+package configs
+
+import (
+	"context"
+	"fmt"
+	"qualifire-home-assignment/internal/models"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+)
+
+// Snapshot is a loaded and validated version of the config file. It is never modified, a reload swaps in a new snapshot.
+// A request captures the active snapshot once in its context and reads every setting from it, hence it finishes against
+// the config it started with
+type Snapshot struct {
+	v    *viper.Viper
+	path string
+}
+
+var (
+	current atomic.Pointer[Snapshot]
+	// loadMu serializes the loads, so the last file read is the one which stays active
+	loadMu sync.Mutex
+)
+
+// Current returns the active snapshot of the config file
+func Current() *Snapshot {
+	if snapshot := current.Load(); snapshot != nil {
+		return snapshot
+	}
+	return &Snapshot{v: viper.New()}
+}
+
+// SNAPSHOT_KEY is the context key of the snapshot a request captured
+const SNAPSHOT_KEY = "config_snapshot"
+
+// FromContext returns the snapshot captured in the context, or the active snapshot when none was captured
+func FromContext(ctx context.Context) *Snapshot {
+	if snapshot, ok := ctx.Value(SNAPSHOT_KEY).(*Snapshot); ok {
+		return snapshot
+	}
+	return Current()
+}
+
+// Config returns the value of the key in the snapshot
+func (s *Snapshot) Config(key string, fallback string) any {
+	value := s.v.Get(key)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// VirtualKeyConfig returns the configuration of the virtual key in the snapshot, or nil if the key doesn't exist
+func (s *Snapshot) VirtualKeyConfig(virtualKey string) map[string]interface{} {
+	virtualKeys, ok := s.v.Get("virtual_keys").(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	keyConfig, _ := virtualKeys[virtualKey].(map[string]interface{})
+	return keyConfig
+}
+
+// readSnapshot reads the config file into a new snapshot and validates it
+func readSnapshot(path string) (*Snapshot, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("json")
+	v.AutomaticEnv()
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	if err := validate(v); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return &Snapshot{v: v, path: path}, nil
+}
+
+// validate checks that every virtual key is routable and its limits are well-formed, that the model aliases are routable
+// and that the prices are numbers
+func validate(v *viper.Viper) error {
+	virtualKeys, ok := v.Get("virtual_keys").(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("virtual_keys is missing")
+	}
+
+	for virtualKey, keyConfig := range virtualKeys {
+		var key models.VirtualKey
+		if err := mapstructure.WeakDecode(keyConfig, &key); err != nil {
+			return fmt.Errorf("virtual key %s: %w", virtualKey, err)
+		}
+		if err := key.Validate(); err != nil {
+			return fmt.Errorf("virtual key %s: %w", virtualKey, err)
+		}
+	}
+
+	if aliases := v.Get("model_aliases"); aliases != nil {
+		var modelAliases map[string]models.Target
+		if err := mapstructure.WeakDecode(aliases, &modelAliases); err != nil {
+			return fmt.Errorf("model_aliases: %w", err)
+		}
+		if err := models.ValidateModelAliases(modelAliases); err != nil {
+			return err
+		}
+	}
+
+	if pricing := v.Get("pricing"); pricing != nil {
+		var prices map[string]map[string]float64
+		if err := mapstructure.Decode(pricing, &prices); err != nil {
+			return fmt.Errorf("pricing: %w", err)
+		}
+	}
+	return nil
+}
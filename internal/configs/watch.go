@@ -0,0 +1,50 @@
+package configs
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchConfig reloads the config file whenever it changes, until the returned stop function is called.
+// A file which fails to load or validate is rejected and logged, while the previous snapshot stays active
+func WatchConfig() (func(), error) {
+	path := filepath.Clean(ConfigFile())
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	// The directory is watched rather than the file, since editors and the admin API replace the file instead of writing it
+	if err = watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != path || !event.Has(fsnotify.Write|fsnotify.Create) {
+					continue
+				}
+				if err := LoadConfigFile(path); err != nil {
+					log.Printf("Rejected config file change, keeping the previous config: %s", err.Error())
+					continue
+				}
+				log.Printf("Reloaded config file %s", path)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Config file watcher error: %s", err.Error())
+			}
+		}
+	}()
+
+	return func() { watcher.Close() }, nil
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	stderrors "errors"
+	"net/http"
+	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/http/validators"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminKeys handles the admin requests which manage the virtual keys.
+// Upstream api keys are masked in every response
+type AdminKeys struct{}
+
+// List returns all the virtual keys
+func (a AdminKeys) List(c *gin.Context) {
+	keys, err := services.GetKeyService().List()
+	if err != nil {
+		panic(toKeyError(err))
+	}
+
+	masked := make([]models.VirtualKey, len(keys))
+	for i, key := range keys {
+		masked[i] = key.Masked()
+	}
+	Success(c, http.StatusOK, gin.H{"keys": masked})
+}
+
+// Get returns a single virtual key
+func (a AdminKeys) Get(c *gin.Context) {
+	key, err := services.GetKeyService().Get(c.Param("key"))
+	if err != nil {
+		panic(toKeyError(err))
+	}
+	Success(c, http.StatusOK, key.Masked())
+}
+
+// Create creates a virtual key
+func (a AdminKeys) Create(c *gin.Context) {
+	key := validators.AdminKey{}.Validate(c).(models.VirtualKey)
+
+	key, err := services.GetKeyService().Create(key)
+	if err != nil {
+		panic(toKeyError(err))
+	}
+	Success(c, http.StatusCreated, key.Masked())
+}
+
+// Update updates the fields of a virtual key given in the request
+func (a AdminKeys) Update(c *gin.Context) {
+	update := validators.AdminKey{}.ValidateUpdate(c)
+
+	key, err := services.GetKeyService().Update(c.Param("key"), update.Apply)
+	if err != nil {
+		panic(toKeyError(err))
+	}
+	Success(c, http.StatusOK, key.Masked())
+}
+
+// Rotate replaces a virtual key with a newly generated one
+func (a AdminKeys) Rotate(c *gin.Context) {
+	key, err := services.GetKeyService().Rotate(c.Param("key"))
+	if err != nil {
+		panic(toKeyError(err))
+	}
+	Success(c, http.StatusOK, key.Masked())
+}
+
+// Revoke deletes a virtual key
+func (a AdminKeys) Revoke(c *gin.Context) {
+	if err := services.GetKeyService().Revoke(c.Param("key")); err != nil {
+		panic(toKeyError(err))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// toKeyError maps the errors of the key service to API errors
+func toKeyError(err error) errors.Error {
+	switch {
+	case stderrors.Is(err, services.ErrKeyNotFound):
+		return errors.GetError("VIRTUAL_KEY_NOT_FOUND", err.Error(), http.StatusNotFound)
+	case stderrors.Is(err, services.ErrKeyExists):
+		return errors.GetError("VIRTUAL_KEY_EXISTS", err.Error(), http.StatusConflict)
+	case stderrors.Is(err, services.ErrInvalidKey):
+		return errors.Validation{}.GetError(err.Error(), http.StatusBadRequest)
+	default:
+		return errors.GetError("MISSING_CONFIGURATIONS", "failed to update the virtual keys: "+err.Error(), http.StatusInternalServerError)
+	}
+}
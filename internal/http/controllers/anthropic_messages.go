@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/http/validators"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/providers"
+	"qualifire-home-assignment/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AnthropicMessages handles the Anthropic-compatible messages requests.
+// Whichever provider serves the request, the response follows the Anthropic messages schema
+type AnthropicMessages struct{}
+
+// RouteRequests handles the Anthropic-compatible messages requests
+func (am AnthropicMessages) RouteRequests(c *gin.Context) {
+	req := validators.AnthropicMessage{}.Validate(c)
+	proxyReq := req.(models.ProxyRequest)
+
+	provider, ok := prepareRequest(c, proxyReq)
+	if !ok {
+		return
+	}
+	if proxyReq.Stream {
+		am.streamRequest(c, provider, proxyReq)
+		return
+	}
+
+	result := provider.SendRequest(c.Request.Context())
+	setResult(c, result)
+
+	id := result.ID
+	if id == "" {
+		id = newMessageID()
+	}
+	choice := result.Choices[0]
+	message := newAnthropicMessage(id, result.Model, models.NewAnthropicUsage(result.Usage))
+	if choice.Content != "" || len(choice.ToolCalls) == 0 {
+		message.Content = append(message.Content, models.AnthropicContentBlock{Type: models.ANTHROPIC_TEXT_BLOCK, Text: choice.Content})
+	}
+	for _, toolCall := range choice.ToolCalls {
+		message.Content = append(message.Content, models.NewToolUseBlock(toolCall))
+	}
+	message.StopReason = models.ToAnthropicStopReason(result.Choices[0].FinishReason)
+
+	Success(c, http.StatusOK, message)
+}
+
+// streamRequest relays the provider stream to the client as Anthropic message events.
+// The text and the tool calls are streamed as content blocks, each one is opened by its first delta
+func (am AnthropicMessages) streamRequest(c *gin.Context, provider providers.Provider, proxyReq models.ProxyRequest) {
+	started := false
+	var finishReason string
+	blocks := anthropicBlocks{c: c, tools: make(map[int]int)}
+	start := func(model string) {
+		if started {
+			return
+		}
+		started = true
+		// The prompt tokens are reported by the providers at the end of the stream, so they are estimated here
+		usage := models.AnthropicUsage{InputTokens: utils.CalculateRequestTokens(proxyReq.Messages, proxyReq.Model)}
+		Event(c, "message_start", gin.H{"type": "message_start", "message": newAnthropicMessage(newMessageID(), model, usage)})
+	}
+
+	result := relayStream(c, provider, func(chunk providers.StreamChunk) {
+		start(chunk.Model)
+		if chunk.Delta.Content != "" {
+			blocks.text(chunk.Delta.Content)
+		}
+		for _, toolCall := range chunk.Delta.ToolCalls {
+			blocks.toolCall(toolCall)
+		}
+		if chunk.FinishReason != "" {
+			finishReason = chunk.FinishReason
+		}
+	}, func(e errors.ApiProvider) {
+		Event(c, "error", e.ToAnthropic())
+	})
+	if result == nil {
+		return
+	}
+
+	start(result.Model)
+	// A message always holds a content block, even when nothing was generated
+	if blocks.count == 0 {
+		blocks.open(models.ANTHROPIC_TEXT_BLOCK, gin.H{"type": models.ANTHROPIC_TEXT_BLOCK, "text": ""})
+	}
+	blocks.close()
+	usage := models.NewAnthropicUsage(result.Usage)
+	Event(c, "message_delta", gin.H{
+		"type":  "message_delta",
+		"delta": gin.H{"stop_reason": models.ToAnthropicStopReason(finishReason), "stop_sequence": nil},
+		"usage": usage,
+	})
+	Event(c, "message_stop", gin.H{"type": "message_stop"})
+}
+
+// anthropicBlocks tracks the content blocks of a streamed Anthropic message, only the last opened block is open
+type anthropicBlocks struct {
+	c *gin.Context
+	// count is the number of opened blocks, current is the type of the open block, or empty when none is open
+	count   int
+	current string
+	// tools maps the index of the tool calls to the index of their block
+	tools map[int]int
+}
+
+// open closes the open block and opens the given one
+func (b *anthropicBlocks) open(blockType string, block gin.H) {
+	b.close()
+	Event(b.c, "content_block_start", gin.H{"type": "content_block_start", "index": b.count, "content_block": block})
+	b.current = blockType
+	b.count++
+}
+
+// close closes the open block, if any
+func (b *anthropicBlocks) close() {
+	if b.current == "" {
+		return
+	}
+	Event(b.c, "content_block_stop", gin.H{"type": "content_block_stop", "index": b.count - 1})
+	b.current = ""
+}
+
+// text streams a text delta, in a new text block unless a text block is open
+func (b *anthropicBlocks) text(text string) {
+	if b.current != models.ANTHROPIC_TEXT_BLOCK {
+		b.open(models.ANTHROPIC_TEXT_BLOCK, gin.H{"type": models.ANTHROPIC_TEXT_BLOCK, "text": ""})
+	}
+	Event(b.c, "content_block_delta", gin.H{"type": "content_block_delta", "index": b.count - 1, "delta": gin.H{"type": "text_delta", "text": text}})
+}
+
+// toolCall streams a tool call fragment, the first fragment of a call opens its tool_use block
+func (b *anthropicBlocks) toolCall(toolCall models.ToolCall) {
+	if toolCall.Index == nil {
+		return
+	}
+	if toolCall.ID != "" {
+		b.open(models.ANTHROPIC_TOOL_USE_BLOCK, gin.H{
+			"type":  models.ANTHROPIC_TOOL_USE_BLOCK,
+			"id":    toolCall.ID,
+			"name":  toolCall.Function.Name,
+			"input": gin.H{},
+		})
+		b.tools[*toolCall.Index] = b.count - 1
+	}
+	index, ok := b.tools[*toolCall.Index]
+	if !ok || toolCall.Function.Arguments == "" {
+		return
+	}
+	Event(b.c, "content_block_delta", gin.H{"type": "content_block_delta", "index": index, "delta": gin.H{"type": "input_json_delta", "partial_json": toolCall.Function.Arguments}})
+}
+
+// newAnthropicMessage returns an Anthropic message without content
+func newAnthropicMessage(id string, model string, usage models.AnthropicUsage) models.AnthropicMessage {
+	return models.AnthropicMessage{
+		ID:      id,
+		Type:    models.ANTHROPIC_MESSAGE_TYPE,
+		Role:    "assistant",
+		Model:   model,
+		Content: []models.AnthropicContentBlock{},
+		Usage:   usage,
+	}
+}
+
+// newMessageID returns a new message identifier, for the responses the provider didn't identify
+func newMessageID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return "msg_" + hex.EncodeToString(b)
+}
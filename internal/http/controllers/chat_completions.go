@@ -2,11 +2,13 @@ package controllers
 
 import (
 	"net/http"
+	"qualifire-home-assignment/internal/configs"
 	"qualifire-home-assignment/internal/http/errors"
 	"qualifire-home-assignment/internal/http/validators"
 	"qualifire-home-assignment/internal/models"
 	"qualifire-home-assignment/internal/providers"
 	"qualifire-home-assignment/internal/services"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,23 +21,121 @@ func (cp ChatCompletions) RouteRequests(c *gin.Context) {
 	req := validators.ChatCompletion{}.Validate(c)
 	proxyReq := req.(models.ProxyRequest)
 
-	// Store provider in context for metrics middleware
+	provider, ok := prepareRequest(c, proxyReq)
+	if !ok {
+		return
+	}
+	if proxyReq.Stream {
+		cp.streamRequest(c, provider)
+		return
+	}
+
+	result := provider.SendRequest(c.Request.Context())
+	setResult(c, result)
+
+	Success(c, http.StatusOK, result)
+}
+
+// streamRequest relays the provider stream to the client as server-sent events
+func (cp ChatCompletions) streamRequest(c *gin.Context, provider providers.Provider) {
+	result := relayStream(c, provider, func(chunk providers.StreamChunk) {
+		Event(c, "", chunk)
+	}, func(e errors.ApiProvider) {
+		Event(c, "error", e.ToGin())
+	})
+	if result == nil {
+		return
+	}
+
+	Event(c, "", "[DONE]")
+}
+
+// prepareRequest stores the request in context for the metrics middleware, checks the quota of the virtual key
+// and creates the provider the request is routed to. It returns false if the request was rejected
+func prepareRequest(c *gin.Context, proxyReq models.ProxyRequest) (providers.Provider, bool) {
+	snapshot := configs.FromContext(c)
+	semanticThreshold := services.GetSemanticCacheThreshold(snapshot, proxyReq.VirtualKey)
+	proxyReq.Cache = models.ParseCacheControl(c.GetHeader("Cache-Control"), services.IsCacheEnabled(snapshot, proxyReq.VirtualKey) || semanticThreshold > 0)
+	// Only the deterministic requests use the cache, unless the request opts in to replaying a sampled response
+	if !proxyReq.Options.IsDeterministic() && !proxyReq.Cache.Sampled {
+		proxyReq.Cache = models.CacheControl{}
+	}
+	if proxyReq.Cache.Enabled() {
+		proxyReq.Cache.SemanticThreshold = semanticThreshold
+	}
+
+	// Store provider and model in context for metrics middleware
 	c.Set("provider", proxyReq.Provider)
+	c.Set("model", proxyReq.Model)
 
-	// Check quota using virtual key
-	virtualKey := proxyReq.VirtualKey
+	// Reserve a request of the quota of the virtual key, its tokens and spend are charged by the metrics middleware
 	quotaService := services.GetQuotaService()
-	allowed, reason := quotaService.CheckQuota(virtualKey)
+	allowed, reason := quotaService.ReserveRequest(snapshot, proxyReq.VirtualKey)
 	if !allowed {
-		err := errors.GetError("QUOTA_EXCEEDED", reason, http.StatusTooManyRequests)
-		c.JSON(err.StatusCode, err.ToGin())
-		return
+		Error(c, errors.GetError("QUOTA_EXCEEDED", reason, http.StatusTooManyRequests))
+		return nil, false
 	}
 
-	result := providers.Factory(proxyReq).SendRequest()
+	if proxyReq.Cache.Enabled() {
+		cached := providers.NewCached(c.Request.Context(), proxyReq)
+		setCacheStatus(c, cached)
+		return cached, true
+	}
+	return providers.Factory(proxyReq), true
+}
 
-	// Store token count in context for metrics middleware
-	c.Set("token_count", result.TokensUsed)
+// setCacheStatus reports whether the response is served from the cache in the X-Cache header, and stores it
+// in context for the metrics middleware. The headers are set before the provider is called, so streams carry them too
+func setCacheStatus(c *gin.Context, cached providers.Cached) {
+	status, age := cached.Status()
+	c.Set("cache", status)
+	c.Header("X-Cache", status)
+	if models.IsCacheHit(status) {
+		c.Header("Age", strconv.Itoa(int(age.Seconds())))
+	}
+	if status == models.CACHE_SEMANTIC_HIT {
+		c.Header("X-Cache-Similarity", strconv.FormatFloat(cached.Similarity(), 'f', 4, 64))
+	}
+}
 
-	Success(c, http.StatusOK, result)
+// relayStream relays the provider stream to the client through writeChunk.
+// The request context is passed upstream, so a client disconnect cancels the provider call.
+// It returns nil if the stream failed after it started, in which case the error was reported through writeError
+func relayStream(c *gin.Context, provider providers.Provider, writeChunk providers.StreamHandler, writeError func(e errors.ApiProvider)) (result *providers.Response) {
+	defer func() {
+		// Once the stream has started the status is already sent, so errors are reported as an event
+		if err := recover(); err != nil {
+			e, ok := err.(errors.ApiProvider)
+			if !ok || !c.Writer.Written() {
+				panic(err)
+			}
+			c.Set("status_code", e.StatusCode)
+			writeError(e)
+			result = nil
+		}
+	}()
+
+	result = provider.StreamRequest(c.Request.Context(), writeChunk)
+	setResult(c, result)
+	return result
+}
+
+// setResult stores the target which actually answered and its token usage in context for metrics middleware.
+// A response served from the cache costs nothing, and is charged to the token quota according to the cache quota policy
+func setResult(c *gin.Context, result *providers.Response) {
+	c.Set("provider", result.Provider)
+	c.Set("model", result.Model)
+	if models.IsCacheHit(c.GetString("cache")) {
+		tokens := 0
+		if services.GetCacheQuotaPolicy() == services.CACHE_QUOTA_CHARGE {
+			tokens = result.TokensUsed
+		}
+		c.Set("token_count", tokens)
+		c.Set("usage", models.Usage{})
+		c.Set("cost_usd", float64(0))
+		return
+	}
+	c.Set("token_count", result.TokensUsed)
+	c.Set("usage", result.Usage)
+	c.Set("cost_usd", services.CalculateCost(configs.FromContext(c), result.Model, result.Usage))
 }
@@ -10,11 +10,46 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	// ERROR_FORMAT is the context key of the format the errors of the request are rendered in
+	ERROR_FORMAT = "error_format"
+	// OPENAI_ERROR_FORMAT renders the errors in the OpenAI error format
+	OPENAI_ERROR_FORMAT = "openai"
+	// ANTHROPIC_ERROR_FORMAT renders the errors in the Anthropic error format
+	ANTHROPIC_ERROR_FORMAT = "anthropic"
+)
+
 // Success is the success response
 func Success(c *gin.Context, status int, results interface{}) {
 	c.JSON(status, results)
 }
 
+// Error is the error response, rendered in the error format of the request
+func Error(c *gin.Context, e errors.Error) {
+	switch c.GetString(ERROR_FORMAT) {
+	case OPENAI_ERROR_FORMAT:
+		c.JSON(e.StatusCode, e.ToOpenAI())
+	case ANTHROPIC_ERROR_FORMAT:
+		c.JSON(e.StatusCode, e.ToAnthropic())
+	default:
+		c.JSON(e.StatusCode, e.ToGin())
+	}
+}
+
+// ErrorFormat renders the errors of the requests in the given error format
+func ErrorFormat(format string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(ERROR_FORMAT, format)
+		c.Next()
+	}
+}
+
+// Event writes a single server-sent event and flushes it to the client
+func Event(c *gin.Context, event string, data interface{}) {
+	c.SSEvent(event, data)
+	c.Writer.Flush()
+}
+
 // Recovery is the recovery middleware
 func Recovery() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -23,15 +58,19 @@ func Recovery() gin.HandlerFunc {
 				switch e := err.(type) {
 				case errors.ApiProvider:
 					// Do not log error and debug stack for api provider errors, because they are already logged
-					c.JSON(e.StatusCode, e.ToGin())
+					Error(c, e.Error)
 					break
 				case errors.Error:
-					c.JSON(e.StatusCode, e.ToGin())
+					Error(c, e)
 					logRecoveryError(err)
 				default:
-					c.JSON(http.StatusInternalServerError, gin.H{
-						"error": fmt.Sprintf("internal error: %v", err),
-					})
+					if c.GetString(ERROR_FORMAT) != "" {
+						Error(c, errors.GetError("INTERNAL_ERROR", fmt.Sprintf("internal error: %v", err), http.StatusInternalServerError))
+					} else {
+						c.JSON(http.StatusInternalServerError, gin.H{
+							"error": fmt.Sprintf("internal error: %v", err),
+						})
+					}
 					logRecoveryError(err)
 				}
 				c.Abort()
@@ -43,5 +82,5 @@ func Recovery() gin.HandlerFunc {
 
 func logRecoveryError(err any) {
 	log.Printf("Panic recovery error: %v", err)
-	log.Printf("Panic recovery debug stack:" + string(debug.Stack()))
+	log.Print("Panic recovery debug stack:" + string(debug.Stack()))
 }
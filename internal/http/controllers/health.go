@@ -0,0 +1,25 @@
+package controllers
+
+import (
+	"net/http"
+	"qualifire-home-assignment/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Health handles health requests
+type Health struct{}
+
+// GetHealth reports that the gateway is up, and the state of the circuit breakers of the provider targets.
+// An open circuit only makes the gateway degraded, since the other targets are still served
+func (h Health) GetHealth(c *gin.Context) {
+	breakers := services.GetCircuitBreakerService().GetStates()
+	status := "ok"
+	for _, breaker := range breakers {
+		if breaker.State != services.CIRCUIT_CLOSED {
+			status = "degraded"
+		}
+	}
+
+	Success(c, http.StatusOK, gin.H{"status": status, "circuit_breakers": breakers})
+}
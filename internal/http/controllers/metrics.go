@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"bytes"
 	"net/http"
 	"qualifire-home-assignment/internal/services"
 
@@ -10,9 +11,22 @@ import (
 // Metrics handles metrics requests
 type Metrics struct{}
 
-// GetMetrics returns usage statistics
+// GetMetrics returns usage statistics.
+// Prometheus scrapers (or ?format=prometheus) get the text exposition format, any other client gets JSON
 func (m Metrics) GetMetrics(c *gin.Context) {
 	metricsService := services.GetMetricsService()
+	if c.Query("format") == "prometheus" || c.NegotiateFormat(gin.MIMEJSON, gin.MIMEPlain) == gin.MIMEPlain {
+		var buf bytes.Buffer
+		metricsService.WritePrometheus(&buf)
+		services.GetQuotaService().WritePrometheus(&buf)
+		services.GetCircuitBreakerService().WritePrometheus(&buf)
+		services.GetKeyPoolService().WritePrometheus(&buf)
+		c.Data(http.StatusOK, services.PROMETHEUS_CONTENT_TYPE, buf.Bytes())
+		return
+	}
+
 	stats := metricsService.GetStats()
+	stats["circuit_breakers"] = services.GetCircuitBreakerService().GetStates()
+	stats["upstream_keys"] = services.GetKeyPoolService().GetUsage()
 	Success(c, http.StatusOK, stats)
 }
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/http/validators"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/providers"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAIChatCompletions handles the OpenAI-compatible chat completion requests.
+// Whichever provider serves the request, the response follows the OpenAI chat completion schema
+type OpenAIChatCompletions struct{}
+
+// RouteRequests handles the OpenAI-compatible chat completion requests
+func (oc OpenAIChatCompletions) RouteRequests(c *gin.Context) {
+	req := validators.OpenAIChatCompletion{}.Validate(c)
+	proxyReq := req.(models.ProxyRequest)
+
+	provider, ok := prepareRequest(c, proxyReq)
+	if !ok {
+		return
+	}
+	if proxyReq.Stream {
+		oc.streamRequest(c, provider, proxyReq)
+		return
+	}
+
+	result := provider.SendRequest(c.Request.Context())
+	setResult(c, result)
+
+	id := result.ID
+	if id == "" {
+		id = newCompletionID()
+	}
+	completion := models.ChatCompletion{
+		ID:      id,
+		Object:  models.CHAT_COMPLETION_OBJECT,
+		Created: time.Now().Unix(),
+		Model:   result.Model,
+		Choices: make([]models.ChatCompletionChoice, len(result.Choices)),
+		Usage:   models.NewChatCompletionUsage(result.Usage),
+	}
+	for i, choice := range result.Choices {
+		message := &models.ChatCompletionMessage{Role: "assistant", ToolCalls: choice.ToolCalls}
+		// The content of a message which only calls tools is null
+		if choice.Content != "" || len(choice.ToolCalls) == 0 {
+			message.Content = &choice.Content
+		}
+		completion.Choices[i] = models.ChatCompletionChoice{
+			Index:        i,
+			Message:      message,
+			FinishReason: toOpenAIFinishReason(choice.FinishReason),
+		}
+	}
+
+	Success(c, http.StatusOK, completion)
+}
+
+// streamRequest relays the provider stream to the client as OpenAI chat completion chunks
+func (oc OpenAIChatCompletions) streamRequest(c *gin.Context, provider providers.Provider, proxyReq models.ProxyRequest) {
+	id := newCompletionID()
+	created := time.Now().Unix()
+
+	result := relayStream(c, provider, func(chunk providers.StreamChunk) {
+		delta := &models.ChatCompletionMessage{Role: chunk.Delta.Role, ToolCalls: chunk.Delta.ToolCalls}
+		if chunk.Delta.Content != "" {
+			delta.Content = &chunk.Delta.Content
+		}
+		var finishReason *string
+		if chunk.FinishReason != "" {
+			finishReason = &chunk.FinishReason
+		}
+
+		Event(c, "", models.ChatCompletion{
+			ID:      id,
+			Object:  models.CHAT_COMPLETION_CHUNK_OBJECT,
+			Created: created,
+			Model:   chunk.Model,
+			Choices: []models.ChatCompletionChoice{{Index: chunk.Index, Delta: delta, FinishReason: finishReason}},
+		})
+	}, func(e errors.ApiProvider) {
+		Event(c, "", e.ToOpenAI())
+	})
+	if result == nil {
+		return
+	}
+
+	// The usage chunk has no choices and follows the last chunk, as OpenAI sends it
+	if options := proxyReq.Options.StreamOptions; options != nil && options.IncludeUsage {
+		Event(c, "", models.ChatCompletion{
+			ID:      id,
+			Object:  models.CHAT_COMPLETION_CHUNK_OBJECT,
+			Created: created,
+			Model:   result.Model,
+			Choices: []models.ChatCompletionChoice{},
+			Usage:   models.NewChatCompletionUsage(result.Usage),
+		})
+	}
+
+	Event(c, "", "[DONE]")
+}
+
+// newCompletionID returns a new chat completion identifier, for the responses the provider didn't identify
+func newCompletionID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
+
+// toOpenAIFinishReason returns the finish reason of a choice, a choice whose reason is unknown is reported as stopped
+func toOpenAIFinishReason(finishReason string) *string {
+	if finishReason == "" {
+		finishReason = "stop"
+	}
+	return &finishReason
+}
@@ -1,5 +1,7 @@
 package errors
 
+import "net/http"
+
 type ApiProvider struct {
 	Error
 }
@@ -14,3 +16,10 @@ func (a ApiProvider) GetError(message string, status int) ApiProvider {
 		},
 	}
 }
+
+// GetUnavailableError returns the error of a provider which is known to be unavailable, so it wasn't called
+func (a ApiProvider) GetUnavailableError(message string) ApiProvider {
+	e := a.GetError(message, http.StatusServiceUnavailable)
+	e.Code = "LLM_PROVIDER_UNAVAILABLE"
+	return e
+}
@@ -1,7 +1,9 @@
 package errors // Contract is the interface for the errors
 import (
+	"net/http"
 	"qualifire-home-assignment/internal/configs"
 	"runtime/debug"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,6 +24,15 @@ type Error struct {
 	Message    string
 	Details    string
 	StatusCode int
+	// Limit is the request limit the error was raised for, if any
+	Limit *Limit
+}
+
+// Limit describes a request limit which was exceeded, and the actual value of the request
+type Limit struct {
+	Name   string `json:"name"`
+	Max    int    `json:"max"`
+	Actual int    `json:"actual"`
 }
 
 // GetDetails returns the details
@@ -44,9 +55,73 @@ func GetError(code string, message string, status int) Error {
 
 // ToGin returns the error in gin format
 func (e Error) ToGin() gin.H {
-	return gin.H{
+	h := gin.H{
 		"code":    e.Code,
 		"message": e.Message,
 		"details": e.Details,
 	}
+	if e.Limit != nil {
+		h["limit"] = e.Limit
+	}
+	return h
+}
+
+// ToOpenAI returns the error in the OpenAI error format, which the OpenAI clients can parse.
+// The exceeded limit, if any, is named in param
+func (e Error) ToOpenAI() gin.H {
+	body := gin.H{
+		"message": e.Message,
+		"type":    openAIErrorType(e.StatusCode),
+		"param":   nil,
+		"code":    strings.ToLower(e.Code),
+	}
+	if e.Limit != nil {
+		body["param"] = e.Limit.Name
+		body["limit"] = e.Limit
+	}
+	return gin.H{"error": body}
+}
+
+// ToAnthropic returns the error in the Anthropic error format, which the Anthropic clients can parse
+func (e Error) ToAnthropic() gin.H {
+	body := gin.H{
+		"type":    anthropicErrorType(e.StatusCode),
+		"message": e.Message,
+	}
+	if e.Limit != nil {
+		body["limit"] = e.Limit
+	}
+	return gin.H{"type": "error", "error": body}
+}
+
+// anthropicErrorType maps the status code to the Anthropic error type
+func anthropicErrorType(status int) string {
+	switch {
+	case status == http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case status == 529:
+		return "overloaded_error"
+	case status >= http.StatusInternalServerError:
+		return "api_error"
+	default:
+		return openAIErrorType(status)
+	}
+}
+
+// openAIErrorType maps the status code to the OpenAI error type
+func openAIErrorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= http.StatusInternalServerError:
+		return "server_error"
+	default:
+		return "invalid_request_error"
+	}
 }
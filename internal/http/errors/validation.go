@@ -1,5 +1,7 @@
 package errors
 
+import "fmt"
+
 type Validation struct {
 	Error
 }
@@ -12,3 +14,10 @@ func (v Validation) GetError(message string, status int) Error {
 		StatusCode: status,
 	}
 }
+
+// GetLimitError returns the error of a request which exceeds one of its size limits, naming the limit and the actual value
+func (v Validation) GetLimitError(limit string, max int, actual int, status int) Error {
+	e := v.GetError(fmt.Sprintf("%s exceeded: the request has %d, the limit is %d", limit, actual, max), status)
+	e.Limit = &Limit{Name: limit, Max: max, Actual: actual}
+	return e
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuthMiddleware authenticates the admin requests with the ADMIN_API_KEY bearer token.
+// The admin API is disabled when no ADMIN_API_KEY is configured
+func AdminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminKey := configs.Env("ADMIN_API_KEY", "")
+		if adminKey == "" {
+			err := errors.GetError("UNAUTHORIZED", "admin API is disabled", http.StatusForbidden)
+			c.AbortWithStatusJSON(err.StatusCode, err.ToGin())
+			return
+		}
+
+		token, ok := utils.ExtractVirtualKey(c.GetHeader("Authorization"))
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminKey)) != 1 {
+			err := errors.GetError("UNAUTHORIZED", "invalid admin API key", http.StatusUnauthorized)
+			c.AbortWithStatusJSON(err.StatusCode, err.ToGin())
+			return
+		}
+
+		c.Next()
+	}
+}
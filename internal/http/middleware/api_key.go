@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// ApiKeyHeader accepts the virtual key in the x-api-key header, as sent by the Anthropic clients,
+// by mapping it to the bearer token the rest of the gateway reads
+func ApiKeyHeader() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiKey := c.GetHeader("x-api-key"); apiKey != "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/services"
+	"qualifire-home-assignment/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BodyLimit rejects the requests whose body exceeds the max_body_bytes limit of their virtual key.
+// The body is read up to the limit before the handlers bind it, so an oversized body is never read entirely
+func BodyLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		virtualKey, _ := utils.ExtractVirtualKey(c.GetHeader("Authorization"))
+		maxBodyBytes := services.GetRequestLimits(configs.FromContext(c), virtualKey).MaxBodyBytes
+		if maxBodyBytes <= 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > int64(maxBodyBytes) {
+			panic(errors.Validation{}.GetLimitError("max_body_bytes", maxBodyBytes, int(c.Request.ContentLength), http.StatusRequestEntityTooLarge))
+		}
+
+		// A body of unknown length is read one byte past the limit, which tells whether it exceeds it
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, int64(maxBodyBytes)+1))
+		if err != nil {
+			panic(errors.Validation{}.GetError(err.Error(), http.StatusBadRequest))
+		}
+		if len(body) > maxBodyBytes {
+			panic(errors.Validation{}.GetLimitError("max_body_bytes", maxBodyBytes, len(body), http.StatusRequestEntityTooLarge))
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		c.Next()
+	}
+}
@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"qualifire-home-assignment/internal/configs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConfigSnapshot captures the active config snapshot once per request, so the middlewares, validators and
+// services read the same config even when it's reloaded while the request runs
+func ConfigSnapshot() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(configs.SNAPSHOT_KEY, configs.Current())
+		c.Next()
+	}
+}
@@ -1,7 +1,9 @@
 package middleware
 
 import (
+	"qualifire-home-assignment/internal/configs"
 	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/models"
 	"qualifire-home-assignment/internal/services"
 	"qualifire-home-assignment/internal/utils"
 	"time"
@@ -16,10 +18,15 @@ func MetricsMiddleware() gin.HandlerFunc {
 		startTime := time.Now()
 		metricsService := services.GetMetricsService()
 		quotaService := services.GetQuotaService()
+		snapshot := configs.FromContext(c)
 
 		var virtualKey string
 		var provider string
+		var model string
 		var tokenCount int
+		var usage models.Usage
+		var costUSD float64
+		var cache string
 		statusCode := 200
 
 		// Defer to ensure metrics are always recorded
@@ -29,9 +36,19 @@ func MetricsMiddleware() gin.HandlerFunc {
 			if providerVal, exists := c.Get("provider"); exists {
 				provider = providerVal.(string)
 			}
+			if modelVal, exists := c.Get("model"); exists {
+				model = modelVal.(string)
+			}
 			if tokenVal, exists := c.Get("token_count"); exists {
 				tokenCount = tokenVal.(int)
 			}
+			if usageVal, exists := c.Get("usage"); exists {
+				usage = usageVal.(models.Usage)
+			}
+			if costVal, exists := c.Get("cost_usd"); exists {
+				costUSD = costVal.(float64)
+			}
+			cache = c.GetString("cache")
 
 			// Capture panic information if present
 			if err := recover(); err != nil {
@@ -50,14 +67,19 @@ func MetricsMiddleware() gin.HandlerFunc {
 					metricsService.RecordRequest(services.RequestMetric{
 						Provider:   provider,
 						VirtualKey: virtualKey,
+						Model:      model,
 						Duration:   duration,
 						Status:     statusCode,
+						Tokens:     tokenCount,
+						Usage:      usage,
+						CostUSD:    costUSD,
+						Cache:      cache,
 						Timestamp:  startTime,
 					})
 
-					// Increment quota even on failure to prevent abuse
+					// Charge the tokens to the quota even on failure to prevent abuse, the request was counted when reserved
 					if tokenCount > 0 {
-						quotaService.IncrementRequest(virtualKey, tokenCount)
+						quotaService.ChargeUsage(snapshot, virtualKey, tokenCount, costUSD)
 					}
 				}
 
@@ -67,20 +89,28 @@ func MetricsMiddleware() gin.HandlerFunc {
 
 			// Normal execution - record metrics
 			if virtualKey != "" {
-				// Get actual status code from response
+				// Get actual status code from response, unless a stream failed after its status was sent
 				statusCode = c.Writer.Status()
+				if statusVal, exists := c.Get("status_code"); exists {
+					statusCode = statusVal.(int)
+				}
 
 				metricsService.RecordRequest(services.RequestMetric{
 					Provider:   provider,
 					VirtualKey: virtualKey,
+					Model:      model,
 					Duration:   duration,
 					Status:     statusCode,
+					Tokens:     tokenCount,
+					Usage:      usage,
+					CostUSD:    costUSD,
+					Cache:      cache,
 					Timestamp:  startTime,
 				})
 
-				// Increment quota with token count
+				// Charge the tokens and spend of the request to the quota
 				if tokenCount > 0 {
-					quotaService.IncrementRequest(virtualKey, tokenCount)
+					quotaService.ChargeUsage(snapshot, virtualKey, tokenCount, costUSD)
 				}
 			}
 		}()
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"net/http"
+	"qualifire-home-assignment/internal/configs"
 	"qualifire-home-assignment/internal/http/errors"
 	"qualifire-home-assignment/internal/services"
 	"qualifire-home-assignment/internal/utils"
@@ -25,7 +26,7 @@ func QuotaMiddleware() gin.HandlerFunc {
 		quotaService := services.GetQuotaService()
 
 		// Check quota
-		allowed, reason := quotaService.CheckQuota(virtualKey)
+		allowed, reason := quotaService.CheckQuota(configs.FromContext(c), virtualKey)
 		if !allowed {
 			err := errors.GetError("QUOTA_EXCEEDED", reason, http.StatusTooManyRequests)
 			c.JSON(err.StatusCode, err.ToGin())
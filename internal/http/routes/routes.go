@@ -1,7 +1,6 @@
 package routes
 
 import (
-	"net/http"
 	"qualifire-home-assignment/internal/configs"
 	"qualifire-home-assignment/internal/http/controllers"
 	"qualifire-home-assignment/internal/http/middleware"
@@ -15,14 +14,34 @@ func HandleRequests() *gin.Engine {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	
-	r.Use(controllers.Recovery())
+	r.Use(controllers.Recovery(), middleware.ConfigSnapshot())
 	g := r.Group("/chat")
-	g.Use(middleware.QuotaMiddleware(), middleware.MetricsMiddleware())
+	g.Use(middleware.QuotaMiddleware(), middleware.MetricsMiddleware(), middleware.BodyLimit())
 	{
 		g.POST("/completions", controllers.ChatCompletions{}.RouteRequests)
 	}
 
-	r.GET("/health", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "ok"}) })
+	v1 := r.Group("/v1")
+	{
+		openAI := v1.Group("", controllers.ErrorFormat(controllers.OPENAI_ERROR_FORMAT), middleware.MetricsMiddleware(), middleware.BodyLimit())
+		openAI.POST("/chat/completions", controllers.OpenAIChatCompletions{}.RouteRequests)
+
+		anthropic := v1.Group("", controllers.ErrorFormat(controllers.ANTHROPIC_ERROR_FORMAT), middleware.ApiKeyHeader(), middleware.MetricsMiddleware(), middleware.BodyLimit())
+		anthropic.POST("/messages", controllers.AnthropicMessages{}.RouteRequests)
+	}
+
+	a := r.Group("/admin")
+	a.Use(middleware.AdminAuthMiddleware())
+	{
+		a.GET("/keys", controllers.AdminKeys{}.List)
+		a.POST("/keys", controllers.AdminKeys{}.Create)
+		a.GET("/keys/:key", controllers.AdminKeys{}.Get)
+		a.PATCH("/keys/:key", controllers.AdminKeys{}.Update)
+		a.POST("/keys/:key/rotate", controllers.AdminKeys{}.Rotate)
+		a.DELETE("/keys/:key", controllers.AdminKeys{}.Revoke)
+	}
+
+	r.GET("/health", controllers.Health{}.GetHealth)
 	r.GET("/metrics", controllers.Metrics{}.GetMetrics)
 
 	return r
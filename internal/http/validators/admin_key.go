@@ -0,0 +1,168 @@
+package validators
+
+import (
+	"net/http"
+	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminKey represents the payload of the requests which create or update a virtual key
+type AdminKey struct {
+	VirtualKey             string                   `json:"virtual_key" binding:"omitempty,lowercase,max=64"`
+	Provider               string                   `json:"provider" binding:"omitempty,oneof=openai anthropic gemini openai_compatible azure_openai"`
+	ApiKey                 string                   `json:"api_key"`
+	ApiKeys                []models.UpstreamKey     `json:"api_keys" binding:"omitempty,dive"`
+	Balancing              string                   `json:"balancing" binding:"omitempty,oneof=round_robin weighted least_in_flight"`
+	BaseURL                string                   `json:"base_url"`
+	Headers                map[string]string        `json:"headers"`
+	Deployments            map[string]string        `json:"deployments"`
+	ApiVersion             string                   `json:"api_version"`
+	Targets                []models.Target          `json:"targets" binding:"omitempty,dive"`
+	ModelAliases           map[string]models.Target `json:"model_aliases"`
+	AllowedModels          []string                 `json:"allowed_models"`
+	DeniedModels           []string                 `json:"denied_models"`
+	MaxRequests            int                      `json:"max_requests" binding:"min=0"`
+	MaxTokens              int                      `json:"max_tokens" binding:"min=0"`
+	MaxSpendUSD            float64                  `json:"max_spend_usd" binding:"min=0"`
+	Window                 string                   `json:"window"`
+	MaxMessages            int                      `json:"max_messages" binding:"min=0"`
+	MaxMessageChars        int                      `json:"max_message_chars" binding:"min=0"`
+	MaxInputTokens         int                      `json:"max_input_tokens" binding:"min=0"`
+	MaxBodyBytes           int                      `json:"max_body_bytes" binding:"min=0"`
+	Cache                  *bool                    `json:"cache"`
+	SemanticCache          *bool                    `json:"semantic_cache"`
+	SemanticCacheThreshold float64                  `json:"semantic_cache_threshold" binding:"min=0,max=1"`
+}
+
+// Validate validates the payload of a new virtual key, which must be routed either to a provider or to targets
+func (a AdminKey) Validate(c *gin.Context) models.Model {
+	a.bind(c)
+
+	if _, ok := utils.ExtractVirtualKey("Bearer " + a.VirtualKey); a.VirtualKey != "" && !ok {
+		panic(errors.Validation{}.GetError("virtual_key may only contain letters, digits, '_' and '-'", http.StatusBadRequest))
+	}
+
+	key := models.VirtualKey{Key: a.VirtualKey}
+	a.Apply(&key)
+	if err := key.Validate(); err != nil {
+		panic(errors.Validation{}.GetError(err.Error(), http.StatusBadRequest))
+	}
+	return key
+}
+
+// ValidateUpdate validates the payload of a virtual key update, all fields are optional
+func (a AdminKey) ValidateUpdate(c *gin.Context) AdminKey {
+	a.bind(c)
+	if err := models.ValidateModelAliases(a.ModelAliases); err != nil {
+		panic(errors.Validation{}.GetError(err.Error(), http.StatusBadRequest))
+	}
+	return a
+}
+
+// Apply sets the fields given in the payload on the virtual key, the model aliases and lists given replace the previous ones.
+// Routing to a provider and routing to targets replace each other, as do an api key and a pool of api keys.
+// The headers and deployments given replace the previous ones
+func (a AdminKey) Apply(key *models.VirtualKey) {
+	if len(a.Targets) > 0 {
+		key.Targets = a.Targets
+		key.Provider = ""
+		key.ApiKey = ""
+		key.ApiKeys = nil
+		key.Balancing = ""
+		key.BaseURL = ""
+		key.Headers = nil
+		key.Deployments = nil
+		key.ApiVersion = ""
+	}
+	if a.Provider != "" {
+		key.Provider = a.Provider
+		key.Targets = nil
+		// Only the openai_compatible and azure_openai providers are sent to a base URL
+		if a.Provider != "openai_compatible" && a.Provider != "azure_openai" {
+			key.BaseURL = ""
+			key.Headers = nil
+		}
+		if a.Provider != "azure_openai" {
+			key.Deployments = nil
+			key.ApiVersion = ""
+		}
+	}
+	if a.ApiKey != "" {
+		key.ApiKey = a.ApiKey
+		key.ApiKeys = nil
+		key.Targets = nil
+	}
+	if len(a.ApiKeys) > 0 {
+		key.ApiKeys = a.ApiKeys
+		key.ApiKey = ""
+		key.Targets = nil
+	}
+	if a.Balancing != "" {
+		key.Balancing = a.Balancing
+	}
+	if a.BaseURL != "" {
+		key.BaseURL = a.BaseURL
+		key.Targets = nil
+	}
+	if a.Headers != nil {
+		key.Headers = a.Headers
+	}
+	if a.Deployments != nil {
+		key.Deployments = a.Deployments
+	}
+	if a.ApiVersion != "" {
+		key.ApiVersion = a.ApiVersion
+	}
+	if a.ModelAliases != nil {
+		key.ModelAliases = a.ModelAliases
+	}
+	if a.AllowedModels != nil {
+		key.AllowedModels = a.AllowedModels
+	}
+	if a.DeniedModels != nil {
+		key.DeniedModels = a.DeniedModels
+	}
+	if a.MaxRequests > 0 {
+		key.MaxRequests = a.MaxRequests
+	}
+	if a.MaxTokens > 0 {
+		key.MaxTokens = a.MaxTokens
+	}
+	if a.MaxSpendUSD > 0 {
+		key.MaxSpendUSD = a.MaxSpendUSD
+	}
+	if a.Window != "" {
+		key.Window = a.Window
+	}
+	if a.MaxMessages > 0 {
+		key.MaxMessages = a.MaxMessages
+	}
+	if a.MaxMessageChars > 0 {
+		key.MaxMessageChars = a.MaxMessageChars
+	}
+	if a.MaxInputTokens > 0 {
+		key.MaxInputTokens = a.MaxInputTokens
+	}
+	if a.MaxBodyBytes > 0 {
+		key.MaxBodyBytes = a.MaxBodyBytes
+	}
+	if a.Cache != nil {
+		key.Cache = *a.Cache
+	}
+	if a.SemanticCache != nil {
+		key.SemanticCache = *a.SemanticCache
+	}
+	if a.SemanticCacheThreshold > 0 {
+		key.SemanticCacheThreshold = a.SemanticCacheThreshold
+	}
+}
+
+// bind binds the JSON body and validates the fields
+func (a *AdminKey) bind(c *gin.Context) {
+	if err := c.ShouldBindJSON(a); err != nil {
+		panic(errors.Validation{}.GetError(err.Error(), http.StatusBadRequest))
+	}
+}
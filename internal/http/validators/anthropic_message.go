@@ -0,0 +1,188 @@
+package validators
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AnthropicMessage represents the Anthropic messages request payload
+type AnthropicMessage struct {
+	// From body
+	Model         string                  `json:"model" binding:"required"`
+	MaxTokens     int64                   `json:"max_tokens" binding:"required,min=1"`
+	Messages      []AnthropicInputMessage `json:"messages" binding:"required,min=1,dive"`
+	System        models.AnthropicContent `json:"system"`
+	StopSequences []string                `json:"stop_sequences"`
+	Temperature   *float64                `json:"temperature" binding:"omitempty,min=0,max=1"`
+	TopP          *float64                `json:"top_p" binding:"omitempty,min=0,max=1"`
+	TopK          *int64                  `json:"top_k" binding:"omitempty,min=1"`
+	Stream        bool                    `json:"stream"`
+	Tools         []AnthropicTool         `json:"tools" binding:"omitempty,max=128,dive"`
+	ToolChoice    *AnthropicToolChoice    `json:"tool_choice"`
+	Metadata      struct {
+		UserID string `json:"user_id"`
+	} `json:"metadata"`
+
+	// From headers
+	AuthToken string `header:"Authorization"`
+}
+
+// AnthropicInputMessage represents a single message of the Anthropic messages request
+type AnthropicInputMessage struct {
+	Role    string                  `json:"role" binding:"required,oneof=user assistant"`
+	Content models.AnthropicContent `json:"content" binding:"required,dive"`
+}
+
+// AnthropicTool represents a tool definition of the Anthropic messages request
+type AnthropicTool struct {
+	Name        string                 `json:"name" binding:"required,max=64"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"input_schema" binding:"required"`
+}
+
+// AnthropicToolChoice represents how the model chooses the tools to call in the Anthropic messages request
+type AnthropicToolChoice struct {
+	Type                   string `json:"type" binding:"required,oneof=auto any tool none"`
+	Name                   string `json:"name" binding:"required_if=Type tool"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use"`
+}
+
+// Validate validates the AnthropicMessage request and translates it to a proxy request.
+// The system prompt becomes a leading system message, which each provider maps to its own API.
+// The tool_use blocks become tool calls and the tool_result blocks become tool messages, as in the OpenAI function calling
+func (am AnthropicMessage) Validate(c *gin.Context) models.Model {
+	if err := c.ShouldBindJSON(&am); err != nil {
+		panic(errors.Validation{}.GetError(err.Error(), http.StatusBadRequest))
+	}
+	if err := c.ShouldBindHeader(&am); err != nil {
+		panic(errors.Validation{}.GetError(err.Error(), http.StatusBadRequest))
+	}
+
+	messages := make([]models.Message, 0, len(am.Messages)+1)
+	if len(am.System) > 0 {
+		messages = append(messages, models.Message{Role: "system", Content: toText(am.System)})
+	}
+	for _, m := range am.Messages {
+		messages = append(messages, toMessages(m)...)
+	}
+
+	proxyReq := GetProxyRequest(configs.FromContext(c), &ChatCompletion{
+		Messages:  messages,
+		Model:     am.Model,
+		Stream:    am.Stream,
+		AuthToken: am.AuthToken,
+	}).(models.ProxyRequest)
+	proxyReq.Options = models.ChatOptions{
+		Temperature: am.Temperature,
+		TopP:        am.TopP,
+		TopK:        am.TopK,
+		MaxTokens:   &am.MaxTokens,
+		Stop:        am.StopSequences,
+		User:        am.Metadata.UserID,
+	}
+	for _, tool := range am.Tools {
+		proxyReq.Options.Tools = append(proxyReq.Options.Tools, models.Tool{
+			Type: models.TOOL_TYPE_FUNCTION,
+			Function: models.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+	if choice := am.ToolChoice; choice != nil {
+		if choice.Type == "tool" && !hasTool(proxyReq.Options.Tools, choice.Name) {
+			panic(errors.Validation{}.GetError("tool_choice names the tool "+choice.Name+", which isn't in tools", http.StatusBadRequest))
+		}
+		proxyReq.Options.ToolChoice = choice.toToolChoice()
+		if choice.DisableParallelToolUse {
+			parallelToolCalls := false
+			proxyReq.Options.ParallelToolCalls = &parallelToolCalls
+		}
+	}
+	return proxyReq
+}
+
+// toToolChoice converts the Anthropic tool choice to the gateway tool choice
+func (tc AnthropicToolChoice) toToolChoice() *models.ToolChoice {
+	switch tc.Type {
+	case "tool":
+		return &models.ToolChoice{Function: tc.Name}
+	case "any":
+		return &models.ToolChoice{Mode: models.TOOL_CHOICE_REQUIRED}
+	case "none":
+		return &models.ToolChoice{Mode: models.TOOL_CHOICE_NONE}
+	default:
+		return &models.ToolChoice{Mode: models.TOOL_CHOICE_AUTO}
+	}
+}
+
+// toMessages converts an Anthropic message to the gateway messages.
+// The tool results of a user message become tool messages, which precede the text of the message.
+// The image and document blocks of a user message make its content multimodal
+func toMessages(m AnthropicInputMessage) []models.Message {
+	var messages []models.Message
+	var toolCalls []models.ToolCall
+	var parts []models.ContentPart
+	multimodal := false
+	for _, block := range m.Content {
+		switch {
+		case block.Type == models.ANTHROPIC_TEXT_BLOCK:
+			part, _ := block.ToContentPart()
+			parts = append(parts, part)
+		case (block.Type == models.ANTHROPIC_IMAGE_BLOCK || block.Type == models.ANTHROPIC_DOCUMENT_BLOCK) && m.Role == "user":
+			part, ok := block.ToContentPart()
+			if !ok {
+				panic(errors.Validation{}.GetError("unsupported "+block.Type+" source, images take a base64 or url source and documents a base64 one", http.StatusBadRequest))
+			}
+			parts = append(parts, part)
+			multimodal = true
+		case block.Type == models.ANTHROPIC_TOOL_USE_BLOCK && m.Role == "assistant":
+			if block.ID == "" || block.Name == "" {
+				panic(errors.Validation{}.GetError("tool_use blocks require an id and a name", http.StatusBadRequest))
+			}
+			arguments := "{}"
+			var input bytes.Buffer
+			if json.Compact(&input, block.Input) == nil {
+				arguments = input.String()
+			}
+			toolCalls = append(toolCalls, models.ToolCall{
+				ID:       block.ID,
+				Type:     models.TOOL_TYPE_FUNCTION,
+				Function: models.FunctionCall{Name: block.Name, Arguments: arguments},
+			})
+		case block.Type == models.ANTHROPIC_TOOL_RESULT_BLOCK && m.Role == "user":
+			if block.ToolUseID == "" {
+				panic(errors.Validation{}.GetError("tool_result blocks require a tool_use_id", http.StatusBadRequest))
+			}
+			messages = append(messages, models.Message{Role: "tool", Content: toText(block.Content), ToolCallID: block.ToolUseID})
+		default:
+			panic(errors.Validation{}.GetError("unsupported content block type: "+block.Type, http.StatusBadRequest))
+		}
+	}
+
+	if multimodal {
+		return append(messages, models.Message{Role: m.Role, Content: models.TextOf(parts), Parts: parts})
+	}
+	// A user message which only holds tool results has no text left
+	if text := m.Content.Text(); text != "" || len(messages) == 0 || len(toolCalls) > 0 {
+		messages = append(messages, models.Message{Role: m.Role, Content: text, ToolCalls: toolCalls})
+	}
+	return messages
+}
+
+// toText returns the text of the content, only text blocks are supported
+func toText(content models.AnthropicContent) string {
+	for _, block := range content {
+		if block.Type != models.ANTHROPIC_TEXT_BLOCK {
+			panic(errors.Validation{}.GetError("unsupported content block type: "+block.Type, http.StatusBadRequest))
+		}
+	}
+	return content.Text()
+}
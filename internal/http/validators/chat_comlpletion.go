@@ -1,21 +1,27 @@
 package validators
 
 import (
+	"fmt"
 	"net/http"
 	"qualifire-home-assignment/internal/configs"
 	"qualifire-home-assignment/internal/http/errors"
 	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/services"
 	"qualifire-home-assignment/internal/utils"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/cast"
 )
 
 // ChatCompletion represents the chat completion request payload
 type ChatCompletion struct {
 	// From body
-	Messages []models.Message `json:"messages" binding:"required,dive" validate:"dive,min=1,max=100"`
+	Messages []models.Message `json:"messages" binding:"required,dive"`
 	Model    string           `json:"model" binding:"required"`
+	Stream   bool             `json:"stream"`
 
 	// From headers
 	AuthToken string `header:"Authorization" validate:"required"`
@@ -40,23 +46,112 @@ func (cc ChatCompletion) Validate(c *gin.Context) models.Model {
 		panic(errors.Validation{}.GetError(err.Error(), http.StatusBadRequest))
 	}
 
-	return GetProxyRequest(&cc)
+	return GetProxyRequest(configs.FromContext(c), &cc)
 }
 
-// GetProxyRequest maps ChatCompletion to ProxyRequest based on virtual keys configuration
-func GetProxyRequest(cc *ChatCompletion) models.Model {
-	keyInfo, virtualKey := GetKeyInfo(cc)
-	return models.ProxyRequest{
-		Provider:   keyInfo["provider"].(string),
-		ApiKey:     keyInfo["api_key"].(string),
+// GetProxyRequest maps ChatCompletion to ProxyRequest based on the virtual keys of the config snapshot.
+// A model alias is resolved to its target, and the model must be allowed for the virtual key.
+// The request is routed to the primary target
+func GetProxyRequest(snapshot *configs.Snapshot, cc *ChatCompletion) models.Model {
+	keyInfo, virtualKey := GetKeyInfo(snapshot, cc)
+	targets, model := ResolveModel(snapshot, keyInfo, GetTargets(keyInfo), cc.Model)
+	ValidateModelAccess(keyInfo, model, targets)
+	ValidateContent(cc.Messages)
+	ValidateLimits(cc.Messages, model, services.GetRequestLimits(snapshot, virtualKey))
+	req := models.ProxyRequest{
 		Messages:   cc.Messages,
-		Model:      cc.Model,
+		Model:      model,
 		VirtualKey: virtualKey,
+		Stream:     cc.Stream,
 	}
+
+	// A single target is routed here with its model override, while the Fallback provider routes
+	// each target of a chain in turn, starting from the requested model
+	routed := req.ForTarget(targets[0])
+	if len(targets) > 1 {
+		routed.Model = model
+		routed.Targets = targets
+	}
+	return routed
+}
+
+// ValidateContent checks the multimodal content of the messages, images and documents are only accepted in user messages
+// and within the size limits
+func ValidateContent(messages []models.Message) {
+	maxImageBytes := configs.EnvInt("MAX_IMAGE_BYTES", "20971520")
+	maxDocumentBytes := configs.EnvInt("MAX_DOCUMENT_BYTES", "33554432")
+
+	for i, m := range messages {
+		for j, part := range m.Parts {
+			if part.Type != models.CONTENT_PART_TEXT && m.Role != "user" {
+				panic(errors.Validation{}.GetError(fmt.Sprintf("messages[%d].content[%d]: %s parts are only accepted in user messages", i, j, part.Type), http.StatusBadRequest))
+			}
+			if err := part.Validate(maxImageBytes, maxDocumentBytes); err != nil {
+				panic(errors.Validation{}.GetError(fmt.Sprintf("messages[%d].content[%d]: %s", i, j, err.Error()), http.StatusBadRequest))
+			}
+		}
+	}
+}
+
+// ValidateLimits checks the messages against the request size limits of the virtual key
+func ValidateLimits(messages []models.Message, model string, limits services.RequestLimits) {
+	if limits.MaxMessages > 0 && len(messages) > limits.MaxMessages {
+		panic(errors.Validation{}.GetLimitError("max_messages", limits.MaxMessages, len(messages), http.StatusBadRequest))
+	}
+
+	if limits.MaxMessageChars > 0 {
+		for i, m := range messages {
+			if chars := utf8.RuneCountInString(m.Content); chars > limits.MaxMessageChars {
+				e := errors.Validation{}.GetLimitError("max_message_chars", limits.MaxMessageChars, chars, http.StatusBadRequest)
+				e.Message = fmt.Sprintf("messages[%d]: %s", i, e.Message)
+				panic(e)
+			}
+		}
+	}
+
+	if limits.MaxInputTokens > 0 {
+		if tokens := utils.CalculateRequestTokens(messages, model); tokens > limits.MaxInputTokens {
+			panic(errors.Validation{}.GetLimitError("max_input_tokens", limits.MaxInputTokens, tokens, http.StatusBadRequest))
+		}
+	}
+}
+
+// GetTargets returns the ordered fallback chain of a virtual key.
+// A key which declares a single provider and api key (or pool of api keys) is treated as a chain of one target
+func GetTargets(keyInfo map[string]interface{}) []models.Target {
+	var targets []models.Target
+	if rawTargets, ok := keyInfo["targets"]; ok {
+		if err := mapstructure.Decode(rawTargets, &targets); err != nil {
+			panic(errors.GetError("MISSING_CONFIGURATIONS", "virtual key targets are malformed: "+err.Error(), http.StatusInternalServerError))
+		}
+	} else {
+		target := models.Target{
+			Provider:   cast.ToString(keyInfo["provider"]),
+			ApiKey:     cast.ToString(keyInfo["api_key"]),
+			Balancing:  cast.ToString(keyInfo["balancing"]),
+			BaseURL:    cast.ToString(keyInfo["base_url"]),
+			ApiVersion: cast.ToString(keyInfo["api_version"]),
+		}
+		if err := mapstructure.Decode(keyInfo["api_keys"], &target.ApiKeys); err != nil {
+			panic(errors.GetError("MISSING_CONFIGURATIONS", "virtual key api_keys are malformed: "+err.Error(), http.StatusInternalServerError))
+		}
+		if err := mapstructure.Decode(keyInfo["headers"], &target.Headers); err != nil {
+			panic(errors.GetError("MISSING_CONFIGURATIONS", "virtual key headers are malformed: "+err.Error(), http.StatusInternalServerError))
+		}
+		if err := mapstructure.Decode(keyInfo["deployments"], &target.Deployments); err != nil {
+			panic(errors.GetError("MISSING_CONFIGURATIONS", "virtual key deployments are malformed: "+err.Error(), http.StatusInternalServerError))
+		}
+		targets = []models.Target{target}
+	}
+
+	if len(targets) == 0 {
+		panic(errors.GetError("MISSING_CONFIGURATIONS", "virtual key has no targets", http.StatusInternalServerError))
+	}
+	return targets
 }
 
-func GetKeyInfo(cc *ChatCompletion) (map[string]interface{}, string) {
-	virtualKeys := configs.Config("virtual_keys", "")
+func GetKeyInfo(snapshot *configs.Snapshot, cc *ChatCompletion) (map[string]interface{}, string) {
+	virtualKeys := snapshot.Config("virtual_keys", "")
 	if virtualKeys != "" {
 		virtualKey, ok := utils.ExtractVirtualKey(cc.AuthToken)
 		// If the extraction fails, the Authorization header format is wrong
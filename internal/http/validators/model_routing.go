@@ -0,0 +1,82 @@
+package validators
+
+import (
+	"fmt"
+	"net/http"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/models"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+// ResolveModel resolves the requested model when it's an alias of the virtual key or a global alias, the aliases
+// of the key taking precedence. An alias replaces the targets of the key by its own target, which falls back to
+// the api key (and base URL) the virtual key holds for its provider. Other models are returned unchanged, with the targets of the key
+func ResolveModel(snapshot *configs.Snapshot, keyInfo map[string]interface{}, targets []models.Target, model string) ([]models.Target, string) {
+	alias := strings.ToLower(model)
+	target, ok := getModelAliases(keyInfo["model_aliases"], "virtual key model_aliases")[alias]
+	if !ok {
+		target, ok = getModelAliases(snapshot.Config("model_aliases", ""), "model_aliases")[alias]
+	}
+	if !ok {
+		return targets, model
+	}
+
+	if !target.IsRouted() {
+		for _, keyTarget := range targets {
+			if keyTarget.Provider == target.Provider {
+				target.ApiKey, target.ApiKeys, target.Balancing = keyTarget.ApiKey, keyTarget.ApiKeys, keyTarget.Balancing
+				target.BaseURL, target.Headers = keyTarget.BaseURL, keyTarget.Headers
+				target.Deployments, target.ApiVersion = keyTarget.Deployments, keyTarget.ApiVersion
+				break
+			}
+		}
+		if !target.IsRouted() {
+			panic(errors.Validation{}.GetError(fmt.Sprintf("model %s routes to %s, which the virtual key has no api key for", model, target.Provider), http.StatusBadRequest))
+		}
+	}
+	return []models.Target{target}, target.Model
+}
+
+// ValidateModelAccess checks the model, and the models the targets override it with, against the allow and deny lists
+// of the virtual key. A disallowed model is rejected with a 403 before any provider is called
+func ValidateModelAccess(keyInfo map[string]interface{}, model string, targets []models.Target) {
+	var access struct {
+		AllowedModels []string `mapstructure:"allowed_models"`
+		DeniedModels  []string `mapstructure:"denied_models"`
+	}
+	if err := mapstructure.Decode(keyInfo, &access); err != nil {
+		panic(errors.GetError("MISSING_CONFIGURATIONS", "virtual key model lists are malformed: "+err.Error(), http.StatusInternalServerError))
+	}
+
+	requested := []string{model}
+	for _, target := range targets {
+		if target.Model != "" {
+			requested = append(requested, target.Model)
+		}
+	}
+	for _, m := range requested {
+		if !models.IsModelAllowed(m, access.AllowedModels, access.DeniedModels) {
+			panic(errors.GetError("MODEL_NOT_ALLOWED", fmt.Sprintf("model %s isn't allowed for this virtual key", m), http.StatusForbidden))
+		}
+	}
+}
+
+// getModelAliases decodes the model aliases of the configuration, by lowercase alias since the configuration keys are
+// case-insensitive
+func getModelAliases(config interface{}, name string) map[string]models.Target {
+	var aliases map[string]models.Target
+	if config == nil || config == "" {
+		return aliases
+	}
+	if err := mapstructure.Decode(config, &aliases); err != nil {
+		panic(errors.GetError("MISSING_CONFIGURATIONS", name+" are malformed: "+err.Error(), http.StatusInternalServerError))
+	}
+	lowercase := make(map[string]models.Target, len(aliases))
+	for alias, target := range aliases {
+		lowercase[strings.ToLower(alias)] = target
+	}
+	return lowercase
+}
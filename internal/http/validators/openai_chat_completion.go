@@ -0,0 +1,52 @@
+package validators
+
+import (
+	"net/http"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAIChatCompletion represents the OpenAI chat completion request payload
+type OpenAIChatCompletion struct {
+	ChatCompletion
+	models.ChatOptions
+}
+
+// Validate validates the OpenAIChatCompletion request.
+// Unlike ChatCompletion, validation errors are raised, so they are rendered in the OpenAI error format
+func (oc OpenAIChatCompletion) Validate(c *gin.Context) models.Model {
+	if err := c.ShouldBindJSON(&oc); err != nil {
+		panic(errors.Validation{}.GetError(err.Error(), http.StatusBadRequest))
+	}
+
+	if err := c.ShouldBindHeader(&oc.ChatCompletion); err != nil {
+		panic(errors.Validation{}.GetError(err.Error(), http.StatusBadRequest))
+	}
+
+	if format := oc.ResponseFormat; format != nil && format.Type == "json_schema" {
+		if name, _ := format.JSONSchema["name"].(string); name == "" {
+			panic(errors.Validation{}.GetError("response_format.json_schema.name is required", http.StatusBadRequest))
+		}
+	}
+
+	if choice := oc.ToolChoice; choice != nil && choice.Function != "" && !hasTool(oc.Tools, choice.Function) {
+		panic(errors.Validation{}.GetError("tool_choice names the function "+choice.Function+", which isn't in tools", http.StatusBadRequest))
+	}
+
+	proxyReq := GetProxyRequest(configs.FromContext(c), &oc.ChatCompletion).(models.ProxyRequest)
+	proxyReq.Options = oc.ChatOptions
+	return proxyReq
+}
+
+// hasTool tells whether a function of the given name is declared in the tools
+func hasTool(tools []models.Tool, name string) bool {
+	for _, tool := range tools {
+		if tool.Function.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	ANTHROPIC_MESSAGE_TYPE      = "message"
+	ANTHROPIC_TEXT_BLOCK        = "text"
+	ANTHROPIC_TOOL_USE_BLOCK    = "tool_use"
+	ANTHROPIC_TOOL_RESULT_BLOCK = "tool_result"
+	ANTHROPIC_IMAGE_BLOCK       = "image"
+	ANTHROPIC_DOCUMENT_BLOCK    = "document"
+)
+
+// AnthropicContent represents the content of an Anthropic message, which is either a string or a list of content blocks
+type AnthropicContent []AnthropicContentBlock
+
+// AnthropicContentBlock represents a single content block of an Anthropic message
+type AnthropicContentBlock struct {
+	Type string `json:"type" binding:"required"`
+	Text string `json:"text,omitempty"`
+
+	// Source is the data of an image or document block
+	Source *AnthropicSource `json:"source,omitempty"`
+
+	// ID, Name and Input describe a tool_use block, Input is the JSON object of the arguments
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// ToolUseID, Content and IsError describe a tool_result block
+	ToolUseID string           `json:"tool_use_id,omitempty"`
+	Content   AnthropicContent `json:"content,omitempty"`
+	IsError   bool             `json:"is_error,omitempty"`
+}
+
+// AnthropicSource represents the data of an image or document block, either base64 encoded or referenced by a URL
+type AnthropicSource struct {
+	Type      string `json:"type" binding:"required,oneof=base64 url"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// NewAnthropicPartBlock converts a content part to an Anthropic content block
+func NewAnthropicPartBlock(part ContentPart) AnthropicContentBlock {
+	switch part.Type {
+	case CONTENT_PART_IMAGE:
+		if dataURL, ok := ParseDataURL(part.ImageURL.URL); ok {
+			return AnthropicContentBlock{Type: ANTHROPIC_IMAGE_BLOCK, Source: &AnthropicSource{Type: "base64", MediaType: dataURL.MediaType, Data: dataURL.Data}}
+		}
+		return AnthropicContentBlock{Type: ANTHROPIC_IMAGE_BLOCK, Source: &AnthropicSource{Type: "url", URL: part.ImageURL.URL}}
+	case CONTENT_PART_FILE:
+		dataURL, _ := ParseDataURL(part.File.FileData)
+		return AnthropicContentBlock{Type: ANTHROPIC_DOCUMENT_BLOCK, Source: &AnthropicSource{Type: "base64", MediaType: dataURL.MediaType, Data: dataURL.Data}}
+	default:
+		return AnthropicContentBlock{Type: ANTHROPIC_TEXT_BLOCK, Text: part.Text}
+	}
+}
+
+// ToContentPart converts a text, image or document block to a content part, ok is false for the other blocks
+// and for the documents referenced by a URL, which only Anthropic can read
+func (b AnthropicContentBlock) ToContentPart() (part ContentPart, ok bool) {
+	switch {
+	case b.Type == ANTHROPIC_TEXT_BLOCK:
+		return ContentPart{Type: CONTENT_PART_TEXT, Text: b.Text}, true
+	case b.Type == ANTHROPIC_IMAGE_BLOCK && b.Source != nil && b.Source.Type == "url":
+		return ContentPart{Type: CONTENT_PART_IMAGE, ImageURL: &ImageURL{URL: b.Source.URL}}, true
+	case b.Type == ANTHROPIC_IMAGE_BLOCK && b.Source != nil:
+		dataURL := DataURL{MediaType: b.Source.MediaType, Data: b.Source.Data}
+		return ContentPart{Type: CONTENT_PART_IMAGE, ImageURL: &ImageURL{URL: dataURL.String()}}, true
+	case b.Type == ANTHROPIC_DOCUMENT_BLOCK && b.Source != nil && b.Source.Type == "base64":
+		dataURL := DataURL{MediaType: b.Source.MediaType, Data: b.Source.Data}
+		return ContentPart{Type: CONTENT_PART_FILE, File: &File{FileData: dataURL.String()}}, true
+	default:
+		return ContentPart{}, false
+	}
+}
+
+// UnmarshalJSON accepts both a string content and a list of content blocks
+func (c *AnthropicContent) UnmarshalJSON(b []byte) error {
+	var text string
+	if err := json.Unmarshal(b, &text); err == nil {
+		*c = AnthropicContent{{Type: ANTHROPIC_TEXT_BLOCK, Text: text}}
+		return nil
+	}
+
+	var blocks []AnthropicContentBlock
+	if err := json.Unmarshal(b, &blocks); err != nil {
+		return err
+	}
+	*c = blocks
+	return nil
+}
+
+// Text returns the text of the text blocks, joined by new lines
+func (c AnthropicContent) Text() string {
+	texts := make([]string, 0, len(c))
+	for _, block := range c {
+		if block.Type == ANTHROPIC_TEXT_BLOCK {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// NewToolUseBlock converts a tool call to a tool_use block, arguments which aren't a valid JSON are passed as a JSON string
+func NewToolUseBlock(toolCall ToolCall) AnthropicContentBlock {
+	input := json.RawMessage(toolCall.Function.Arguments)
+	if toolCall.Function.Arguments == "" {
+		input = json.RawMessage("{}")
+	} else if !json.Valid(input) {
+		input, _ = json.Marshal(toolCall.Function.Arguments)
+	}
+	return AnthropicContentBlock{Type: ANTHROPIC_TOOL_USE_BLOCK, ID: toolCall.ID, Name: toolCall.Function.Name, Input: input}
+}
+
+// AnthropicMessage represents the Anthropic messages response
+type AnthropicMessage struct {
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	Role         string                  `json:"role"`
+	Model        string                  `json:"model"`
+	Content      []AnthropicContentBlock `json:"content"`
+	StopReason   *string                 `json:"stop_reason"`
+	StopSequence *string                 `json:"stop_sequence"`
+	Usage        AnthropicUsage          `json:"usage"`
+}
+
+// AnthropicUsage represents the usage block of the Anthropic messages response, where the input tokens exclude the cached ones
+type AnthropicUsage struct {
+	InputTokens          int `json:"input_tokens"`
+	OutputTokens         int `json:"output_tokens"`
+	CacheReadInputTokens int `json:"cache_read_input_tokens"`
+}
+
+// NewAnthropicUsage converts the gateway usage to the Anthropic usage block
+func NewAnthropicUsage(usage Usage) AnthropicUsage {
+	return AnthropicUsage{
+		InputTokens:          usage.PromptTokens - usage.CachedTokens,
+		OutputTokens:         usage.CompletionTokens,
+		CacheReadInputTokens: usage.CachedTokens,
+	}
+}
+
+// ToAnthropicStopReason maps a normalized finish reason to the Anthropic stop reason
+func ToAnthropicStopReason(finishReason string) *string {
+	stopReason := "end_turn"
+	switch finishReason {
+	case "length":
+		stopReason = "max_tokens"
+	case "tool_calls":
+		stopReason = "tool_use"
+	}
+	return &stopReason
+}
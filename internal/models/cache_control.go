@@ -0,0 +1,64 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CACHE_HIT          = "HIT"
+	CACHE_SEMANTIC_HIT = "SEMANTIC_HIT"
+	CACHE_MISS         = "MISS"
+)
+
+// CacheControl represents how a request uses the response cache
+type CacheControl struct {
+	// Lookup tells whether the response may be served from the cache
+	Lookup bool
+	// Store tells whether the response of the provider is stored in the cache
+	Store bool
+	// MaxAge is the maximum age of a cached response the request accepts, zero means any age
+	MaxAge time.Duration
+	// SemanticThreshold is the minimum similarity of a semantic cache hit, zero disables the semantic cache
+	SemanticThreshold float64
+	// Sampled opts in to caching the requests which set a non-zero temperature or top_p
+	Sampled bool
+}
+
+// IsCacheHit tells whether the cache status is an exact or a semantic hit
+func IsCacheHit(status string) bool {
+	return status == CACHE_HIT || status == CACHE_SEMANTIC_HIT
+}
+
+// Enabled tells whether the request uses the cache at all
+func (c CacheControl) Enabled() bool {
+	return c.Lookup || c.Store
+}
+
+// ParseCacheControl returns the cache control of a request from its Cache-Control header.
+// enabled is whether the virtual key opted in to the cache, a request can also opt in with max-age.
+// no-cache skips the lookup but stores the fresh response, no-store bypasses the cache entirely,
+// and allow-sampled caches the request even if it isn't deterministic
+func ParseCacheControl(header string, enabled bool) CacheControl {
+	control := CacheControl{Lookup: enabled, Store: enabled}
+	for _, directive := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(strings.ToLower(directive)), "=")
+		switch name {
+		case "max-age":
+			seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err != nil || seconds < 0 {
+				continue
+			}
+			control.Lookup, control.Store = seconds > 0, true
+			control.MaxAge = time.Duration(seconds) * time.Second
+		case "no-cache":
+			control.Lookup = false
+		case "allow-sampled":
+			control.Sampled = true
+		case "no-store":
+			return CacheControl{}
+		}
+	}
+	return control
+}
@@ -0,0 +1,57 @@
+package models
+
+const (
+	CHAT_COMPLETION_OBJECT       = "chat.completion"
+	CHAT_COMPLETION_CHUNK_OBJECT = "chat.completion.chunk"
+)
+
+// ChatCompletion represents the OpenAI chat completion response
+type ChatCompletion struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   *ChatCompletionUsage   `json:"usage,omitempty"`
+}
+
+// ChatCompletionChoice represents a single choice of the OpenAI chat completion response.
+// Chunks of a streamed response carry a Delta instead of a Message
+type ChatCompletionChoice struct {
+	Index        int                    `json:"index"`
+	Message      *ChatCompletionMessage `json:"message,omitempty"`
+	Delta        *ChatCompletionMessage `json:"delta,omitempty"`
+	FinishReason *string                `json:"finish_reason"`
+	Logprobs     interface{}            `json:"logprobs"`
+}
+
+// ChatCompletionMessage represents the message generated by the model
+type ChatCompletionMessage struct {
+	Role      string     `json:"role,omitempty"`
+	Content   *string    `json:"content"`
+	Refusal   *string    `json:"refusal"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+}
+
+// ChatCompletionUsage represents the usage block of the OpenAI chat completion response
+type ChatCompletionUsage struct {
+	PromptTokens        int                       `json:"prompt_tokens"`
+	CompletionTokens    int                       `json:"completion_tokens"`
+	TotalTokens         int                       `json:"total_tokens"`
+	PromptTokensDetails ChatCompletionTokenDetail `json:"prompt_tokens_details"`
+}
+
+// ChatCompletionTokenDetail represents the breakdown of the prompt tokens
+type ChatCompletionTokenDetail struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// NewChatCompletionUsage converts the gateway usage to the OpenAI usage block
+func NewChatCompletionUsage(usage Usage) *ChatCompletionUsage {
+	return &ChatCompletionUsage{
+		PromptTokens:        usage.PromptTokens,
+		CompletionTokens:    usage.CompletionTokens,
+		TotalTokens:         usage.TotalTokens(),
+		PromptTokensDetails: ChatCompletionTokenDetail{CachedTokens: usage.CachedTokens},
+	}
+}
@@ -0,0 +1,77 @@
+package models
+
+import "encoding/json"
+
+// ChatOptions represents the optional OpenAI generation parameters of a chat completion request
+type ChatOptions struct {
+	Temperature         *float64        `json:"temperature,omitempty" binding:"omitempty,min=0,max=2"`
+	TopP                *float64        `json:"top_p,omitempty" binding:"omitempty,min=0,max=1"`
+	MaxTokens           *int64          `json:"max_tokens,omitempty" binding:"omitempty,min=1"`
+	MaxCompletionTokens *int64          `json:"max_completion_tokens,omitempty" binding:"omitempty,min=1"`
+	Stop                StopSequences   `json:"stop,omitempty" binding:"max=4"`
+	N                   *int64          `json:"n,omitempty" binding:"omitempty,min=1,max=128"`
+	Seed                *int64          `json:"seed,omitempty"`
+	ResponseFormat      *ResponseFormat `json:"response_format,omitempty"`
+	User                string          `json:"user,omitempty"`
+	StreamOptions       *StreamOptions  `json:"stream_options,omitempty"`
+	// TopK is supported by Anthropic only, the other providers ignore it
+	TopK *int64 `json:"top_k,omitempty" binding:"omitempty,min=1"`
+
+	// Tools are the functions the model may call
+	Tools             []Tool      `json:"tools,omitempty" binding:"omitempty,max=128,dive"`
+	ToolChoice        *ToolChoice `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool       `json:"parallel_tool_calls,omitempty"`
+}
+
+// StreamOptions represents the options of a streamed response
+type StreamOptions struct {
+	// IncludeUsage asks for a last chunk which carries the usage of the whole request
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// ResponseFormat represents the format the model must answer with
+type ResponseFormat struct {
+	Type       string                 `json:"type" binding:"required,oneof=text json_object json_schema"`
+	JSONSchema map[string]interface{} `json:"json_schema,omitempty" binding:"required_if=Type json_schema"`
+}
+
+// StopSequences represents the stop parameter, which is either a single sequence or a list of sequences
+type StopSequences []string
+
+// UnmarshalJSON accepts both a single stop sequence and a list of stop sequences
+func (s *StopSequences) UnmarshalJSON(b []byte) error {
+	var sequence string
+	if err := json.Unmarshal(b, &sequence); err == nil {
+		*s = StopSequences{sequence}
+		return nil
+	}
+
+	var sequences []string
+	if err := json.Unmarshal(b, &sequences); err != nil {
+		return err
+	}
+	*s = sequences
+	return nil
+}
+
+// GetMaxTokens returns the maximum number of generated tokens, max_completion_tokens takes precedence over max_tokens
+func (o ChatOptions) GetMaxTokens() *int64 {
+	if o.MaxCompletionTokens != nil {
+		return o.MaxCompletionTokens
+	}
+	return o.MaxTokens
+}
+
+// IsDeterministic tells whether the request sets a zero temperature. The providers sample with a temperature of 1
+// when it's omitted, so the responses of the other requests vary from a call to another, whatever their top_p
+func (o ChatOptions) IsDeterministic() bool {
+	return o.Temperature != nil && *o.Temperature == 0
+}
+
+// GetN returns the number of choices to generate
+func (o ChatOptions) GetN() int64 {
+	if o.N == nil {
+		return 1
+	}
+	return *o.N
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	CONTENT_PART_TEXT  = "text"
+	CONTENT_PART_IMAGE = "image_url"
+	CONTENT_PART_FILE  = "file"
+
+	PDF_MEDIA_TYPE = "application/pdf"
+)
+
+// ImageMediaTypes are the image formats supported by all the providers
+var ImageMediaTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// ContentPart represents a single part of a multimodal message content, in the OpenAI content parts format
+type ContentPart struct {
+	Type     string    `json:"type" binding:"required,oneof=text image_url file"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty" binding:"required_if=Type image_url"`
+	File     *File     `json:"file,omitempty" binding:"required_if=Type file"`
+}
+
+// ImageURL represents an image, either a http(s) URL or a base64 data URL
+type ImageURL struct {
+	URL    string `json:"url" binding:"required"`
+	Detail string `json:"detail,omitempty" binding:"omitempty,oneof=auto low high"`
+}
+
+// File represents a document, given as a base64 data URL since files uploaded to a provider can't be shared with the others
+type File struct {
+	Filename string `json:"filename,omitempty"`
+	FileData string `json:"file_data" binding:"required"`
+}
+
+// DataURL represents a decoded base64 data URL, data:<media type>;base64,<data>
+type DataURL struct {
+	MediaType string
+	// Data is the base64 encoded data
+	Data string
+}
+
+// ParseDataURL parses a base64 data URL, ok is false for other URLs
+func ParseDataURL(url string) (dataURL DataURL, ok bool) {
+	rest, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return DataURL{}, false
+	}
+	header, data, ok := strings.Cut(rest, ",")
+	if !ok {
+		return DataURL{}, false
+	}
+	mediaType, ok := strings.CutSuffix(header, ";base64")
+	if !ok {
+		return DataURL{}, false
+	}
+	return DataURL{MediaType: mediaType, Data: data}, true
+}
+
+// String returns the data URL
+func (d DataURL) String() string {
+	return "data:" + d.MediaType + ";base64," + d.Data
+}
+
+// Size returns the size of the decoded data in bytes
+func (d DataURL) Size() int {
+	return base64.StdEncoding.DecodedLen(len(d.Data))
+}
+
+// Validate checks that the part holds a supported image or document within the size limits, in bytes
+func (p ContentPart) Validate(maxImageBytes int, maxDocumentBytes int) error {
+	switch p.Type {
+	case CONTENT_PART_IMAGE:
+		dataURL, ok := ParseDataURL(p.ImageURL.URL)
+		if !ok {
+			if !strings.HasPrefix(p.ImageURL.URL, "https://") && !strings.HasPrefix(p.ImageURL.URL, "http://") {
+				return fmt.Errorf("image_url.url must be a http(s) URL or a base64 data URL")
+			}
+			return nil
+		}
+		if !isImageMediaType(dataURL.MediaType) {
+			return fmt.Errorf("unsupported image type %s, expected one of %s", dataURL.MediaType, strings.Join(ImageMediaTypes, ", "))
+		}
+		if dataURL.Size() > maxImageBytes {
+			return fmt.Errorf("image of %d bytes exceeds the limit of %d bytes", dataURL.Size(), maxImageBytes)
+		}
+	case CONTENT_PART_FILE:
+		dataURL, ok := ParseDataURL(p.File.FileData)
+		if !ok || dataURL.MediaType != PDF_MEDIA_TYPE {
+			return fmt.Errorf("file.file_data must be a base64 data URL of a PDF document")
+		}
+		if dataURL.Size() > maxDocumentBytes {
+			return fmt.Errorf("document of %d bytes exceeds the limit of %d bytes", dataURL.Size(), maxDocumentBytes)
+		}
+	}
+	return nil
+}
+
+// isImageMediaType tells whether the image format is supported
+func isImageMediaType(mediaType string) bool {
+	for _, imageMediaType := range ImageMediaTypes {
+		if mediaType == imageMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// messageContent represents the content of a message, which is either a string or a list of content parts
+type messageContent struct {
+	text  string
+	parts []ContentPart
+}
+
+// UnmarshalJSON accepts a string content, a list of content parts or null
+func (c *messageContent) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(b, &c.text); err == nil {
+		return nil
+	}
+	return json.Unmarshal(b, &c.parts)
+}
+
+// TextOf returns the text of the content parts, joined by new lines
+func TextOf(parts []ContentPart) string {
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type == CONTENT_PART_TEXT {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,47 @@
+package models
+
+import "errors"
+
+const (
+	BALANCING_ROUND_ROBIN     = "round_robin"
+	BALANCING_WEIGHTED        = "weighted"
+	BALANCING_LEAST_IN_FLIGHT = "least_in_flight"
+)
+
+// UpstreamKey represents a single upstream api key of a key pool
+type UpstreamKey struct {
+	ApiKey string `json:"api_key" mapstructure:"api_key" binding:"required"`
+	// Weight is the share of the requests the key gets with the weighted balancing, 1 when zero
+	Weight int `json:"weight,omitempty" mapstructure:"weight" binding:"min=0"`
+}
+
+// ValidateKeyPool checks that the upstream keys of a pool are set and that its balancing is supported
+func ValidateKeyPool(keys []UpstreamKey, balancing string) error {
+	for _, key := range keys {
+		if key.ApiKey == "" {
+			return errors.New("every upstream key of api_keys requires an api_key")
+		}
+		if key.Weight < 0 {
+			return errors.New("weights of api_keys can't be negative")
+		}
+	}
+	switch balancing {
+	case "", BALANCING_ROUND_ROBIN, BALANCING_WEIGHTED, BALANCING_LEAST_IN_FLIGHT:
+		return nil
+	default:
+		return errors.New("balancing must be one of round_robin, weighted or least_in_flight")
+	}
+}
+
+// maskKeyPool returns a copy of the upstream keys whose api keys are masked
+func maskKeyPool(keys []UpstreamKey) []UpstreamKey {
+	if keys == nil {
+		return nil
+	}
+	masked := make([]UpstreamKey, len(keys))
+	for i, key := range keys {
+		key.ApiKey = MaskSecret(key.ApiKey)
+		masked[i] = key
+	}
+	return masked
+}
@@ -10,4 +10,7 @@ type LogEntry struct {
 	RequestBody  map[string]interface{} `json:"request,omitempty"`
 	ResponseBody map[string]interface{} `json:"response,omitempty"`
 	Error        string                 `json:"error,omitempty"`
+	// Attempt is the attempt number of the provider request, and RetryInMS the delay before its retry
+	Attempt   int   `json:"attempt,omitempty"`
+	RetryInMS int64 `json:"retry_in_ms,omitempty"`
 }
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidateModelAliases checks that every model alias routes to a provider and a model, with a well-formed key pool.
+// The api key of an alias is optional, the alias then uses the api key the virtual key holds for its provider
+func ValidateModelAliases(aliases map[string]Target) error {
+	for alias, target := range aliases {
+		if target.Provider == "" || target.Model == "" {
+			return fmt.Errorf("model alias %s requires a provider and a model", alias)
+		}
+		if err := ValidateKeyPool(target.ApiKeys, target.Balancing); err != nil {
+			return fmt.Errorf("model alias %s: %w", alias, err)
+		}
+		// The base URL of an openai_compatible or azure_openai alias may also come from the virtual key
+		inherited := target.BaseURL == "" && (target.Provider == "openai_compatible" || target.Provider == "azure_openai")
+		if err := target.ValidateUpstream(); err != nil && !inherited {
+			return fmt.Errorf("model alias %s: %w", alias, err)
+		}
+	}
+	return nil
+}
+
+// IsModelAllowed tells whether the model is allowed by the allow and deny lists of a virtual key.
+// The deny list takes precedence, and an empty allow list allows every model which isn't denied
+func IsModelAllowed(model string, allowed []string, denied []string) bool {
+	for _, pattern := range denied {
+		if MatchModel(pattern, model) {
+			return false
+		}
+	}
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, pattern := range allowed {
+		if MatchModel(pattern, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchModel matches a model name against a pattern, in which * matches any sequence of characters, e.g. gpt-4o*
+func MatchModel(pattern string, model string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == model
+	}
+	if !strings.HasPrefix(model, parts[0]) {
+		return false
+	}
+	model = model[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(model, part)
+		if i < 0 {
+			return false
+		}
+		model = model[i+len(part):]
+	}
+	return strings.HasSuffix(model, parts[len(parts)-1])
+}
+
+// maskModelAliases returns a copy of the model aliases whose api keys are masked
+func maskModelAliases(aliases map[string]Target) map[string]Target {
+	if aliases == nil {
+		return nil
+	}
+	masked := make(map[string]Target, len(aliases))
+	for alias, target := range aliases {
+		target.ApiKey = MaskSecret(target.ApiKey)
+		target.ApiKeys = maskKeyPool(target.ApiKeys)
+		target.Headers = maskHeaders(target.Headers)
+		masked[alias] = target
+	}
+	return masked
+}
@@ -1,16 +1,124 @@
 package models
 
+import "encoding/json"
+
 // ProxyRequest represents a request to be proxied to an external provider
 type ProxyRequest struct {
-	Provider   string    `json:"provider"`
-	ApiKey     string    `json:"api_key"`
-	Messages   []Message `json:"messages"`
-	Model      string    `json:"model"`
-	VirtualKey string    `json:"virtual_key"`
+	Provider string `json:"provider"`
+	ApiKey   string `json:"api_key"`
+	// ApiKeys is the pool of upstream api keys the request is balanced across, instead of ApiKey
+	ApiKeys   []UpstreamKey `json:"api_keys,omitempty"`
+	Balancing string        `json:"balancing,omitempty"`
+	// BaseURL and Headers route the request to an openai_compatible server or an azure_openai resource
+	BaseURL string            `json:"base_url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Deployments and ApiVersion route the request to the deployment of its model in an azure_openai resource
+	Deployments map[string]string `json:"deployments,omitempty"`
+	ApiVersion  string            `json:"api_version,omitempty"`
+	Messages    []Message         `json:"messages"`
+	Model       string            `json:"model"`
+	VirtualKey  string            `json:"virtual_key"`
+	Stream      bool              `json:"stream"`
+	// Options are the optional generation parameters, which each provider maps to its own API
+	Options ChatOptions `json:"options"`
+	// Targets is the ordered fallback chain of the virtual key, the first target is the primary one
+	Targets []Target `json:"targets,omitempty"`
+	// Cache is how the request uses the response cache
+	Cache CacheControl `json:"-"`
+	// Pooled tells that the request is sent with an upstream key of a pool, which moves to another key
+	// of the pool rather than retrying a rate limited one
+	Pooled bool `json:"-"`
 }
 
 // Message represents a single message in the chat completion request
 type Message struct {
-	Role    string `json:"role" binding:"required,oneof=system user assistant developer tool"`
-	Content string `json:"content" binding:"required,max=255"`
+	Role string `json:"role" binding:"required,oneof=system user assistant developer tool"`
+	// Content is the text of the message, the text parts of a multimodal content joined by new lines.
+	// It's optional for the assistant messages which only call tools
+	Content string `json:"content" binding:"required_without_all=ToolCalls Parts"`
+	// Parts is the multimodal content of the message, when the content is given as a list of content parts
+	Parts []ContentPart `json:"-" binding:"omitempty,dive"`
+	// ToolCalls are the functions called by an assistant message
+	ToolCalls []ToolCall `json:"tool_calls,omitempty" binding:"omitempty,dive"`
+	// ToolCallID is the call a tool message answers
+	ToolCallID string `json:"tool_call_id,omitempty" binding:"required_if=Role tool"`
+}
+
+// UnmarshalJSON accepts both a string content and a list of content parts
+func (m *Message) UnmarshalJSON(b []byte) error {
+	type message Message
+	raw := struct {
+		*message
+		Content messageContent `json:"content"`
+	}{message: (*message)(m)}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	m.Content = raw.Content.text
+	m.Parts = raw.Content.parts
+	if m.Parts != nil {
+		m.Content = TextOf(m.Parts)
+	}
+	return nil
+}
+
+// MarshalJSON encodes the content the way it was given, either as a string or as a list of content parts
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message
+	var content interface{} = m.Content
+	if m.Parts != nil {
+		content = m.Parts
+	}
+	return json.Marshal(struct {
+		message
+		Content interface{} `json:"content"`
+	}{message(m), content})
+}
+
+// Target represents a single upstream provider a virtual key can be routed to
+type Target struct {
+	Provider string `json:"provider" mapstructure:"provider" binding:"required,oneof=openai anthropic gemini openai_compatible azure_openai"`
+	// ApiKey is optional for the openai_compatible servers, which don't always require one
+	ApiKey string `json:"api_key,omitempty" mapstructure:"api_key" binding:"required_without_all=ApiKeys BaseURL"`
+	// ApiKeys is a pool of upstream api keys the requests are balanced across, instead of ApiKey
+	ApiKeys []UpstreamKey `json:"api_keys,omitempty" mapstructure:"api_keys" binding:"omitempty,dive"`
+	// Balancing is how a key of the pool is chosen: round_robin (default), weighted or least_in_flight
+	Balancing string `json:"balancing,omitempty" mapstructure:"balancing" binding:"omitempty,oneof=round_robin weighted least_in_flight"`
+	// BaseURL is the endpoint of an openai_compatible server, e.g. http://localhost:11434/v1 for Ollama,
+	// or of an azure_openai resource, e.g. https://my-resource.openai.azure.com
+	BaseURL string `json:"base_url,omitempty" mapstructure:"base_url" binding:"required_if=Provider openai_compatible,required_if=Provider azure_openai"`
+	// Headers are the custom headers sent to an openai_compatible server or an azure_openai resource
+	Headers map[string]string `json:"headers,omitempty" mapstructure:"headers"`
+	// Deployments are the azure_openai deployments by model, a model without a deployment is sent to the deployment of its name
+	Deployments map[string]string `json:"deployments,omitempty" mapstructure:"deployments"`
+	// ApiVersion is the azure_openai api-version, AZURE_OPENAI_API_VERSION when empty
+	ApiVersion string `json:"api_version,omitempty" mapstructure:"api_version"`
+	// Model overrides the requested model, when empty the requested model is used
+	Model string `json:"model,omitempty" mapstructure:"model"`
+}
+
+// ForTarget returns a copy of the request routed to the given target
+func (p ProxyRequest) ForTarget(target Target) ProxyRequest {
+	p.Provider = target.Provider
+	p.ApiKey = target.ApiKey
+	p.ApiKeys = target.ApiKeys
+	p.Balancing = target.Balancing
+	p.BaseURL = target.BaseURL
+	p.Headers = target.Headers
+	p.Deployments = target.Deployments
+	p.ApiVersion = target.ApiVersion
+	if target.Model != "" {
+		p.Model = target.Model
+	}
+	p.Targets = nil
+	return p
+}
+
+// ForApiKey returns a copy of the request sent with the given upstream api key of its pool
+func (p ProxyRequest) ForApiKey(apiKey string) ProxyRequest {
+	p.ApiKey = apiKey
+	p.ApiKeys = nil
+	p.Pooled = true
+	return p
 }
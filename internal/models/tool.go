@@ -0,0 +1,92 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+const (
+	TOOL_TYPE_FUNCTION = "function"
+
+	TOOL_CHOICE_NONE     = "none"
+	TOOL_CHOICE_AUTO     = "auto"
+	TOOL_CHOICE_REQUIRED = "required"
+)
+
+// Tool represents a function the model may call, in the OpenAI function calling format
+type Tool struct {
+	Type     string             `json:"type" binding:"required,eq=function"`
+	Function FunctionDefinition `json:"function"`
+}
+
+// FunctionDefinition describes a function the model may call
+type FunctionDefinition struct {
+	Name        string `json:"name" binding:"required,max=64"`
+	Description string `json:"description,omitempty"`
+	// Parameters is the JSON schema of the function arguments
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Strict     *bool                  `json:"strict,omitempty"`
+}
+
+// ToolCall represents a call of a function by the model
+type ToolCall struct {
+	// Index identifies the call across the chunks of a stream, it's only set on streamed fragments
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty" binding:"required"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+// FunctionCall represents the function called by the model and its arguments
+type FunctionCall struct {
+	Name string `json:"name,omitempty" binding:"required"`
+	// Arguments are JSON encoded, streamed fragments carry a part of them
+	Arguments string `json:"arguments"`
+}
+
+// ToolChoice represents how the model chooses the tools to call, either a mode (none, auto or required)
+// or a single function the model must call
+type ToolChoice struct {
+	Mode     string
+	Function string
+}
+
+// toolChoiceFunction represents a tool choice which forces a function
+type toolChoiceFunction struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name string `json:"name"`
+	} `json:"function"`
+}
+
+// UnmarshalJSON accepts both a tool choice mode and a function to call
+func (t *ToolChoice) UnmarshalJSON(b []byte) error {
+	var mode string
+	if err := json.Unmarshal(b, &mode); err == nil {
+		if mode != TOOL_CHOICE_NONE && mode != TOOL_CHOICE_AUTO && mode != TOOL_CHOICE_REQUIRED {
+			return errors.New("tool_choice must be none, auto, required or a function")
+		}
+		*t = ToolChoice{Mode: mode}
+		return nil
+	}
+
+	var function toolChoiceFunction
+	if err := json.Unmarshal(b, &function); err != nil {
+		return err
+	}
+	if function.Type != TOOL_TYPE_FUNCTION || function.Function.Name == "" {
+		return errors.New("tool_choice must name the function to call")
+	}
+	*t = ToolChoice{Function: function.Function.Name}
+	return nil
+}
+
+// MarshalJSON encodes the tool choice the way it was requested
+func (t ToolChoice) MarshalJSON() ([]byte, error) {
+	if t.Function == "" {
+		return json.Marshal(t.Mode)
+	}
+	function := toolChoiceFunction{Type: TOOL_TYPE_FUNCTION}
+	function.Function.Name = t.Function
+	return json.Marshal(function)
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"errors"
+	"net/url"
+)
+
+// IsRouted tells whether the target has an upstream to send the requests to: an api key or a pool of api keys,
+// or the base URL of an openai_compatible server, since the self-hosted servers don't always require an api key
+func (t Target) IsRouted() bool {
+	return t.ApiKey != "" || len(t.ApiKeys) > 0 || (t.Provider == "openai_compatible" && t.BaseURL != "")
+}
+
+// ValidateUpstream checks the base URL, the headers and the deployments of the target. The openai_compatible and
+// azure_openai providers require an absolute http(s) base URL, while the other providers are sent to their own
+// endpoints and accept none. The deployments and the API version are only accepted by the azure_openai provider
+func (t Target) ValidateUpstream() error {
+	if t.Provider != "azure_openai" && (len(t.Deployments) > 0 || t.ApiVersion != "") {
+		return errors.New("deployments and api_version are only supported by the azure_openai provider")
+	}
+
+	switch t.Provider {
+	case "openai_compatible":
+		if t.BaseURL == "" {
+			return errors.New("the openai_compatible provider requires a base_url")
+		}
+	case "azure_openai":
+		if t.BaseURL == "" {
+			return errors.New("the azure_openai provider requires a base_url, the endpoint of its resource")
+		}
+		for model, deployment := range t.Deployments {
+			if deployment == "" {
+				return errors.New("the deployment of model " + model + " can't be empty")
+			}
+		}
+	default:
+		if t.BaseURL != "" || len(t.Headers) > 0 {
+			return errors.New("base_url and headers are only supported by the openai_compatible and azure_openai providers")
+		}
+		return nil
+	}
+
+	u, err := url.Parse(t.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("base_url must be an absolute http(s) URL, e.g. http://localhost:11434/v1")
+	}
+	for name := range t.Headers {
+		if name == "" {
+			return errors.New("header names can't be empty")
+		}
+	}
+	return nil
+}
+
+// maskHeaders returns a copy of the custom headers whose values are masked, since they usually carry credentials
+func maskHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(headers))
+	for name, value := range headers {
+		masked[name] = MaskSecret(value)
+	}
+	return masked
+}
@@ -0,0 +1,16 @@
+package models
+
+// Usage represents the token usage of a single request
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	// CachedTokens is the part of the prompt tokens which was served from the provider prompt cache
+	CachedTokens int `json:"cached_tokens,omitempty"`
+	// Estimated reports that the provider didn't return usage, so the tokens were counted by the gateway
+	Estimated bool `json:"estimated,omitempty"`
+}
+
+// TotalTokens returns the sum of prompt and completion tokens
+func (u Usage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// VirtualKey represents the configuration of a virtual key, as declared under virtual_keys in the keys configuration
+type VirtualKey struct {
+	Key      string `json:"virtual_key,omitempty" mapstructure:"-"`
+	Provider string `json:"provider,omitempty" mapstructure:"provider"`
+	ApiKey   string `json:"api_key,omitempty" mapstructure:"api_key"`
+	// ApiKeys is a pool of upstream api keys the requests are balanced across, instead of ApiKey
+	ApiKeys   []UpstreamKey `json:"api_keys,omitempty" mapstructure:"api_keys"`
+	Balancing string        `json:"balancing,omitempty" mapstructure:"balancing"`
+	// BaseURL and Headers route the key to an openai_compatible server or an azure_openai resource
+	BaseURL string            `json:"base_url,omitempty" mapstructure:"base_url"`
+	Headers map[string]string `json:"headers,omitempty" mapstructure:"headers"`
+	// Deployments and ApiVersion route the models of the key to the deployments of an azure_openai resource
+	Deployments map[string]string `json:"deployments,omitempty" mapstructure:"deployments"`
+	ApiVersion  string            `json:"api_version,omitempty" mapstructure:"api_version"`
+	Targets     []Target          `json:"targets,omitempty" mapstructure:"targets"`
+
+	// ModelAliases are the model names, e.g. fast or smart, which route to a concrete provider, model and api key.
+	// They take precedence over the global model_aliases
+	ModelAliases map[string]Target `json:"model_aliases,omitempty" mapstructure:"model_aliases"`
+	// AllowedModels and DeniedModels restrict the models the key may use, * matches any sequence of characters
+	AllowedModels []string `json:"allowed_models,omitempty" mapstructure:"allowed_models"`
+	DeniedModels  []string `json:"denied_models,omitempty" mapstructure:"denied_models"`
+
+	// Quota limits of the key, zero values fall back to the global limits
+	MaxRequests int     `json:"max_requests,omitempty" mapstructure:"max_requests"`
+	MaxTokens   int     `json:"max_tokens,omitempty" mapstructure:"max_tokens"`
+	MaxSpendUSD float64 `json:"max_spend_usd,omitempty" mapstructure:"max_spend_usd"`
+	Window      string  `json:"window,omitempty" mapstructure:"window"`
+
+	// Request size limits of the key, zero values fall back to the global limits
+	MaxMessages     int `json:"max_messages,omitempty" mapstructure:"max_messages"`
+	MaxMessageChars int `json:"max_message_chars,omitempty" mapstructure:"max_message_chars"`
+	MaxInputTokens  int `json:"max_input_tokens,omitempty" mapstructure:"max_input_tokens"`
+	MaxBodyBytes    int `json:"max_body_bytes,omitempty" mapstructure:"max_body_bytes"`
+
+	// Cache opts the key in to the response cache
+	Cache bool `json:"cache,omitempty" mapstructure:"cache"`
+	// SemanticCache opts the key in to the semantic cache, whose threshold falls back to the global one when zero
+	SemanticCache          bool    `json:"semantic_cache,omitempty" mapstructure:"semantic_cache"`
+	SemanticCacheThreshold float64 `json:"semantic_cache_threshold,omitempty" mapstructure:"semantic_cache_threshold"`
+}
+
+// Validate checks that the key is routed either to a provider or to targets, and that its limits are well-formed
+func (v VirtualKey) Validate() error {
+	if len(v.Targets) == 0 {
+		if err := v.target().ValidateUpstream(); v.Provider != "" && err != nil {
+			return err
+		}
+		if v.Provider == "" || !v.target().IsRouted() {
+			return errors.New("either provider and api_key (or api_keys) or targets are required")
+		}
+	}
+	if err := ValidateKeyPool(v.ApiKeys, v.Balancing); err != nil {
+		return err
+	}
+	for _, target := range v.Targets {
+		if err := target.ValidateUpstream(); target.Provider != "" && err != nil {
+			return err
+		}
+		if target.Provider == "" || !target.IsRouted() {
+			return errors.New("every target requires a provider and an api_key (or api_keys)")
+		}
+		if err := ValidateKeyPool(target.ApiKeys, target.Balancing); err != nil {
+			return err
+		}
+	}
+	if err := ValidateModelAliases(v.ModelAliases); err != nil {
+		return err
+	}
+	if v.MaxRequests < 0 || v.MaxTokens < 0 || v.MaxSpendUSD < 0 ||
+		v.MaxMessages < 0 || v.MaxMessageChars < 0 || v.MaxInputTokens < 0 || v.MaxBodyBytes < 0 {
+		return errors.New("limits can't be negative")
+	}
+	if v.SemanticCacheThreshold < 0 || v.SemanticCacheThreshold > 1 {
+		return errors.New("semantic_cache_threshold must be between 0 and 1")
+	}
+	if v.Window != "" {
+		if _, err := time.ParseDuration(v.Window); err != nil {
+			return errors.New("window must be a duration, e.g. 30m or 24h")
+		}
+	}
+	return nil
+}
+
+// Masked returns a copy of the virtual key whose upstream api keys are masked, so it can be safely returned to clients
+func (v VirtualKey) Masked() VirtualKey {
+	v.ApiKey = MaskSecret(v.ApiKey)
+	v.ApiKeys = maskKeyPool(v.ApiKeys)
+	v.Headers = maskHeaders(v.Headers)
+	if v.Targets != nil {
+		targets := make([]Target, len(v.Targets))
+		for i, target := range v.Targets {
+			target.ApiKey = MaskSecret(target.ApiKey)
+			target.ApiKeys = maskKeyPool(target.ApiKeys)
+			target.Headers = maskHeaders(target.Headers)
+			targets[i] = target
+		}
+		v.Targets = targets
+	}
+	v.ModelAliases = maskModelAliases(v.ModelAliases)
+	return v
+}
+
+// target returns the single target of a key routed to a provider
+func (v VirtualKey) target() Target {
+	return Target{
+		Provider:    v.Provider,
+		ApiKey:      v.ApiKey,
+		ApiKeys:     v.ApiKeys,
+		Balancing:   v.Balancing,
+		BaseURL:     v.BaseURL,
+		Headers:     v.Headers,
+		Deployments: v.Deployments,
+		ApiVersion:  v.ApiVersion,
+	}
+}
+
+// Fingerprint returns a short stable hash of a secret, which tells apart the secrets without revealing them
+func Fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:8])
+}
+
+// MaskSecret keeps only the last 4 characters of a secret, short secrets are masked entirely
+func MaskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) <= 8 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", len(secret)-4) + secret[len(secret)-4:]
+}
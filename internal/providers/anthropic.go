@@ -1,19 +1,25 @@
 package providers
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"qualifire-home-assignment/internal/configs"
-	"qualifire-home-assignment/internal/utils"
 	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/models"
+	"strings"
 )
 
 const (
 	VERSION      = "v1"
 	MESSAGES_URI = "messages"
+
+	// ANTHROPIC_API_VERSION is the default version of the Anthropic API, sent in the anthropic-version header
+	ANTHROPIC_API_VERSION = "2023-06-01"
 )
 
 // Anthropic provider implementation
@@ -21,58 +27,428 @@ type Anthropic struct {
 	ProviderBase
 }
 
+// anthropicResponse represents the Anthropic messages response
+type anthropicResponse struct {
+	ID         string                  `json:"id"`
+	Role       string                  `json:"role"`
+	Content    models.AnthropicContent `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      anthropicUsage          `json:"usage"`
+}
+
+// anthropicRequest represents the Anthropic messages request
+type anthropicRequest struct {
+	Model         string                 `json:"model"`
+	MaxTokens     int64                  `json:"max_tokens"`
+	Messages      []anthropicMessage     `json:"messages"`
+	System        string                 `json:"system,omitempty"`
+	Stream        bool                   `json:"stream,omitempty"`
+	Temperature   *float64               `json:"temperature,omitempty"`
+	TopP          *float64               `json:"top_p,omitempty"`
+	TopK          *int64                 `json:"top_k,omitempty"`
+	StopSequences []string               `json:"stop_sequences,omitempty"`
+	Metadata      *anthropicMetadata     `json:"metadata,omitempty"`
+	Tools         []anthropicTool        `json:"tools,omitempty"`
+	ToolChoice    map[string]interface{} `json:"tool_choice,omitempty"`
+}
+
+// anthropicMetadata represents the metadata of the Anthropic messages request
+type anthropicMetadata struct {
+	UserID string `json:"user_id"`
+}
+
+// anthropicError represents the error body of the Anthropic API
+type anthropicError struct {
+	Type  string `json:"type"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicMessage represents a single message of the Anthropic messages request,
+// the content is a string unless the message holds tool blocks
+type anthropicMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+// anthropicTool represents a tool definition of the Anthropic messages request
+type anthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+// anthropicUsage represents the Anthropic usage block, where the input tokens exclude the cached prompt tokens
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// anthropicStreamEvent represents a single server-sent event of the Anthropic messages stream
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		Model string         `json:"model"`
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	ContentBlock models.AnthropicContentBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
 // SendRequest sends a request to the Anthropic provider and returns the response
-func (a Anthropic) SendRequest() *Response {
-	var err error
-	var res *http.Response
-	var req *http.Request
-
-	body := map[string]interface{}{
-		"model":      a.Request.Model,
-		"max_tokens": 200,
-		"messages":   a.Request.Messages,
-	}
-	b, _ := json.Marshal(body)
-	httpClient := a.GetHttpClient()
-	req, err = http.NewRequest("POST", fmt.Sprintf("%s/%s/%s", configs.Env("ANTHROPIC_ENDPOINT", ""), VERSION, MESSAGES_URI), bytes.NewBuffer(b))
+func (a Anthropic) SendRequest(ctx context.Context) *Response {
+	res := a.doRequest(ctx, a.GetHttpClient(), false)
+	defer closeBody(res.Body)
+
+	var result anthropicResponse
+	err := json.NewDecoder(res.Body).Decode(&result)
 	if err != nil {
-		return nil
+		panic(errors.ApiProvider{}.GetError("failed to decode the Anthropic response: "+err.Error(), http.StatusBadGateway))
 	}
-	req.Header.Set("x-api-key", a.Request.ApiKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("anthropic-version", a.Request.Model)
 
-	res, err = httpClient.Do(req)
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			panic(fmt.Sprintf("Failed to close Anthropic response body: %s", err.Error()))
+	var toolCalls toolCallsBuilder
+	for _, block := range result.Content {
+		if block.Type == models.ANTHROPIC_TOOL_USE_BLOCK {
+			toolCalls.add(len(toolCalls.calls), block.ID, block.Name, string(block.Input))
 		}
-	}(res.Body)
+	}
+
+	response := a.newResponse([]Choice{{
+		Message:      Message{Role: result.Role, Content: result.Content.Text(), ToolCalls: toolCalls.build()},
+		FinishReason: toFinishReason(result.StopReason),
+	}}, result.Usage.toUsage())
+	response.ID = result.ID
+	return response
+}
+
+// StreamRequest streams the Anthropic response chunks into the handler and returns the aggregated response
+func (a Anthropic) StreamRequest(ctx context.Context, handler StreamHandler) *Response {
+	var content strings.Builder
+	var toolCalls toolCallsBuilder
+	var usage anthropicUsage
+	var finishReason string
+	model := a.Request.Model
+	// toolIndexes maps the index of the tool_use blocks to the index of their tool call
+	toolIndexes := make(map[int]int)
+
+	res := a.doRequest(ctx, a.GetStreamHttpClient(), true)
+	defer closeBody(res.Body)
+
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			continue
+		}
+
+		switch event.Type {
+		case "message_start":
+			model = event.Message.Model
+			usage = event.Message.Usage
+		case "content_block_start":
+			if event.ContentBlock.Type != models.ANTHROPIC_TOOL_USE_BLOCK {
+				continue
+			}
+			toolIndexes[event.Index] = len(toolIndexes)
+			delta := toolCalls.add(toolIndexes[event.Index], event.ContentBlock.ID, event.ContentBlock.Name, "")
+			handler(StreamChunk{
+				Provider: ANTHROPIC,
+				Model:    model,
+				Delta:    Message{Role: "assistant", ToolCalls: []models.ToolCall{delta}},
+			})
+		case "content_block_delta":
+			if event.Delta.Type == "input_json_delta" {
+				index, ok := toolIndexes[event.Index]
+				if !ok {
+					continue
+				}
+				delta := toolCalls.add(index, "", "", event.Delta.PartialJSON)
+				handler(StreamChunk{
+					Provider: ANTHROPIC,
+					Model:    model,
+					Delta:    Message{Role: "assistant", ToolCalls: []models.ToolCall{delta}},
+				})
+				continue
+			}
+			content.WriteString(event.Delta.Text)
+			handler(StreamChunk{
+				Provider: ANTHROPIC,
+				Model:    model,
+				Delta:    Message{Role: "assistant", Content: event.Delta.Text},
+			})
+		case "message_delta":
+			// The output tokens of the message delta are cumulative
+			usage.OutputTokens = event.Usage.OutputTokens
+			finishReason = toFinishReason(event.Delta.StopReason)
+			handler(StreamChunk{
+				Provider:     ANTHROPIC,
+				Model:        model,
+				Delta:        Message{Role: "assistant"},
+				FinishReason: finishReason,
+			})
+		case "error":
+			panic(errors.ApiProvider{}.GetError(event.Error.Message, http.StatusBadGateway))
+		}
+	}
+
+	// A canceled context means the client went away, so the partial response is still accounted for
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		panic(errors.ApiProvider{}.GetError(err.Error(), transportErrorStatus(err)))
+	}
+
+	return a.newResponse([]Choice{{
+		Message:      Message{Role: "assistant", Content: content.String(), ToolCalls: toolCalls.build()},
+		FinishReason: finishReason,
+	}}, usage.toUsage())
+}
+
+// doRequest sends the request to the Anthropic messages endpoint and panics in case the provider failed
+func (a Anthropic) doRequest(ctx context.Context, httpClient *http.Client, stream bool) *http.Response {
+	b, _ := json.Marshal(a.toAnthropicRequest(stream))
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/%s/%s", configs.Env("ANTHROPIC_ENDPOINT", ""), VERSION, MESSAGES_URI), bytes.NewBuffer(b))
 	if err != nil {
-		panic(errors.ApiProvider{}.GetError(err.Error(), res.StatusCode))
+		panic(errors.ApiProvider{}.GetError(err.Error(), http.StatusInternalServerError))
 	}
+	req.Header.Set("x-api-key", a.Request.ApiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("anthropic-version", configs.Env("ANTHROPIC_VERSION", ANTHROPIC_API_VERSION))
 
-	var result map[string]interface{}
-	err = json.NewDecoder(res.Body).Decode(&result)
+	res, err := httpClient.Do(req)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to decode Anthropic response: %s", err.Error()))
+		panic(toTransportError(err))
 	}
 
 	if res.StatusCode != http.StatusOK {
-		panic(errors.ApiProvider{}.GetError(result["error"].(map[string]interface{})["message"].(string), res.StatusCode))
+		defer closeBody(res.Body)
+		var result anthropicError
+		if err = json.NewDecoder(res.Body).Decode(&result); err != nil || result.Error.Message == "" {
+			panic(errors.ApiProvider{}.GetError(res.Status, res.StatusCode))
+		}
+		panic(errors.ApiProvider{}.GetError(result.Error.Message, res.StatusCode))
+	}
+
+	return res
+}
+
+// toAnthropicRequest converts the proxy request to the Anthropic messages request.
+// Anthropic requires max_tokens, which defaults to ANTHROPIC_MAX_TOKENS when the client didn't set it
+func (a Anthropic) toAnthropicRequest(stream bool) anthropicRequest {
+	options := a.Request.Options
+	if options.GetN() > 1 {
+		panic(errors.ApiProvider{}.GetError("anthropic doesn't support generating several choices (n > 1)", http.StatusBadRequest))
+	}
+
+	system, messages := toAnthropicMessages(a.Request.Messages)
+	if len(messages) == 0 {
+		panic(errors.ApiProvider{}.GetError("anthropic requires at least one user or assistant message", http.StatusBadRequest))
+	}
+	request := anthropicRequest{
+		Model:         a.Request.Model,
+		MaxTokens:     int64(configs.EnvInt("ANTHROPIC_MAX_TOKENS", "4096")),
+		Messages:      messages,
+		System:        system,
+		Stream:        stream,
+		Temperature:   options.Temperature,
+		TopP:          options.TopP,
+		TopK:          options.TopK,
+		StopSequences: options.Stop,
+		ToolChoice:    toAnthropicToolChoice(options),
+	}
+	if maxTokens := options.GetMaxTokens(); maxTokens != nil {
+		request.MaxTokens = *maxTokens
+	}
+	if options.User != "" {
+		request.Metadata = &anthropicMetadata{UserID: options.User}
+	}
+	if len(options.Tools) > 0 {
+		request.Tools = toAnthropicTools(options.Tools)
+	}
+	return request
+}
+
+// toAnthropicMessages moves the system and developer messages to the top-level system prompt,
+// since the Anthropic messages only take the user and assistant roles.
+// Tool calls become tool_use blocks, and tool messages become tool_result blocks of a user message.
+// The images and documents of a multimodal content become image and document blocks,
+// and the consecutive messages of the same role are merged into a single turn
+func toAnthropicMessages(messages []models.Message) (string, []anthropicMessage) {
+	var system []string
+	conversation := make([]anthropicMessage, 0, len(messages))
+	for _, m := range messages {
+		switch m.Role {
+		case "system", "developer":
+			system = append(system, m.Content)
+		case "tool":
+			result := models.AnthropicContentBlock{
+				Type:      models.ANTHROPIC_TOOL_RESULT_BLOCK,
+				ToolUseID: m.ToolCallID,
+				Content:   models.AnthropicContent{{Type: models.ANTHROPIC_TEXT_BLOCK, Text: m.Content}},
+			}
+			// The results of the calls of an assistant message are merged into a single user turn
+			conversation = append(conversation, anthropicMessage{Role: "user", Content: []models.AnthropicContentBlock{result}})
+		case "assistant":
+			if len(m.ToolCalls) == 0 {
+				conversation = append(conversation, anthropicMessage{Role: m.Role, Content: m.Content})
+				continue
+			}
+			blocks := make([]models.AnthropicContentBlock, 0, len(m.ToolCalls)+1)
+			if m.Content != "" {
+				blocks = append(blocks, models.AnthropicContentBlock{Type: models.ANTHROPIC_TEXT_BLOCK, Text: m.Content})
+			}
+			for _, toolCall := range m.ToolCalls {
+				blocks = append(blocks, models.AnthropicContentBlock{
+					Type:  models.ANTHROPIC_TOOL_USE_BLOCK,
+					ID:    toolCall.ID,
+					Name:  toolCall.Function.Name,
+					Input: toToolInput(toolCall),
+				})
+			}
+			conversation = append(conversation, anthropicMessage{Role: m.Role, Content: blocks})
+		default:
+			if m.Parts == nil {
+				conversation = append(conversation, anthropicMessage{Role: m.Role, Content: m.Content})
+				continue
+			}
+			blocks := make([]models.AnthropicContentBlock, 0, len(m.Parts))
+			for _, part := range m.Parts {
+				blocks = append(blocks, models.NewAnthropicPartBlock(part))
+			}
+			conversation = append(conversation, anthropicMessage{Role: m.Role, Content: blocks})
+		}
 	}
+	return strings.Join(system, "\n\n"), mergeTurns(conversation)
+}
 
-	responseContent := result["content"].(string)
+// mergeTurns merges the consecutive messages of the same role into a single turn, since the Anthropic messages
+// alternate between the user and the assistant. Merged text contents are joined by a blank line, and contents
+// holding blocks are concatenated
+func mergeTurns(conversation []anthropicMessage) []anthropicMessage {
+	merged := make([]anthropicMessage, 0, len(conversation))
+	for _, m := range conversation {
+		last := len(merged) - 1
+		if last < 0 || merged[last].Role != m.Role {
+			merged = append(merged, m)
+			continue
+		}
 
-	// Calculate tokens: input + output
-	inputTokens := utils.CalculateRequestTokens(a.Request.Messages, a.Request.Model)
-	outputTokens := utils.CalculateResponseTokens(responseContent)
+		previousText, previousIsText := merged[last].Content.(string)
+		text, isText := m.Content.(string)
+		if previousIsText && isText {
+			merged[last].Content = previousText + "\n\n" + text
+			continue
+		}
+		merged[last].Content = append(toBlocks(merged[last].Content), toBlocks(m.Content)...)
+	}
+	return merged
+}
 
-	return &Response{
-		Choices: []Message{
-			{Role: result["role"].(string), Content: responseContent},
-		},
-		TokensUsed: inputTokens + outputTokens,
+// toBlocks returns the content of a message as content blocks
+func toBlocks(content interface{}) []models.AnthropicContentBlock {
+	if text, ok := content.(string); ok {
+		return []models.AnthropicContentBlock{{Type: models.ANTHROPIC_TEXT_BLOCK, Text: text}}
+	}
+	return content.([]models.AnthropicContentBlock)
+}
+
+// toToolInput returns the arguments of a tool call as the input object of a tool_use block
+func toToolInput(toolCall models.ToolCall) json.RawMessage {
+	if toolCall.Function.Arguments == "" {
+		return json.RawMessage("{}")
+	}
+	if !json.Valid([]byte(toolCall.Function.Arguments)) {
+		panic(errors.ApiProvider{}.GetError(fmt.Sprintf("the arguments of tool call %s aren't valid JSON", toolCall.ID), http.StatusBadRequest))
+	}
+	return json.RawMessage(toolCall.Function.Arguments)
+}
+
+// toAnthropicTools converts the function definitions to Anthropic tools, whose input schema is required
+func toAnthropicTools(tools []models.Tool) []anthropicTool {
+	anthropicTools := make([]anthropicTool, 0, len(tools))
+	for _, tool := range tools {
+		schema := tool.Function.Parameters
+		if schema == nil {
+			schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		anthropicTools = append(anthropicTools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		})
+	}
+	return anthropicTools
+}
+
+// toAnthropicToolChoice converts the tool choice and the parallel tool calls option to the Anthropic tool choice
+func toAnthropicToolChoice(options models.ChatOptions) map[string]interface{} {
+	if options.ToolChoice == nil && options.ParallelToolCalls == nil {
+		return nil
+	}
+
+	toolChoice := map[string]interface{}{"type": "auto"}
+	if choice := options.ToolChoice; choice != nil {
+		switch {
+		case choice.Function != "":
+			toolChoice = map[string]interface{}{"type": "tool", "name": choice.Function}
+		case choice.Mode == models.TOOL_CHOICE_NONE:
+			toolChoice = map[string]interface{}{"type": "none"}
+		case choice.Mode == models.TOOL_CHOICE_REQUIRED:
+			toolChoice = map[string]interface{}{"type": "any"}
+		}
+	}
+	if options.ParallelToolCalls != nil && !*options.ParallelToolCalls && toolChoice["type"] != "none" {
+		toolChoice["disable_parallel_tool_use"] = true
+	}
+	return toolChoice
+}
+
+// toUsage converts the Anthropic usage to the gateway usage, where the prompt tokens include the cached ones
+func (u anthropicUsage) toUsage() *models.Usage {
+	return &models.Usage{
+		PromptTokens:     u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens,
+		CompletionTokens: u.OutputTokens,
+		CachedTokens:     u.CacheReadInputTokens,
+	}
+}
+
+// toFinishReason maps Anthropic stop reasons to the normalized finish reasons
+func toFinishReason(stopReason string) string {
+	switch stopReason {
+	case "end_turn", "stop_sequence":
+		return "stop"
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	default:
+		return stopReason
+	}
+}
+
+// closeBody closes the provider response body
+func closeBody(body io.ReadCloser) {
+	err := body.Close()
+	if err != nil {
+		panic(fmt.Sprintf("Failed to close the provider response body: %s", err.Error()))
 	}
 }
@@ -0,0 +1,40 @@
+package providers
+
+import (
+	"net/url"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/models"
+	"strings"
+
+	"github.com/openai/openai-go/v2/option"
+)
+
+// AZURE_OPENAI_API_VERSION is the default api-version of the Azure OpenAI requests
+const AZURE_OPENAI_API_VERSION = "2024-10-21"
+
+// azureOpenAIOptions returns the client options which send an azure_openai request to the deployment of its model:
+// it's posted under /openai/deployments/{deployment} of the resource endpoint with the api-version query parameter,
+// and authenticated by the api-key header instead of a bearer token
+func azureOpenAIOptions(req models.ProxyRequest) []option.RequestOption {
+	apiVersion := req.ApiVersion
+	if apiVersion == "" {
+		apiVersion = configs.Env("AZURE_OPENAI_API_VERSION", AZURE_OPENAI_API_VERSION)
+	}
+	endpoint := strings.TrimSuffix(req.BaseURL, "/") + "/openai/deployments/" + url.PathEscape(azureDeployment(req)) + "/"
+
+	return []option.RequestOption{
+		option.WithBaseURL(endpoint),
+		option.WithQuery("api-version", apiVersion),
+		option.WithHeaderDel("Authorization"),
+		option.WithHeader("api-key", req.ApiKey),
+	}
+}
+
+// azureDeployment returns the deployment of the requested model. The deployments are looked up by lowercase model,
+// since the configuration keys are case-insensitive, and a model without a deployment is sent to the deployment of its name
+func azureDeployment(req models.ProxyRequest) string {
+	if deployment, ok := req.Deployments[strings.ToLower(req.Model)]; ok {
+		return deployment
+	}
+	return req.Model
+}
@@ -0,0 +1,199 @@
+package providers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/services"
+	"time"
+)
+
+// Cached provider implementation, which serves identical requests from the response cache
+// and stores the responses of the wrapped provider.
+// When the semantic cache is enabled, a request which misses the exact cache is served the response of the most
+// similar last user message of the same virtual key, model and conversation
+type Cached struct {
+	ProviderBase
+	provider Provider
+	key      string
+	// vector is the embedding of the last user message, and conversation the hash of the rest of the request,
+	// when the semantic cache is enabled
+	vector       []float64
+	conversation string
+	// hit is the cached response of the request, nil on a cache miss
+	hit        *Response
+	status     string
+	age        time.Duration
+	similarity float64
+}
+
+// NewCached looks the request up in the response cache and wraps the provider the request is routed to.
+// The context bounds the embedding of the last user message for the semantic cache
+func NewCached(ctx context.Context, req models.ProxyRequest) Cached {
+	cached := Cached{ProviderBase: ProviderBase{req}, key: cacheKey(req), status: models.CACHE_MISS}
+	if req.Cache.SemanticThreshold > 0 {
+		cached.vector = embedLastUserMessage(ctx, req.Messages)
+		cached.conversation = semanticConversation(req)
+	}
+
+	if req.Cache.Lookup {
+		if value, age, ok := services.GetCacheService().Get(cached.key, req.Cache.MaxAge); ok {
+			cached.hit, cached.status, cached.age = value.(*Response), models.CACHE_HIT, age
+		} else if cached.vector != nil {
+			value, similarity, age, ok := services.GetSemanticCacheService().Search(semanticScope(req), cached.conversation, cached.vector, req.Cache.SemanticThreshold, req.Cache.MaxAge)
+			if ok {
+				cached.hit, cached.status, cached.age, cached.similarity = value.(*Response), models.CACHE_SEMANTIC_HIT, age, similarity
+			}
+		}
+	}
+
+	req.Cache = models.CacheControl{}
+	cached.provider = Factory(req)
+	return cached
+}
+
+// Status returns HIT, SEMANTIC_HIT or MISS, and the age of the cached response on a hit
+func (c Cached) Status() (string, time.Duration) {
+	return c.status, c.age
+}
+
+// Similarity returns the similarity of the last user message with the cached one, on a semantic hit
+func (c Cached) Similarity() float64 {
+	return c.similarity
+}
+
+// SendRequest returns the cached response, or sends the request to the provider and caches its response
+func (c Cached) SendRequest(ctx context.Context) *Response {
+	if c.hit != nil {
+		result := *c.hit
+		return &result
+	}
+
+	result := c.provider.SendRequest(ctx)
+	c.store(result)
+	return result
+}
+
+// StreamRequest replays the cached response as a stream, or streams the provider response and caches it once complete
+func (c Cached) StreamRequest(ctx context.Context, handler StreamHandler) *Response {
+	if c.hit != nil {
+		c.replay(handler)
+		result := *c.hit
+		return &result
+	}
+
+	result := c.provider.StreamRequest(ctx, handler)
+	// The response of a stream the client canceled is partial
+	if ctx.Err() == nil {
+		c.store(result)
+	}
+	return result
+}
+
+// replay streams every cached choice as a single delta followed by its finish reason
+func (c Cached) replay(handler StreamHandler) {
+	for i, choice := range c.hit.Choices {
+		toolCalls := make([]models.ToolCall, len(choice.ToolCalls))
+		for j, toolCall := range choice.ToolCalls {
+			index := j
+			toolCall.Index = &index
+			toolCalls[j] = toolCall
+		}
+
+		handler(StreamChunk{
+			Provider: c.hit.Provider,
+			Model:    c.hit.Model,
+			Index:    i,
+			Delta:    Message{Role: "assistant", Content: choice.Content, ToolCalls: toolCalls},
+		})
+		handler(StreamChunk{
+			Provider:     c.hit.Provider,
+			Model:        c.hit.Model,
+			Index:        i,
+			Delta:        Message{Role: "assistant"},
+			FinishReason: choice.FinishReason,
+		})
+	}
+}
+
+// store caches the response, if the request allows it
+func (c Cached) store(result *Response) {
+	if !c.Request.Cache.Store || result == nil {
+		return
+	}
+	services.GetCacheService().Set(c.key, result)
+	if c.vector != nil {
+		services.GetSemanticCacheService().Add(semanticScope(c.Request), c.conversation, c.vector, result)
+	}
+}
+
+// embedLastUserMessage returns the embedding of the last user message, or nil if there is none or the embedder failed,
+// in which case the request only uses the exact cache
+func embedLastUserMessage(ctx context.Context, messages []models.Message) []float64 {
+	i := lastUserMessage(messages)
+	if i < 0 || messages[i].Content == "" {
+		return nil
+	}
+	vector, err := services.GetSemanticCacheService().Embed(ctx, messages[i].Content)
+	if err != nil {
+		log.Printf("Failed to embed the last user message for the semantic cache: %s", err.Error())
+		return nil
+	}
+	return vector
+}
+
+// lastUserMessage returns the index of the last user message, or -1 if there is none
+func lastUserMessage(messages []models.Message) int {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return i
+		}
+	}
+	return -1
+}
+
+// semanticScope returns the scope the semantic cache entries of the request are shared in: its virtual key and model
+func semanticScope(req models.ProxyRequest) string {
+	return req.VirtualKey + "\x00" + req.Model
+}
+
+// semanticConversation returns the hash of the request without its last user message: the system prompt, the earlier
+// turns, the tools and the generation parameters, which a semantic hit must share with the cached request
+func semanticConversation(req models.ProxyRequest) string {
+	if i := lastUserMessage(req.Messages); i >= 0 {
+		messages := make([]models.Message, 0, len(req.Messages)-1)
+		req.Messages = append(append(messages, req.Messages[:i]...), req.Messages[i+1:]...)
+	}
+	return cacheKey(req)
+}
+
+// cacheKey returns the hash of the normalized request: its virtual key, targets, model, messages and generation
+// parameters, so the keys never share their responses.
+// The parameters which don't change the generated response, and the upstream api keys and headers, are left out
+func cacheKey(req models.ProxyRequest) string {
+	options := req.Options
+	options.User = ""
+	options.StreamOptions = nil
+
+	targets := make([]models.Target, len(req.Targets))
+	for i, target := range req.Targets {
+		target.ApiKey = ""
+		target.ApiKeys = nil
+		target.Headers = nil
+		targets[i] = target
+	}
+
+	b, _ := json.Marshal(struct {
+		VirtualKey string
+		Provider   string
+		Model      string
+		Messages   []models.Message
+		Options    models.ChatOptions
+		Targets    []models.Target
+	}{req.VirtualKey, req.Provider, req.Model, req.Messages, options, targets})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,83 @@
+package providers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/services"
+)
+
+// Fallback provider implementation, which routes the request through the ordered targets of the virtual key
+// and moves to the next target whenever the current one fails with a retryable error
+type Fallback struct {
+	ProviderBase
+}
+
+// SendRequest sends the request to the first target that answers successfully
+func (f Fallback) SendRequest(ctx context.Context) *Response {
+	return f.try(ctx, func(provider Provider) *Response {
+		return provider.SendRequest(ctx)
+	}, nil)
+}
+
+// StreamRequest streams the response of the first target that answers successfully.
+// Once a target has streamed a chunk to the client, its failures can't be recovered by another target
+func (f Fallback) StreamRequest(ctx context.Context, handler StreamHandler) *Response {
+	started := false
+	return f.try(ctx, func(provider Provider) *Response {
+		return provider.StreamRequest(ctx, func(chunk StreamChunk) {
+			started = true
+			handler(chunk)
+		})
+	}, &started)
+}
+
+// try calls the targets in order until one of them answers, the last error is propagated to the caller
+func (f Fallback) try(ctx context.Context, call func(provider Provider) *Response, started *bool) *Response {
+	targets := f.Request.Targets
+	for i, target := range targets {
+		result, err := callProvider(Factory(f.Request.ForTarget(target)), call)
+		if err == nil {
+			return result
+		}
+
+		isLast := i == len(targets)-1
+		if isLast || !isRetryable(err) || ctx.Err() != nil || (started != nil && *started) {
+			panic(*err)
+		}
+
+		next := targets[i+1]
+		log.Printf("Provider %s failed with status %d for virtual key %s, failing over to %s", target.Provider, err.StatusCode, f.Request.VirtualKey, next.Provider)
+		services.GetMetricsService().RecordFailover(services.FailoverMetric{
+			VirtualKey:   f.Request.VirtualKey,
+			FromProvider: target.Provider,
+			ToProvider:   next.Provider,
+			Status:       err.StatusCode,
+		})
+	}
+
+	panic(errors.GetError("MISSING_CONFIGURATIONS", "virtual key has no targets", http.StatusInternalServerError))
+}
+
+// callProvider calls a single provider and converts its provider error to a return value
+func callProvider(provider Provider, call func(provider Provider) *Response) (result *Response, err *errors.ApiProvider) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(errors.ApiProvider)
+			if !ok {
+				panic(r)
+			}
+			err = &e
+		}
+	}()
+
+	return call(provider), nil
+}
+
+// isRetryable reports whether the error allows failing over to the next target
+func isRetryable(err *errors.ApiProvider) bool {
+	return err.StatusCode == http.StatusTooManyRequests ||
+		err.StatusCode == http.StatusRequestTimeout ||
+		err.StatusCode >= http.StatusInternalServerError
+}
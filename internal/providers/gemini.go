@@ -0,0 +1,487 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/models"
+	"strings"
+)
+
+// GEMINI_API_VERSION is the version of the Gemini API the requests are sent to
+const GEMINI_API_VERSION = "v1beta"
+
+// Gemini provider implementation
+type Gemini struct {
+	ProviderBase
+}
+
+// geminiRequest represents the Gemini generateContent request
+type geminiRequest struct {
+	Contents          []geminiContent         `json:"contents"`
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+	ToolConfig        *geminiToolConfig       `json:"toolConfig,omitempty"`
+}
+
+// geminiContent represents a single turn of the conversation, whose role is either user or model
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+// geminiPart represents a single part of a turn: a text, an inline image or document, a function call or its response
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	InlineData       *geminiBlob             `json:"inlineData,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+	// Thought marks the parts which summarize the reasoning of the model, they aren't part of the answer
+	Thought bool `json:"thought,omitempty"`
+}
+
+// geminiBlob represents base64 encoded inline data
+type geminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+// geminiFunctionCall represents a call of a function by the model
+type geminiFunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// geminiFunctionResponse represents the result of a function call, which Gemini requires to be an object
+type geminiFunctionResponse struct {
+	ID       string          `json:"id,omitempty"`
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+// geminiGenerationConfig represents the generation parameters of the Gemini request
+type geminiGenerationConfig struct {
+	Temperature        *float64    `json:"temperature,omitempty"`
+	TopP               *float64    `json:"topP,omitempty"`
+	TopK               *int64      `json:"topK,omitempty"`
+	MaxOutputTokens    *int64      `json:"maxOutputTokens,omitempty"`
+	StopSequences      []string    `json:"stopSequences,omitempty"`
+	CandidateCount     *int64      `json:"candidateCount,omitempty"`
+	Seed               *int64      `json:"seed,omitempty"`
+	ResponseMimeType   string      `json:"responseMimeType,omitempty"`
+	ResponseJsonSchema interface{} `json:"responseJsonSchema,omitempty"`
+}
+
+// geminiTool represents the functions the model may call
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+// geminiFunctionDeclaration represents a function the model may call, whose parameters are a JSON schema
+type geminiFunctionDeclaration struct {
+	Name                 string                 `json:"name"`
+	Description          string                 `json:"description,omitempty"`
+	ParametersJsonSchema map[string]interface{} `json:"parametersJsonSchema,omitempty"`
+}
+
+// geminiToolConfig represents how the model chooses the functions to call
+type geminiToolConfig struct {
+	FunctionCallingConfig struct {
+		Mode                 string   `json:"mode"`
+		AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+	} `json:"functionCallingConfig"`
+}
+
+// geminiResponse represents the Gemini generateContent response, and a single chunk of a streamed response
+type geminiResponse struct {
+	Candidates []struct {
+		Index        int           `json:"index"`
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata *geminiUsage `json:"usageMetadata"`
+	ResponseID    string       `json:"responseId"`
+	Error         *geminiError `json:"error"`
+}
+
+// geminiUsage represents the Gemini usage metadata, where the prompt tokens include the cached ones
+type geminiUsage struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+}
+
+// geminiError represents the error payload of the Gemini API
+type geminiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// SendRequest sends a request to the Gemini provider and returns the response
+func (g Gemini) SendRequest(ctx context.Context) *Response {
+	res := g.doRequest(ctx, g.GetHttpClient(), false)
+	defer closeBody(res.Body)
+
+	var result geminiResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		panic(errors.ApiProvider{}.GetError("failed to decode the Gemini response: "+err.Error(), http.StatusBadGateway))
+	}
+	if len(result.Candidates) == 0 {
+		panic(toGeminiBlockedError(result))
+	}
+
+	choices := make([]Choice, len(result.Candidates))
+	for _, candidate := range result.Candidates {
+		if candidate.Index >= len(choices) {
+			continue
+		}
+		var content strings.Builder
+		var toolCalls toolCallsBuilder
+		for _, part := range candidate.Content.Parts {
+			if part.FunctionCall != nil {
+				toolCalls.add(len(toolCalls.calls), toToolCallID(part.FunctionCall), part.FunctionCall.Name, string(part.FunctionCall.Args))
+			} else if !part.Thought {
+				content.WriteString(part.Text)
+			}
+		}
+		choices[candidate.Index] = Choice{
+			Message:      Message{Role: "assistant", Content: content.String(), ToolCalls: toolCalls.build()},
+			FinishReason: toGeminiFinishReason(candidate.FinishReason, len(toolCalls.calls) > 0),
+		}
+	}
+
+	response := g.newResponse(choices, result.UsageMetadata.toUsage())
+	response.ID = result.ResponseID
+	return response
+}
+
+// StreamRequest streams the Gemini response chunks into the handler and returns the aggregated response.
+// Gemini streams the text of the candidates in fragments, while their function calls come whole
+func (g Gemini) StreamRequest(ctx context.Context, handler StreamHandler) *Response {
+	var usage *geminiUsage
+	choices := make([]Choice, g.Request.Options.GetN())
+	contents := make([]strings.Builder, len(choices))
+	toolCalls := make([]toolCallsBuilder, len(choices))
+
+	res := g.doRequest(ctx, g.GetStreamHttpClient(), true)
+	defer closeBody(res.Body)
+
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk); err != nil {
+			continue
+		}
+		if chunk.Error != nil {
+			panic(errors.ApiProvider{}.GetError(chunk.Error.Message, http.StatusBadGateway))
+		}
+		if chunk.UsageMetadata != nil {
+			usage = chunk.UsageMetadata
+		}
+		if len(chunk.Candidates) == 0 && chunk.PromptFeedback.BlockReason != "" {
+			panic(toGeminiBlockedError(chunk))
+		}
+
+		for _, candidate := range chunk.Candidates {
+			index := candidate.Index
+			if index >= len(choices) {
+				continue
+			}
+
+			var text strings.Builder
+			var deltas []models.ToolCall
+			for _, part := range candidate.Content.Parts {
+				if part.FunctionCall != nil {
+					delta := toolCalls[index].add(len(toolCalls[index].calls), toToolCallID(part.FunctionCall), part.FunctionCall.Name, string(part.FunctionCall.Args))
+					deltas = append(deltas, delta)
+				} else if !part.Thought {
+					text.WriteString(part.Text)
+				}
+			}
+			contents[index].WriteString(text.String())
+
+			finishReason := ""
+			if candidate.FinishReason != "" {
+				finishReason = toGeminiFinishReason(candidate.FinishReason, len(toolCalls[index].calls) > 0)
+				choices[index].FinishReason = finishReason
+			}
+			handler(StreamChunk{
+				Provider:     GEMINI,
+				Model:        g.Request.Model,
+				Index:        index,
+				Delta:        Message{Role: "assistant", Content: text.String(), ToolCalls: deltas},
+				FinishReason: finishReason,
+			})
+		}
+	}
+
+	// A canceled context means the client went away, so the partial response is still accounted for
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		panic(errors.ApiProvider{}.GetError(err.Error(), transportErrorStatus(err)))
+	}
+
+	for i := range choices {
+		choices[i].Message = Message{Role: "assistant", Content: contents[i].String(), ToolCalls: toolCalls[i].build()}
+	}
+	return g.newResponse(choices, usage.toUsage())
+}
+
+// doRequest sends the request to the Gemini generateContent endpoint, or its streaming counterpart,
+// and panics in case the provider failed
+func (g Gemini) doRequest(ctx context.Context, httpClient *http.Client, stream bool) *http.Response {
+	method := "generateContent"
+	if stream {
+		method = "streamGenerateContent?alt=sse"
+	}
+	endpoint := fmt.Sprintf("%s/%s/models/%s:%s", configs.Env("GEMINI_ENDPOINT", "https://generativelanguage.googleapis.com"), GEMINI_API_VERSION, url.PathEscape(g.Request.Model), method)
+
+	b, _ := json.Marshal(g.toGeminiRequest())
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(b))
+	if err != nil {
+		panic(errors.ApiProvider{}.GetError(err.Error(), http.StatusInternalServerError))
+	}
+	req.Header.Set("x-goog-api-key", g.Request.ApiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		panic(toTransportError(err))
+	}
+
+	if res.StatusCode != http.StatusOK {
+		defer closeBody(res.Body)
+		var result geminiResponse
+		if err = json.NewDecoder(res.Body).Decode(&result); err != nil || result.Error == nil || result.Error.Message == "" {
+			panic(errors.ApiProvider{}.GetError(res.Status, res.StatusCode))
+		}
+		panic(errors.ApiProvider{}.GetError(result.Error.Message, res.StatusCode))
+	}
+
+	return res
+}
+
+// toGeminiRequest converts the proxy request to the Gemini generateContent request
+func (g Gemini) toGeminiRequest() geminiRequest {
+	options := g.Request.Options
+	system, contents := toGeminiContents(g.Request.Messages)
+	if len(contents) == 0 {
+		panic(errors.ApiProvider{}.GetError("gemini requires at least one user or assistant message", http.StatusBadRequest))
+	}
+
+	request := geminiRequest{
+		Contents:          contents,
+		SystemInstruction: system,
+		GenerationConfig: &geminiGenerationConfig{
+			Temperature:     options.Temperature,
+			TopP:            options.TopP,
+			TopK:            options.TopK,
+			MaxOutputTokens: options.GetMaxTokens(),
+			StopSequences:   options.Stop,
+			CandidateCount:  options.N,
+			Seed:            options.Seed,
+		},
+		ToolConfig: toGeminiToolConfig(options.ToolChoice),
+	}
+	if format := options.ResponseFormat; format != nil && format.Type != "text" {
+		request.GenerationConfig.ResponseMimeType = "application/json"
+		if format.Type == "json_schema" {
+			request.GenerationConfig.ResponseJsonSchema = format.JSONSchema["schema"]
+		}
+	}
+	if len(options.Tools) > 0 {
+		declarations := make([]geminiFunctionDeclaration, 0, len(options.Tools))
+		for _, tool := range options.Tools {
+			declarations = append(declarations, geminiFunctionDeclaration{
+				Name:                 tool.Function.Name,
+				Description:          tool.Function.Description,
+				ParametersJsonSchema: tool.Function.Parameters,
+			})
+		}
+		request.Tools = []geminiTool{{FunctionDeclarations: declarations}}
+	}
+	return request
+}
+
+// toGeminiContents moves the system and developer messages to the system instruction, and maps the user messages
+// to user turns and the assistant messages to model turns. Tool calls become function calls, and tool messages
+// become function responses of a user turn, named after the call they answer.
+// The consecutive turns of the same role are merged, so the function responses of a model turn are sent together
+func toGeminiContents(messages []models.Message) (*geminiContent, []geminiContent) {
+	var system *geminiContent
+	functionNames := make(map[string]string)
+	contents := make([]geminiContent, 0, len(messages))
+	for _, m := range messages {
+		var content geminiContent
+		switch m.Role {
+		case "system", "developer":
+			if system == nil {
+				system = &geminiContent{}
+			}
+			system.Parts = append(system.Parts, geminiPart{Text: m.Content})
+			continue
+		case "tool":
+			// Gemini names a function response after the function, so it must answer an earlier call
+			name, ok := functionNames[m.ToolCallID]
+			if !ok {
+				panic(errors.Validation{}.GetError(fmt.Sprintf("tool message %s answers no earlier tool call", m.ToolCallID), http.StatusBadRequest))
+			}
+			content = geminiContent{Role: "user", Parts: []geminiPart{{FunctionResponse: &geminiFunctionResponse{
+				Name:     name,
+				Response: toFunctionResponse(m.Content),
+			}}}}
+		case "assistant":
+			content = geminiContent{Role: "model"}
+			if m.Content != "" {
+				content.Parts = append(content.Parts, geminiPart{Text: m.Content})
+			}
+			for _, toolCall := range m.ToolCalls {
+				functionNames[toolCall.ID] = toolCall.Function.Name
+				content.Parts = append(content.Parts, geminiPart{FunctionCall: &geminiFunctionCall{
+					Name: toolCall.Function.Name,
+					Args: toToolInput(toolCall),
+				}})
+			}
+		default:
+			content = geminiContent{Role: "user", Parts: toGeminiParts(m)}
+		}
+
+		if last := len(contents) - 1; last >= 0 && contents[last].Role == content.Role {
+			contents[last].Parts = append(contents[last].Parts, content.Parts...)
+			continue
+		}
+		contents = append(contents, content)
+	}
+	return system, contents
+}
+
+// toGeminiParts converts the content of a user message to Gemini parts, images and documents are sent inline
+// since Gemini doesn't fetch image URLs
+func toGeminiParts(m models.Message) []geminiPart {
+	if m.Parts == nil {
+		return []geminiPart{{Text: m.Content}}
+	}
+
+	parts := make([]geminiPart, 0, len(m.Parts))
+	for _, part := range m.Parts {
+		switch part.Type {
+		case models.CONTENT_PART_IMAGE:
+			dataURL, ok := models.ParseDataURL(part.ImageURL.URL)
+			if !ok {
+				panic(errors.ApiProvider{}.GetError("gemini only accepts images given as base64 data URLs", http.StatusBadRequest))
+			}
+			parts = append(parts, geminiPart{InlineData: &geminiBlob{MimeType: dataURL.MediaType, Data: dataURL.Data}})
+		case models.CONTENT_PART_FILE:
+			dataURL, _ := models.ParseDataURL(part.File.FileData)
+			parts = append(parts, geminiPart{InlineData: &geminiBlob{MimeType: dataURL.MediaType, Data: dataURL.Data}})
+		default:
+			parts = append(parts, geminiPart{Text: part.Text})
+		}
+	}
+	return parts
+}
+
+// toFunctionResponse returns the content of a tool message as a function response object,
+// a content which isn't a JSON object is wrapped in {"content": ...}
+func toFunctionResponse(content string) json.RawMessage {
+	trimmed := strings.TrimSpace(content)
+	if strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+
+	var value interface{} = content
+	if json.Valid([]byte(trimmed)) {
+		value = json.RawMessage(trimmed)
+	}
+	b, _ := json.Marshal(map[string]interface{}{"content": value})
+	return b
+}
+
+// toGeminiToolConfig converts the tool choice to the Gemini function calling config
+func toGeminiToolConfig(choice *models.ToolChoice) *geminiToolConfig {
+	if choice == nil {
+		return nil
+	}
+
+	config := &geminiToolConfig{}
+	switch {
+	case choice.Function != "":
+		config.FunctionCallingConfig.Mode = "ANY"
+		config.FunctionCallingConfig.AllowedFunctionNames = []string{choice.Function}
+	case choice.Mode == models.TOOL_CHOICE_NONE:
+		config.FunctionCallingConfig.Mode = "NONE"
+	case choice.Mode == models.TOOL_CHOICE_REQUIRED:
+		config.FunctionCallingConfig.Mode = "ANY"
+	default:
+		config.FunctionCallingConfig.Mode = "AUTO"
+	}
+	return config
+}
+
+// toToolCallID returns the id of a function call. Gemini doesn't always identify the calls, they then get a random id
+func toToolCallID(call *geminiFunctionCall) string {
+	if call.ID != "" {
+		return call.ID
+	}
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return "call_" + hex.EncodeToString(b)
+}
+
+// toGeminiBlockedError returns the error of a response without candidates, whose prompt was usually blocked
+func toGeminiBlockedError(result geminiResponse) errors.ApiProvider {
+	if reason := result.PromptFeedback.BlockReason; reason != "" {
+		return errors.ApiProvider{}.GetError("gemini blocked the prompt: "+reason, http.StatusBadRequest)
+	}
+	return errors.ApiProvider{}.GetError("gemini returned no candidates", http.StatusBadGateway)
+}
+
+// toUsage converts the Gemini usage metadata to the gateway usage, the thinking tokens are billed as completion tokens.
+// It returns nil when Gemini didn't report usage, so the tokens are estimated
+func (u *geminiUsage) toUsage() *models.Usage {
+	if u == nil {
+		return nil
+	}
+	return &models.Usage{
+		PromptTokens:     u.PromptTokenCount,
+		CompletionTokens: u.CandidatesTokenCount + u.ThoughtsTokenCount,
+		CachedTokens:     u.CachedContentTokenCount,
+	}
+}
+
+// toGeminiFinishReason maps Gemini finish reasons to the normalized finish reasons
+func toGeminiFinishReason(finishReason string, calledTools bool) string {
+	switch finishReason {
+	case "STOP":
+		if calledTools {
+			return "tool_calls"
+		}
+		return "stop"
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return "content_filter"
+	default:
+		return strings.ToLower(finishReason)
+	}
+}
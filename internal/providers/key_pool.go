@@ -0,0 +1,89 @@
+package providers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/services"
+)
+
+// KeyPool provider implementation, which balances the request across the upstream api keys of its pool
+// and moves to another key of the pool whenever the chosen one is rate limited or its circuit breaker is open
+type KeyPool struct {
+	ProviderBase
+}
+
+// SendRequest sends the request with a key of the pool
+func (k KeyPool) SendRequest(ctx context.Context) *Response {
+	return k.try(ctx, func(provider Provider) *Response {
+		return provider.SendRequest(ctx)
+	}, nil)
+}
+
+// StreamRequest streams the response of the request sent with a key of the pool.
+// Once a chunk has been streamed to the client, another key can't take over
+func (k KeyPool) StreamRequest(ctx context.Context, handler StreamHandler) *Response {
+	started := false
+	return k.try(ctx, func(provider Provider) *Response {
+		return provider.StreamRequest(ctx, func(chunk StreamChunk) {
+			started = true
+			handler(chunk)
+		})
+	}, &started)
+}
+
+// try sends the request with the keys chosen by the pool until one is available, the last error is
+// propagated to the caller
+func (k KeyPool) try(ctx context.Context, call func(provider Provider) *Response, started *bool) *Response {
+	pool := services.GetKeyPoolService()
+	tried := make(map[string]bool)
+	var lastErr *errors.ApiProvider
+	for {
+		apiKey, ok := pool.Acquire(k.Request.Provider, k.Request.ApiKeys, k.Request.Balancing, tried)
+		if !ok {
+			break
+		}
+		tried[apiKey] = true
+
+		result, err := k.send(apiKey, call)
+		if err == nil {
+			return result
+		}
+
+		if !isKeyUnavailable(*err) || ctx.Err() != nil || (started != nil && *started) {
+			panic(*err)
+		}
+		lastErr = err
+		log.Printf("Upstream key %s of %s is unavailable for virtual key %s (%s), moving to another key of the pool", models.MaskSecret(apiKey), k.Request.Provider, k.Request.VirtualKey, err.Message)
+	}
+
+	if lastErr != nil {
+		panic(*lastErr)
+	}
+	panic(errors.GetError("MISSING_CONFIGURATIONS", "virtual key has no upstream api keys", http.StatusInternalServerError))
+}
+
+// send sends the request with the upstream key, and releases the key whatever the outcome.
+// A panic which isn't a provider error is released as a failure before it propagates
+func (k KeyPool) send(apiKey string, call func(provider Provider) *Response) (result *Response, err *errors.ApiProvider) {
+	status, tokens := http.StatusInternalServerError, 0
+	defer func() {
+		services.GetKeyPoolService().Release(k.Request.Provider, apiKey, status, tokens)
+	}()
+
+	result, err = callProvider(Factory(k.Request.ForApiKey(apiKey)), call)
+	if err != nil {
+		status = err.StatusCode
+	} else {
+		status, tokens = 0, result.TokensUsed
+	}
+	return result, err
+}
+
+// isKeyUnavailable tells whether the error is specific to the upstream key, so another key of the pool may succeed:
+// the key is rate limited, or the circuit breaker of the key is open
+func isKeyUnavailable(err errors.ApiProvider) bool {
+	return err.StatusCode == http.StatusTooManyRequests || err.Code == "LLM_PROVIDER_UNAVAILABLE"
+}
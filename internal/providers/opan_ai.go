@@ -2,14 +2,16 @@ package providers
 
 import (
 	"context"
+	"encoding/json"
+	stderrors "errors"
 	"net/http"
-	"qualifire-home-assignment/internal/configs"
 	"qualifire-home-assignment/internal/http/errors"
 	"qualifire-home-assignment/internal/models"
-	"qualifire-home-assignment/internal/utils"
+	"strings"
 
 	"github.com/openai/openai-go/v2"
 	"github.com/openai/openai-go/v2/option"
+	"github.com/openai/openai-go/v2/shared"
 	"github.com/openai/openai-go/v2/shared/constant"
 )
 
@@ -18,41 +20,250 @@ type OpenAI struct {
 	ProviderBase
 }
 
-func (o OpenAI) SendRequest() *Response {
+// SendRequest sends a request to the OpenAI provider and returns the response
+func (o OpenAI) SendRequest(ctx context.Context) *Response {
 	var err error
 	var resp *openai.ChatCompletion
 
-	httpClient := o.GetHttpClient()
-	// Create OpenAI client
-	client := openai.NewClient(
+	client := o.newClient(o.GetHttpClient())
+	resp, err = client.Chat.Completions.New(ctx, o.newParams())
+	if err != nil {
+		panic(toApiProviderError(err))
+	}
+
+	choices := make([]Choice, 0, len(resp.Choices))
+	for _, choice := range resp.Choices {
+		choices = append(choices, Choice{
+			Message:      Message{Role: "assistant", Content: choice.Message.Content, ToolCalls: toToolCalls(choice.Message.ToolCalls)},
+			FinishReason: choice.FinishReason,
+		})
+	}
+
+	result := o.newResponse(choices, toUsage(resp.Usage))
+	result.ID = resp.ID
+	return result
+}
+
+// StreamRequest streams the OpenAI response chunks into the handler and returns the aggregated response
+func (o OpenAI) StreamRequest(ctx context.Context, handler StreamHandler) *Response {
+	var usage *models.Usage
+	choices := make([]Choice, o.Request.Options.GetN())
+	contents := make([]strings.Builder, len(choices))
+	toolCalls := make([]toolCallsBuilder, len(choices))
+
+	params := o.newParams()
+	// Ask for the usage chunk, which is sent before the end of the stream
+	params.StreamOptions = openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)}
+
+	client := o.newClient(o.GetStreamHttpClient())
+	stream := client.Chat.Completions.NewStreaming(ctx, params)
+	defer stream.Close()
+
+	for stream.Next() {
+		chunk := stream.Current()
+		if chunk.Usage.TotalTokens > 0 {
+			usage = toUsage(chunk.Usage)
+		}
+
+		for _, choice := range chunk.Choices {
+			index := int(choice.Index)
+			if index >= len(choices) {
+				continue
+			}
+			contents[index].WriteString(choice.Delta.Content)
+			if choice.FinishReason != "" {
+				choices[index].FinishReason = choice.FinishReason
+			}
+
+			var deltas []models.ToolCall
+			for _, toolCall := range choice.Delta.ToolCalls {
+				delta := toolCalls[index].add(int(toolCall.Index), toolCall.ID, toolCall.Function.Name, toolCall.Function.Arguments)
+				deltas = append(deltas, delta)
+			}
+
+			handler(StreamChunk{
+				Provider:     o.Request.Provider,
+				Model:        chunk.Model,
+				Index:        index,
+				Delta:        Message{Role: "assistant", Content: choice.Delta.Content, ToolCalls: deltas},
+				FinishReason: choice.FinishReason,
+			})
+		}
+	}
+
+	// A canceled context means the client went away, so the partial response is still accounted for
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
+		panic(toApiProviderError(err))
+	}
+
+	for i := range choices {
+		choices[i].Message = Message{Role: "assistant", Content: contents[i].String(), ToolCalls: toolCalls[i].build()}
+	}
+	return o.newResponse(choices, usage)
+}
+
+// newClient creates an OpenAI client on top of the given HTTP client. An openai_compatible request is sent
+// to its base URL with its custom headers, and without authorization when it has no api key.
+// An azure_openai request is sent to the deployment of its model in its resource
+func (o OpenAI) newClient(httpClient *http.Client) openai.Client {
+	options := []option.RequestOption{
 		option.WithAPIKey(o.Request.ApiKey),
 		option.WithHTTPClient(httpClient),
-		option.WithMaxRetries(configs.EnvInt("PROVIDER_MAX_RETRIES", "0")),
-	)
+		// The retries are made by the transport of the HTTP client, as for every provider
+		option.WithMaxRetries(0),
+	}
+	switch {
+	case o.Request.Provider == AZURE_OPENAI:
+		options = append(options, azureOpenAIOptions(o.Request)...)
+	case o.Request.BaseURL != "":
+		options = append(options, option.WithBaseURL(o.Request.BaseURL))
+	}
+	if o.Request.ApiKey == "" {
+		options = append(options, option.WithHeaderDel("Authorization"))
+	}
+	for name, value := range o.Request.Headers {
+		options = append(options, option.WithHeader(name, value))
+	}
+	return openai.NewClient(options...)
+}
 
-	messages := toOpenAIMessages(o.Request)
+// newParams maps the proxy request to the OpenAI chat completion parameters
+func (o OpenAI) newParams() openai.ChatCompletionNewParams {
+	options := o.Request.Options
 	params := openai.ChatCompletionNewParams{
-		Messages: messages,
+		Messages: toOpenAIMessages(o.Request),
 		Model:    o.Request.Model,
 	}
 
-	resp, err = client.Chat.Completions.New(context.Background(), params)
-	if err != nil {
-		panic(errors.ApiProvider{}.GetError(err.Error(), http.StatusInternalServerError))
+	if options.Temperature != nil {
+		params.Temperature = openai.Float(*options.Temperature)
+	}
+	if options.TopP != nil {
+		params.TopP = openai.Float(*options.TopP)
+	}
+	if options.MaxTokens != nil {
+		params.MaxTokens = openai.Int(*options.MaxTokens)
+	}
+	if options.MaxCompletionTokens != nil {
+		params.MaxCompletionTokens = openai.Int(*options.MaxCompletionTokens)
+	}
+	if len(options.Stop) > 0 {
+		params.Stop = openai.ChatCompletionNewParamsStopUnion{OfStringArray: options.Stop}
+	}
+	if options.N != nil {
+		params.N = openai.Int(*options.N)
+	}
+	if options.Seed != nil {
+		params.Seed = openai.Int(*options.Seed)
+	}
+	if options.User != "" {
+		params.User = openai.String(options.User)
+	}
+	if options.ResponseFormat != nil {
+		params.ResponseFormat = toResponseFormat(*options.ResponseFormat)
+	}
+	for _, tool := range options.Tools {
+		function := shared.FunctionDefinitionParam{Name: tool.Function.Name, Parameters: tool.Function.Parameters}
+		if tool.Function.Description != "" {
+			function.Description = openai.String(tool.Function.Description)
+		}
+		if tool.Function.Strict != nil {
+			function.Strict = openai.Bool(*tool.Function.Strict)
+		}
+		params.Tools = append(params.Tools, openai.ChatCompletionFunctionTool(function))
+	}
+	if options.ToolChoice != nil {
+		params.ToolChoice = toToolChoice(*options.ToolChoice)
+	}
+	if options.ParallelToolCalls != nil {
+		params.ParallelToolCalls = openai.Bool(*options.ParallelToolCalls)
 	}
 
-	responseContent := resp.Choices[0].Message.Content
+	return params
+}
 
-	// Calculate tokens: input + output
-	inputTokens := utils.CalculateRequestTokens(o.Request.Messages, o.Request.Model)
-	outputTokens := utils.CalculateResponseTokens(responseContent)
+// toResponseFormat converts the requested response format to the OpenAI response format
+func toResponseFormat(format models.ResponseFormat) openai.ChatCompletionNewParamsResponseFormatUnion {
+	switch format.Type {
+	case "json_object":
+		return openai.ChatCompletionNewParamsResponseFormatUnion{OfJSONObject: &shared.ResponseFormatJSONObjectParam{}}
+	case "json_schema":
+		// The schema is validated with the request, hence it always decodes
+		var schema shared.ResponseFormatJSONSchemaJSONSchemaParam
+		b, _ := json.Marshal(format.JSONSchema)
+		_ = json.Unmarshal(b, &schema)
+		return openai.ChatCompletionNewParamsResponseFormatUnion{OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{JSONSchema: schema}}
+	default:
+		return openai.ChatCompletionNewParamsResponseFormatUnion{OfText: &shared.ResponseFormatTextParam{}}
+	}
+}
+
+// toContentParts converts the parts of a multimodal content to the OpenAI content parts
+func toContentParts(parts []models.ContentPart) []openai.ChatCompletionContentPartUnionParam {
+	contentParts := make([]openai.ChatCompletionContentPartUnionParam, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case models.CONTENT_PART_TEXT:
+			contentParts = append(contentParts, openai.TextContentPart(part.Text))
+		case models.CONTENT_PART_IMAGE:
+			contentParts = append(contentParts, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{
+				URL:    part.ImageURL.URL,
+				Detail: part.ImageURL.Detail,
+			}))
+		case models.CONTENT_PART_FILE:
+			file := openai.ChatCompletionContentPartFileFileParam{FileData: openai.String(part.File.FileData)}
+			if part.File.Filename != "" {
+				file.Filename = openai.String(part.File.Filename)
+			}
+			contentParts = append(contentParts, openai.FileContentPart(file))
+		}
+	}
+	return contentParts
+}
 
-	return &Response{
-		Choices: []Message{
-			{Role: "user", Content: responseContent},
-		},
-		TokensUsed: inputTokens + outputTokens,
+// toToolChoice converts the requested tool choice to the OpenAI tool choice
+func toToolChoice(choice models.ToolChoice) openai.ChatCompletionToolChoiceOptionUnionParam {
+	if choice.Function != "" {
+		return openai.ToolChoiceOptionFunctionToolChoice(openai.ChatCompletionNamedToolChoiceFunctionParam{Name: choice.Function})
 	}
+	return openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: openai.String(choice.Mode)}
+}
+
+// toToolCalls converts the function calls of an OpenAI message to the gateway tool calls
+func toToolCalls(toolCalls []openai.ChatCompletionMessageToolCallUnion) []models.ToolCall {
+	var calls []models.ToolCall
+	for _, toolCall := range toolCalls {
+		if toolCall.Type != models.TOOL_TYPE_FUNCTION {
+			continue
+		}
+		calls = append(calls, models.ToolCall{
+			ID:       toolCall.ID,
+			Type:     models.TOOL_TYPE_FUNCTION,
+			Function: models.FunctionCall{Name: toolCall.Function.Name, Arguments: toolCall.Function.Arguments},
+		})
+	}
+	return calls
+}
+
+// toUsage converts the OpenAI usage to the gateway usage
+func toUsage(usage openai.CompletionUsage) *models.Usage {
+	return &models.Usage{
+		PromptTokens:     int(usage.PromptTokens),
+		CompletionTokens: int(usage.CompletionTokens),
+		CachedTokens:     int(usage.PromptTokensDetails.CachedTokens),
+	}
+}
+
+// toApiProviderError converts an OpenAI client error to an ApiProvider error, keeping the upstream status code
+func toApiProviderError(err error) errors.ApiProvider {
+	var apiErr *openai.Error
+	if stderrors.As(err, &apiErr) && apiErr.Message != "" {
+		return errors.ApiProvider{}.GetError(apiErr.Message, apiErr.StatusCode)
+	}
+	if apiErr != nil {
+		return errors.ApiProvider{}.GetError(err.Error(), apiErr.StatusCode)
+	}
+	return toTransportError(err)
 }
 
 // toOpenAIMessages converts ProxyRequest messages to OpenAI ChatCompletionMessageParamUnion format
@@ -71,23 +282,36 @@ func toOpenAIMessages(req models.ProxyRequest) []openai.ChatCompletionMessagePar
 				},
 			})
 		case "user":
+			content := openai.ChatCompletionUserMessageParamContentUnion{OfString: openai.String(m.Content)}
+			if m.Parts != nil {
+				content = openai.ChatCompletionUserMessageParamContentUnion{OfArrayOfContentParts: toContentParts(m.Parts)}
+			}
 			messages = append(messages, openai.ChatCompletionMessageParamUnion{
 				OfUser: &openai.ChatCompletionUserMessageParam{
-					Role: constant.User(m.Role),
-					Content: openai.ChatCompletionUserMessageParamContentUnion{
-						OfString: openai.String(m.Content),
-					},
+					Role:    constant.User(m.Role),
+					Content: content,
 				},
 			})
 		case "assistant":
-			messages = append(messages, openai.ChatCompletionMessageParamUnion{
-				OfAssistant: &openai.ChatCompletionAssistantMessageParam{
-					Role: constant.Assistant(m.Role),
-					Content: openai.ChatCompletionAssistantMessageParamContentUnion{
-						OfString: openai.String(m.Content),
+			assistant := &openai.ChatCompletionAssistantMessageParam{Role: constant.Assistant(m.Role)}
+			// The content of a message which only calls tools is omitted
+			if m.Content != "" || len(m.ToolCalls) == 0 {
+				assistant.Content = openai.ChatCompletionAssistantMessageParamContentUnion{
+					OfString: openai.String(m.Content),
+				}
+			}
+			for _, toolCall := range m.ToolCalls {
+				assistant.ToolCalls = append(assistant.ToolCalls, openai.ChatCompletionMessageToolCallUnionParam{
+					OfFunction: &openai.ChatCompletionMessageFunctionToolCallParam{
+						ID: toolCall.ID,
+						Function: openai.ChatCompletionMessageFunctionToolCallFunctionParam{
+							Name:      toolCall.Function.Name,
+							Arguments: toolCall.Function.Arguments,
+						},
 					},
-				},
-			})
+				})
+			}
+			messages = append(messages, openai.ChatCompletionMessageParamUnion{OfAssistant: assistant})
 		case "developer":
 			messages = append(messages, openai.ChatCompletionMessageParamUnion{
 				OfDeveloper: &openai.ChatCompletionDeveloperMessageParam{
@@ -100,7 +324,8 @@ func toOpenAIMessages(req models.ProxyRequest) []openai.ChatCompletionMessagePar
 		case "tool":
 			messages = append(messages, openai.ChatCompletionMessageParamUnion{
 				OfTool: &openai.ChatCompletionToolMessageParam{
-					Role: constant.Tool(m.Role),
+					Role:       constant.Tool(m.Role),
+					ToolCallID: m.ToolCallID,
 					Content: openai.ChatCompletionToolMessageParamContentUnion{
 						OfString: openai.String(m.Content),
 					},
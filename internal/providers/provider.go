@@ -1,25 +1,59 @@
 package providers
 
 import (
+	"context"
+	stderrors "errors"
 	"net/http"
+	"os"
 	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/errors"
 	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/services"
 	"qualifire-home-assignment/internal/transports"
+	"qualifire-home-assignment/internal/utils"
 	"time"
 )
 
 // Provider defines the interface for all providers
 type Provider interface {
 	// SendRequest sends a request to the provider and returns the response
-	SendRequest() *Response
+	SendRequest(ctx context.Context) *Response
+	// StreamRequest streams the provider response chunk by chunk into the handler
+	// and returns the aggregated response once the stream is closed
+	StreamRequest(ctx context.Context, handler StreamHandler) *Response
 }
 
 // Response represents a generic response from a provider
 type Response struct {
-	Choices      []Message `json:"choices"`
-	TokensUsed   int       `json:"tokens_used,omitempty"`
+	// ID is the identifier the provider gave to the response, if any
+	ID         string       `json:"id,omitempty"`
+	Choices    []Choice     `json:"choices"`
+	TokensUsed int          `json:"tokens_used,omitempty"`
+	Usage      models.Usage `json:"usage"`
+	// Provider and Model report the target which actually answered the request
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
 }
 
+// Choice represents a single generated message and the reason the generation stopped
+type Choice struct {
+	Message
+	FinishReason string `json:"finish_reason,omitempty"`
+}
+
+// StreamChunk represents a single normalized chunk of a streamed provider response
+type StreamChunk struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	// Index is the index of the choice the chunk belongs to, when several choices are generated
+	Index        int     `json:"index,omitempty"`
+	Delta        Message `json:"delta"`
+	FinishReason string  `json:"finish_reason,omitempty"`
+}
+
+// StreamHandler receives the chunks of a streamed provider response
+type StreamHandler func(chunk StreamChunk)
+
 // ProviderBase provides common fields for all providers
 type ProviderBase struct {
 	Request models.ProxyRequest
@@ -29,32 +63,159 @@ type ProviderBase struct {
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ToolCalls are the functions called by the model, stream chunks carry fragments of the calls
+	ToolCalls []models.ToolCall `json:"tool_calls,omitempty"`
 }
 
 const (
 	OPENAI    = "openai"
 	ANTHROPIC = "anthropic"
+	GEMINI    = "gemini"
+	// OPENAI_COMPATIBLE is a self-hosted server implementing the OpenAI chat completions API, e.g. Ollama, vLLM or llama.cpp
+	OPENAI_COMPATIBLE = "openai_compatible"
+	// AZURE_OPENAI is an Azure OpenAI resource, whose models are served by deployments
+	AZURE_OPENAI = "azure_openai"
 )
 
-// Factory creates a Provider based on the ProxyRequest provider field
+// Factory creates a Provider based on the ProxyRequest provider field.
+// A request routed to a pool of upstream api keys is wrapped by the KeyPool provider,
+// while the requests which use the response cache are wrapped by NewCached
 func Factory(req models.ProxyRequest) Provider {
+	if len(req.Targets) > 1 {
+		return Fallback{ProviderBase{req}}
+	}
+	if len(req.ApiKeys) > 0 {
+		return KeyPool{ProviderBase{req}}
+	}
+
 	switch req.Provider {
-	case OPENAI:
+	case OPENAI, OPENAI_COMPATIBLE, AZURE_OPENAI:
 		return OpenAI{ProviderBase{req}}
 	case ANTHROPIC:
 		return Anthropic{ProviderBase{req}}
+	case GEMINI:
+		return Gemini{ProviderBase{req}}
 	default:
 		panic("unknown provider")
 	}
 }
 
-// GetHttpClient returns a configured HTTP client with logging and timeout
+// GetHttpClient returns a configured HTTP client with logging, retries and timeout.
+// The request timeout bounds each attempt, while the client timeout bounds all of them
 func (p ProviderBase) GetHttpClient() *http.Client {
+	policy := transports.NewRetryPolicy(time.Duration(configs.EnvInt("PROVIDER_REQUEST_TIMEOUT", "30")) * time.Second)
+	return &http.Client{
+		Transport: p.getTransport(&http.Transport{
+			TLSHandshakeTimeout: time.Duration(configs.EnvInt("TLS_HANDSHAKE_TIMEOUT", "30")) * time.Second,
+		}, policy),
+		Timeout: policy.Timeout(),
+	}
+}
+
+// GetStreamHttpClient returns a configured HTTP client for streamed responses.
+// The timeout is applied only until the response headers arrive, so long generations aren't cut off,
+// while the stream itself is bounded by the request context
+func (p ProviderBase) GetStreamHttpClient() *http.Client {
 	return &http.Client{
-		Transport: &transports.LoggingTransport{Base: &http.Transport{
-			TLSHandshakeTimeout: time.Duration(configs.EnvInt("TLS_HANDSHAKE_TIMEOUT", "30")) * time.Second},
-			Req: p.Request,
+		Transport: p.getTransport(&http.Transport{
+			TLSHandshakeTimeout:   time.Duration(configs.EnvInt("TLS_HANDSHAKE_TIMEOUT", "30")) * time.Second,
+			ResponseHeaderTimeout: time.Duration(configs.EnvInt("PROVIDER_REQUEST_TIMEOUT", "30")) * time.Second,
+		}, transports.NewRetryPolicy(0)),
+	}
+}
+
+// getTransport wraps the base transport with request logging, the logged attempts with the retry policy,
+// and the retried request with the circuit breaker of the provider target.
+// A rate limited request of a key pool isn't retried, so the pool moves to another key instead
+func (p ProviderBase) getTransport(base http.RoundTripper, policy transports.RetryPolicy) http.RoundTripper {
+	if p.Request.Pooled {
+		policy = policy.Except(http.StatusTooManyRequests)
+	}
+	return &transports.CircuitBreakerTransport{
+		Base: &transports.RetryTransport{
+			Base:   &transports.LoggingTransport{Base: base, Req: p.Request},
+			Policy: policy,
+			Req:    p.Request,
 		},
-		Timeout: time.Duration(configs.EnvInt("PROVIDER_REQUEST_TIMEOUT", "30")) * time.Second,
+		Req: p.Request,
+	}
+}
+
+// newResponse builds a Response with the usage reported by the provider.
+// If the provider didn't report usage, the tokens are estimated: input + output of every choice
+func (p ProviderBase) newResponse(choices []Choice, usage *models.Usage) *Response {
+	if usage == nil || usage.TotalTokens() == 0 {
+		usage = &models.Usage{
+			PromptTokens: utils.CalculateRequestTokens(p.Request.Messages, p.Request.Model),
+			Estimated:    true,
+		}
+		for _, choice := range choices {
+			usage.CompletionTokens += utils.CalculateResponseTokens(choice.Content, p.Request.Model)
+			for _, toolCall := range choice.ToolCalls {
+				usage.CompletionTokens += utils.CalculateResponseTokens(toolCall.Function.Name+toolCall.Function.Arguments, p.Request.Model)
+			}
+		}
+	}
+
+	return &Response{
+		Choices:    choices,
+		TokensUsed: usage.TotalTokens(),
+		Usage:      *usage,
+		Provider:   p.Request.Provider,
+		Model:      p.Request.Model,
+	}
+}
+
+// toolCallsBuilder aggregates the streamed fragments of tool calls, the fragments of a call share its index
+type toolCallsBuilder struct {
+	calls []models.ToolCall
+}
+
+// add appends a fragment to the call of the index and returns the fragment as a stream delta
+func (b *toolCallsBuilder) add(index int, id string, name string, arguments string) models.ToolCall {
+	for len(b.calls) <= index {
+		b.calls = append(b.calls, models.ToolCall{Type: models.TOOL_TYPE_FUNCTION})
+	}
+	call := &b.calls[index]
+	if id != "" {
+		call.ID = id
+	}
+	if name != "" {
+		call.Function.Name = name
+	}
+	call.Function.Arguments += arguments
+
+	delta := models.ToolCall{Index: &index, ID: id, Function: models.FunctionCall{Name: name, Arguments: arguments}}
+	if id != "" {
+		delta.Type = models.TOOL_TYPE_FUNCTION
+	}
+	return delta
+}
+
+// build returns the aggregated tool calls, the calls without arguments get an empty object
+func (b *toolCallsBuilder) build() []models.ToolCall {
+	for i := range b.calls {
+		if b.calls[i].Function.Arguments == "" {
+			b.calls[i].Function.Arguments = "{}"
+		}
+	}
+	return b.calls
+}
+
+// toTransportError converts the error of a call that didn't get a provider response to a provider error.
+// A call refused by an open circuit breaker fails with LLM_PROVIDER_UNAVAILABLE
+func toTransportError(err error) errors.ApiProvider {
+	var circuitErr *services.CircuitOpenError
+	if stderrors.As(err, &circuitErr) {
+		return errors.ApiProvider{}.GetUnavailableError(circuitErr.Error())
+	}
+	return errors.ApiProvider{}.GetError(err.Error(), transportErrorStatus(err))
+}
+
+// transportErrorStatus returns the status code reported for a failed call that didn't get a provider response
+func transportErrorStatus(err error) int {
+	if os.IsTimeout(err) || stderrors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
 	}
+	return http.StatusBadGateway
 }
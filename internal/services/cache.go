@@ -0,0 +1,131 @@
+package services
+
+import (
+	"container/list"
+	"qualifire-home-assignment/internal/configs"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+const (
+	// CACHE_QUOTA_FREE doesn't charge the cache hits to the token quota
+	CACHE_QUOTA_FREE = "free"
+	// CACHE_QUOTA_CHARGE charges the cache hits the tokens of the cached response, as if the provider answered them
+	CACHE_QUOTA_CHARGE = "charge"
+)
+
+// CacheService caches the provider responses of identical requests in memory.
+// Entries expire after the TTL, and the least recently used entry is evicted once the cache is full
+type CacheService struct {
+	entries    map[string]*list.Element
+	order      *list.List
+	maxEntries int
+	ttl        time.Duration
+	mu         sync.Mutex
+}
+
+// cacheEntry represents a single cached response
+type cacheEntry struct {
+	key      string
+	value    interface{}
+	storedAt time.Time
+}
+
+var (
+	cacheServiceInstance *CacheService
+	cacheServiceOnce     sync.Once
+)
+
+// GetCacheService returns the singleton instance of CacheService
+func GetCacheService() *CacheService {
+	cacheServiceOnce.Do(func() {
+		cacheServiceInstance = &CacheService{
+			entries:    make(map[string]*list.Element),
+			order:      list.New(),
+			maxEntries: configs.EnvInt("CACHE_MAX_ENTRIES", "1000"),
+			ttl:        time.Duration(configs.EnvInt("CACHE_TTL", "3600")) * time.Second,
+		}
+	})
+	return cacheServiceInstance
+}
+
+// Get returns the cached value of the key and its age, entries older than maxAge are ignored unless maxAge is zero
+func (s *CacheService) Get(key string, maxAge time.Duration) (interface{}, time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+	entry := element.Value.(*cacheEntry)
+	age := time.Since(entry.storedAt)
+	if age > s.ttl {
+		s.order.Remove(element)
+		delete(s.entries, key)
+		return nil, 0, false
+	}
+	if maxAge > 0 && age > maxAge {
+		return nil, 0, false
+	}
+
+	s.order.MoveToFront(element)
+	return entry.value, age, true
+}
+
+// Set stores the value of the key, evicting the least recently used entries beyond the maximum number of entries
+func (s *CacheService) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		element.Value = &cacheEntry{key: key, value: value, storedAt: time.Now()}
+		s.order.MoveToFront(element)
+	} else {
+		s.entries[key] = s.order.PushFront(&cacheEntry{key: key, value: value, storedAt: time.Now()})
+	}
+
+	for s.maxEntries > 0 && s.order.Len() > s.maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Len returns the number of cached entries, including the expired ones which weren't evicted yet
+func (s *CacheService) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+// SetLimits sets the maximum number of entries and the TTL of the cache
+func (s *CacheService) SetLimits(maxEntries int, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxEntries = maxEntries
+	s.ttl = ttl
+}
+
+// Reset clears the cache (useful for testing)
+func (s *CacheService) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = make(map[string]*list.Element)
+	s.order = list.New()
+}
+
+// IsCacheEnabled tells whether the virtual key opted in to the response cache in the config snapshot
+func IsCacheEnabled(snapshot *configs.Snapshot, virtualKey string) bool {
+	return cast.ToBool(snapshot.VirtualKeyConfig(virtualKey)["cache"])
+}
+
+// GetCacheQuotaPolicy returns how the cache hits are charged to the token quota, free unless configured otherwise
+func GetCacheQuotaPolicy() string {
+	if configs.Env("CACHE_QUOTA_POLICY", CACHE_QUOTA_FREE) == CACHE_QUOTA_CHARGE {
+		return CACHE_QUOTA_CHARGE
+	}
+	return CACHE_QUOTA_FREE
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/models"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+const (
+	CIRCUIT_CLOSED    = "closed"
+	CIRCUIT_OPEN      = "open"
+	CIRCUIT_HALF_OPEN = "half_open"
+)
+
+// CircuitBreakerConfig defines when the circuit of a provider target opens and how it closes again
+type CircuitBreakerConfig struct {
+	// ErrorRate is the rate of failed requests in the window which opens the circuit, zero disables the breakers
+	ErrorRate float64
+	// MinRequests is the number of requests in the window below which the circuit doesn't open
+	MinRequests int
+	Window      time.Duration
+	// OpenDuration is how long the circuit stays open before it lets probes through
+	OpenDuration time.Duration
+	// HalfOpenProbes is the number of successful probes which close the circuit
+	HalfOpenProbes int
+}
+
+// BreakerKey identifies the provider target a circuit breaker guards: the provider, its endpoint and the api key
+type BreakerKey struct {
+	Provider string
+	Endpoint string
+	// ApiKey is the masked api key, and Fingerprint tells apart the api keys which mask alike
+	ApiKey      string
+	Fingerprint string
+}
+
+// NewBreakerKey returns the key of the circuit breaker of a provider target
+func NewBreakerKey(provider string, endpoint string, apiKey string) BreakerKey {
+	return BreakerKey{Provider: provider, Endpoint: endpoint, ApiKey: models.MaskSecret(apiKey), Fingerprint: models.Fingerprint(apiKey)}
+}
+
+// BreakerState reports the state of a circuit breaker
+type BreakerState struct {
+	Provider string `json:"provider"`
+	Endpoint string `json:"endpoint"`
+	// ApiKey is the masked api key, and Fingerprint a short hash of it which labels the metrics of the breaker
+	ApiKey      string  `json:"api_key"`
+	Fingerprint string  `json:"fingerprint"`
+	State       string  `json:"state"`
+	Requests    int     `json:"requests"`
+	ErrorRate   float64 `json:"error_rate"`
+	// OpenedAt is when the circuit last opened, if ever
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+	Opened   int        `json:"opened_total"`
+}
+
+// CircuitOpenError is returned for the requests to a provider target whose circuit is open
+type CircuitOpenError struct {
+	Key     BreakerKey
+	RetryIn time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s is unavailable at %s, its circuit breaker is open (retry in %ds)", e.Key.Provider, e.Key.Endpoint, int(e.RetryIn.Seconds()+0.5))
+}
+
+// breaker tracks the outcomes of the requests to a single provider target
+type breaker struct {
+	state    string
+	outcomes []outcome
+	openedAt time.Time
+	opened   int
+	// probes is the number of probes in flight, and successes the number of successful ones, while half-open
+	probes    int
+	successes int
+}
+
+// outcome represents the outcome of a single request
+type outcome struct {
+	at     time.Time
+	failed bool
+}
+
+// CircuitBreakerService manages a circuit breaker per provider target.
+// A circuit opens when the error rate of its target in the window reaches the threshold, and the requests then
+// fail fast. Once the open duration elapses the circuit is half-open: a limited number of probes go through,
+// their success closes the circuit and any failure opens it again
+type CircuitBreakerService struct {
+	config   CircuitBreakerConfig
+	breakers map[BreakerKey]*breaker
+	mu       sync.Mutex
+}
+
+var (
+	circuitBreakerServiceInstance *CircuitBreakerService
+	circuitBreakerServiceOnce     sync.Once
+)
+
+// GetCircuitBreakerService returns the singleton instance of CircuitBreakerService
+func GetCircuitBreakerService() *CircuitBreakerService {
+	circuitBreakerServiceOnce.Do(func() {
+		circuitBreakerServiceInstance = &CircuitBreakerService{
+			config: CircuitBreakerConfig{
+				ErrorRate:      cast.ToFloat64(configs.Env("CIRCUIT_BREAKER_ERROR_RATE", "0.5")),
+				MinRequests:    configs.EnvInt("CIRCUIT_BREAKER_MIN_REQUESTS", "10"),
+				Window:         time.Duration(configs.EnvInt("CIRCUIT_BREAKER_WINDOW", "60")) * time.Second,
+				OpenDuration:   time.Duration(configs.EnvInt("CIRCUIT_BREAKER_OPEN_DURATION", "30")) * time.Second,
+				HalfOpenProbes: max(configs.EnvInt("CIRCUIT_BREAKER_HALF_OPEN_PROBES", "1"), 1),
+			},
+			breakers: make(map[BreakerKey]*breaker),
+		}
+	})
+	return circuitBreakerServiceInstance
+}
+
+// Allow checks whether a request may be sent to the target, it returns a CircuitOpenError if the circuit is open.
+// Every allowed request must be followed by a call to Record
+func (s *CircuitBreakerService) Allow(key BreakerKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.config.ErrorRate <= 0 {
+		return nil
+	}
+
+	b := s.getBreaker(key)
+	switch b.state {
+	case CIRCUIT_OPEN:
+		retryIn := s.config.OpenDuration - time.Since(b.openedAt)
+		if retryIn > 0 {
+			return &CircuitOpenError{Key: key, RetryIn: retryIn}
+		}
+		b.state, b.probes, b.successes = CIRCUIT_HALF_OPEN, 0, 0
+		fallthrough
+	case CIRCUIT_HALF_OPEN:
+		if b.probes+b.successes >= s.config.HalfOpenProbes {
+			return &CircuitOpenError{Key: key}
+		}
+		b.probes++
+	}
+	return nil
+}
+
+// Record records the outcome of an allowed request, canceled requests are neither a success nor a failure
+func (s *CircuitBreakerService) Record(key BreakerKey, failed bool, canceled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.config.ErrorRate <= 0 {
+		return
+	}
+
+	b := s.getBreaker(key)
+	if b.state == CIRCUIT_HALF_OPEN {
+		b.probes = max(b.probes-1, 0)
+		switch {
+		case canceled:
+		case failed:
+			s.open(b)
+		default:
+			b.successes++
+			if b.successes >= s.config.HalfOpenProbes {
+				b.state, b.outcomes = CIRCUIT_CLOSED, nil
+			}
+		}
+		return
+	}
+	if canceled || b.state != CIRCUIT_CLOSED {
+		return
+	}
+
+	b.outcomes = append(s.trim(b.outcomes), outcome{at: time.Now(), failed: failed})
+	requests, failures := count(b.outcomes)
+	if requests >= s.config.MinRequests && float64(failures)/float64(requests) >= s.config.ErrorRate {
+		s.open(b)
+	}
+}
+
+// open opens the circuit of the breaker
+func (s *CircuitBreakerService) open(b *breaker) {
+	b.state, b.openedAt, b.outcomes = CIRCUIT_OPEN, time.Now(), nil
+	b.probes, b.successes = 0, 0
+	b.opened++
+}
+
+// getBreaker returns the breaker of the key, the caller must hold the lock
+func (s *CircuitBreakerService) getBreaker(key BreakerKey) *breaker {
+	b, ok := s.breakers[key]
+	if !ok {
+		b = &breaker{state: CIRCUIT_CLOSED}
+		s.breakers[key] = b
+	}
+	return b
+}
+
+// trim drops the outcomes which fell out of the window
+func (s *CircuitBreakerService) trim(outcomes []outcome) []outcome {
+	start := time.Now().Add(-s.config.Window)
+	i := sort.Search(len(outcomes), func(i int) bool { return outcomes[i].at.After(start) })
+	return outcomes[i:]
+}
+
+// count returns the number of requests and failures of the outcomes
+func count(outcomes []outcome) (requests int, failures int) {
+	for _, o := range outcomes {
+		if o.failed {
+			failures++
+		}
+	}
+	return len(outcomes), failures
+}
+
+// GetStates returns the state of every circuit breaker, sorted by provider, endpoint and api key
+func (s *CircuitBreakerService) GetStates() []BreakerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := make([]BreakerState, 0, len(s.breakers))
+	for key, b := range s.breakers {
+		b.outcomes = s.trim(b.outcomes)
+		requests, failures := count(b.outcomes)
+		state := b.state
+		if state == CIRCUIT_OPEN && time.Since(b.openedAt) >= s.config.OpenDuration {
+			state = CIRCUIT_HALF_OPEN
+		}
+		breakerState := BreakerState{
+			Provider:    key.Provider,
+			Endpoint:    key.Endpoint,
+			ApiKey:      key.ApiKey,
+			Fingerprint: key.Fingerprint,
+			State:       state,
+			Requests:    requests,
+			Opened:      b.opened,
+		}
+		if requests > 0 {
+			breakerState.ErrorRate = float64(failures) / float64(requests)
+		}
+		if b.opened > 0 {
+			openedAt := b.openedAt
+			breakerState.OpenedAt = &openedAt
+		}
+		states = append(states, breakerState)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return fmt.Sprint(states[i].Provider, states[i].Endpoint, states[i].ApiKey, states[i].Fingerprint) < fmt.Sprint(states[j].Provider, states[j].Endpoint, states[j].ApiKey, states[j].Fingerprint)
+	})
+	return states
+}
+
+// WritePrometheus writes the state of every circuit breaker in the Prometheus text exposition format
+func (s *CircuitBreakerService) WritePrometheus(w io.Writer) {
+	states := s.GetStates()
+
+	writeHeader(w, "llm_gateway_circuit_breaker_state", "gauge", "State of the circuit breaker of a provider target: 0 closed, 1 half-open, 2 open.")
+	for _, state := range states {
+		value := map[string]float64{CIRCUIT_CLOSED: 0, CIRCUIT_HALF_OPEN: 1, CIRCUIT_OPEN: 2}[state.State]
+		writeSample(w, "llm_gateway_circuit_breaker_state", state.labels(), value)
+	}
+
+	writeHeader(w, "llm_gateway_circuit_breaker_opened_total", "counter", "Total number of times the circuit breaker of a provider target opened.")
+	for _, state := range states {
+		writeSample(w, "llm_gateway_circuit_breaker_opened_total", state.labels(), float64(state.Opened))
+	}
+}
+
+// labels returns the label pairs of the circuit breaker
+func (b BreakerState) labels() []string {
+	return []string{"provider", b.Provider, "endpoint", b.Endpoint, "api_key_fingerprint", b.Fingerprint}
+}
+
+// SetConfig sets the configuration of the circuit breakers
+func (s *CircuitBreakerService) SetConfig(config CircuitBreakerConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+}
+
+// GetConfig returns the configuration of the circuit breakers
+func (s *CircuitBreakerService) GetConfig() CircuitBreakerConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.config
+}
+
+// Reset closes and forgets every circuit breaker (useful for testing)
+func (s *CircuitBreakerService) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.breakers = make(map[BreakerKey]*breaker)
+}
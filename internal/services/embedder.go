@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
+	"qualifire-home-assignment/internal/configs"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/option"
+)
+
+const (
+	// LOCAL_EMBEDDER embeds the text locally, by hashing its words and character trigrams
+	LOCAL_EMBEDDER = "local"
+	// OPENAI_EMBEDDER embeds the text with the OpenAI embeddings API
+	OPENAI_EMBEDDER = "openai"
+)
+
+// Embedder turns a text into a vector, the vectors of similar texts point in similar directions
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float64, error)
+}
+
+// NewEmbedder returns the embedder configured by SEMANTIC_CACHE_EMBEDDER, the local one unless configured otherwise
+func NewEmbedder() Embedder {
+	if configs.Env("SEMANTIC_CACHE_EMBEDDER", LOCAL_EMBEDDER) == OPENAI_EMBEDDER {
+		return OpenAIEmbedder{
+			ApiKey: configs.Env("SEMANTIC_CACHE_API_KEY", ""),
+			Model:  configs.Env("SEMANTIC_CACHE_EMBEDDING_MODEL", openai.EmbeddingModelTextEmbedding3Small),
+		}
+	}
+	return LocalEmbedder{Dimensions: 512}
+}
+
+// LocalEmbedder is a deterministic embedder which needs no model: the words and the character trigrams of the text
+// are hashed into the dimensions of the vector. It matches the texts which share most of their words, such as
+// rephrased questions, and is meant for tests and for deployments which can't call an embeddings API
+type LocalEmbedder struct {
+	Dimensions int
+}
+
+// Embed returns the normalized vector of the text
+func (e LocalEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	vector := make([]float64, e.Dimensions)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		vector[e.index(word)] += 1
+		padded := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			vector[e.index(string(padded[i:i+3]))] += 0.5
+		}
+	}
+	return normalize(vector), nil
+}
+
+// index returns the dimension a feature is hashed into
+func (e LocalEmbedder) index(feature string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(feature))
+	return int(h.Sum32() % uint32(e.Dimensions))
+}
+
+// OpenAIEmbedder embeds the text with the OpenAI embeddings API
+type OpenAIEmbedder struct {
+	ApiKey string
+	Model  string
+}
+
+// Embed returns the embedding of the text
+func (e OpenAIEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	if e.ApiKey == "" {
+		return nil, errors.New("SEMANTIC_CACHE_API_KEY is required by the openai embedder")
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(configs.EnvInt("PROVIDER_REQUEST_TIMEOUT", "30"))*time.Second)
+	defer cancel()
+
+	client := openai.NewClient(option.WithAPIKey(e.ApiKey))
+	resp, err := client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Input: openai.EmbeddingNewParamsInputUnion{OfString: openai.String(text)},
+		Model: e.Model,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, errors.New("the embeddings API returned no embedding")
+	}
+	return normalize(resp.Data[0].Embedding), nil
+}
+
+// normalize scales the vector to a unit length, so the similarity of two vectors is their dot product
+func normalize(vector []float64) []float64 {
+	var norm float64
+	for _, v := range vector {
+		norm += v * v
+	}
+	if norm == 0 {
+		return vector
+	}
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] /= norm
+	}
+	return vector
+}
+
+// CosineSimilarity returns the cosine similarity of two normalized vectors of the same embedder
+func CosineSimilarity(a []float64, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot float64
+	for i := range a {
+		dot += a[i] * b[i]
+	}
+	return dot
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package services
+
+// lockFile is a no-op on platforms without flock, where the file store is safe within a single process only
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UpstreamKeyUsage reports the usage of a single upstream api key of a key pool
+type UpstreamKeyUsage struct {
+	Provider string `json:"provider"`
+	// ApiKey is the masked api key, and Fingerprint a short hash of it which labels the metrics of the key
+	ApiKey      string `json:"api_key"`
+	Fingerprint string `json:"fingerprint"`
+	Requests    int    `json:"requests"`
+	Errors      int    `json:"errors"`
+	RateLimited int    `json:"rate_limited"`
+	Tokens      int    `json:"tokens"`
+	InFlight    int    `json:"in_flight"`
+	// CoolingDownUntil is when the key is chosen again after it was rate limited, if it's cooling down
+	CoolingDownUntil *time.Time `json:"cooling_down_until,omitempty"`
+}
+
+// upstreamKeyID identifies an upstream api key by its provider and api key
+type upstreamKeyID struct {
+	provider string
+	apiKey   string
+}
+
+// upstreamKey tracks the usage of a single upstream api key
+type upstreamKey struct {
+	requests         int
+	errors           int
+	rateLimited      int
+	tokens           int
+	inFlight         int
+	coolingDownUntil time.Time
+}
+
+// keyPool tracks the balancing state of a single key pool
+type keyPool struct {
+	// cursor is the position the next round robin pick starts from
+	cursor int
+	// weights are the current weights of the smooth weighted round robin, by api key
+	weights map[string]int
+}
+
+// KeyPoolService balances the requests across the upstream api keys of the key pools.
+// A key which was rate limited cools down: it isn't chosen again until the cooldown elapses,
+// unless all the keys of its pool are cooling down
+type KeyPoolService struct {
+	cooldown time.Duration
+	keys     map[upstreamKeyID]*upstreamKey
+	pools    map[string]*keyPool
+	mu       sync.Mutex
+}
+
+var (
+	keyPoolServiceInstance *KeyPoolService
+	keyPoolServiceOnce     sync.Once
+)
+
+// GetKeyPoolService returns the singleton instance of KeyPoolService
+func GetKeyPoolService() *KeyPoolService {
+	keyPoolServiceOnce.Do(func() {
+		keyPoolServiceInstance = &KeyPoolService{
+			cooldown: time.Duration(configs.EnvInt("KEY_POOL_COOLDOWN", "60")) * time.Second,
+			keys:     make(map[upstreamKeyID]*upstreamKey),
+			pools:    make(map[string]*keyPool),
+		}
+	})
+	return keyPoolServiceInstance
+}
+
+// Acquire chooses an upstream api key of the pool with its balancing, among the keys which aren't excluded.
+// It returns false when every key of the pool is excluded. Every acquired key must be released with Release
+func (s *KeyPoolService) Acquire(provider string, keys []models.UpstreamKey, balancing string, exclude map[string]bool) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var candidates, available []int
+	for i, key := range keys {
+		if exclude[key.ApiKey] {
+			continue
+		}
+		candidates = append(candidates, i)
+		if !s.getKey(provider, key.ApiKey).coolingDownUntil.After(now) {
+			available = append(available, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	// When all the keys are cooling down, the one which cools down first is used
+	if len(available) == 0 {
+		first := candidates[0]
+		for _, i := range candidates[1:] {
+			if s.getKey(provider, keys[i].ApiKey).coolingDownUntil.Before(s.getKey(provider, keys[first].ApiKey).coolingDownUntil) {
+				first = i
+			}
+		}
+		available = []int{first}
+	}
+
+	pool := s.getPool(provider, keys)
+	var chosen int
+	switch balancing {
+	case models.BALANCING_WEIGHTED:
+		chosen = s.pickWeighted(pool, keys, available)
+	case models.BALANCING_LEAST_IN_FLIGHT:
+		chosen = s.pickLeastInFlight(pool, provider, keys, available)
+	default:
+		chosen = pickNext(pool, len(keys), available)
+	}
+
+	key := s.getKey(provider, keys[chosen].ApiKey)
+	key.requests++
+	key.inFlight++
+	return keys[chosen].ApiKey, true
+}
+
+// Release records the outcome of a request sent with an acquired key: the status of its provider error,
+// zero on success, and the tokens it used. A rate limited key starts cooling down
+func (s *KeyPoolService) Release(provider string, apiKey string, status int, tokens int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := s.getKey(provider, apiKey)
+	key.inFlight = max(key.inFlight-1, 0)
+	key.tokens += tokens
+	if status != 0 {
+		key.errors++
+	}
+	if status == http.StatusTooManyRequests {
+		key.rateLimited++
+		key.coolingDownUntil = time.Now().Add(s.cooldown)
+	}
+}
+
+// pickNext picks the first available key from the round robin cursor on, and moves the cursor past it
+func pickNext(pool *keyPool, size int, available []int) int {
+	for offset := 0; offset < size; offset++ {
+		i := (pool.cursor + offset) % size
+		for _, a := range available {
+			if a == i {
+				pool.cursor = i + 1
+				return i
+			}
+		}
+	}
+	return available[0]
+}
+
+// pickWeighted picks an available key with the smooth weighted round robin, so the keys are interleaved
+// in proportion to their weights
+func (s *KeyPoolService) pickWeighted(pool *keyPool, keys []models.UpstreamKey, available []int) int {
+	total, chosen := 0, available[0]
+	for _, i := range available {
+		weight := max(keys[i].Weight, 1)
+		total += weight
+		pool.weights[keys[i].ApiKey] += weight
+		if pool.weights[keys[i].ApiKey] > pool.weights[keys[chosen].ApiKey] {
+			chosen = i
+		}
+	}
+	pool.weights[keys[chosen].ApiKey] -= total
+	return chosen
+}
+
+// pickLeastInFlight picks the available key with the fewest requests in flight, the ties are broken in round robin
+func (s *KeyPoolService) pickLeastInFlight(pool *keyPool, provider string, keys []models.UpstreamKey, available []int) int {
+	least := -1
+	for _, i := range available {
+		if least == -1 || s.getKey(provider, keys[i].ApiKey).inFlight < least {
+			least = s.getKey(provider, keys[i].ApiKey).inFlight
+		}
+	}
+	var tied []int
+	for _, i := range available {
+		if s.getKey(provider, keys[i].ApiKey).inFlight == least {
+			tied = append(tied, i)
+		}
+	}
+	return pickNext(pool, len(keys), tied)
+}
+
+// getKey returns the usage of the upstream key, the caller must hold the lock
+func (s *KeyPoolService) getKey(provider string, apiKey string) *upstreamKey {
+	id := upstreamKeyID{provider, apiKey}
+	key, ok := s.keys[id]
+	if !ok {
+		key = &upstreamKey{}
+		s.keys[id] = key
+	}
+	return key
+}
+
+// getPool returns the balancing state of the pool, the caller must hold the lock
+func (s *KeyPoolService) getPool(provider string, keys []models.UpstreamKey) *keyPool {
+	apiKeys := make([]string, len(keys))
+	for i, key := range keys {
+		apiKeys[i] = key.ApiKey
+	}
+	id := provider + "\x00" + strings.Join(apiKeys, "\x00")
+	pool, ok := s.pools[id]
+	if !ok {
+		pool = &keyPool{weights: make(map[string]int)}
+		s.pools[id] = pool
+	}
+	return pool
+}
+
+// GetUsage returns the usage of every upstream api key of the key pools, sorted by provider and api key
+func (s *KeyPoolService) GetUsage() []UpstreamKeyUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	usage := make([]UpstreamKeyUsage, 0, len(s.keys))
+	for id, key := range s.keys {
+		keyUsage := UpstreamKeyUsage{
+			Provider:    id.provider,
+			ApiKey:      models.MaskSecret(id.apiKey),
+			Fingerprint: models.Fingerprint(id.apiKey),
+			Requests:    key.requests,
+			Errors:      key.errors,
+			RateLimited: key.rateLimited,
+			Tokens:      key.tokens,
+			InFlight:    key.inFlight,
+		}
+		if key.coolingDownUntil.After(now) {
+			coolingDownUntil := key.coolingDownUntil
+			keyUsage.CoolingDownUntil = &coolingDownUntil
+		}
+		usage = append(usage, keyUsage)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return fmt.Sprint(usage[i].Provider, usage[i].ApiKey, usage[i].Fingerprint) < fmt.Sprint(usage[j].Provider, usage[j].ApiKey, usage[j].Fingerprint)
+	})
+	return usage
+}
+
+// WritePrometheus writes the usage of every upstream api key in the Prometheus text exposition format
+func (s *KeyPoolService) WritePrometheus(w io.Writer) {
+	usage := s.GetUsage()
+
+	writeHeader(w, "llm_gateway_upstream_key_requests_total", "counter", "Total number of requests sent with an upstream api key of a key pool.")
+	for _, key := range usage {
+		writeSample(w, "llm_gateway_upstream_key_requests_total", key.labels(), float64(key.Requests))
+	}
+
+	writeHeader(w, "llm_gateway_upstream_key_errors_total", "counter", "Total number of failed requests sent with an upstream api key of a key pool.")
+	for _, key := range usage {
+		writeSample(w, "llm_gateway_upstream_key_errors_total", key.labels(), float64(key.Errors))
+	}
+
+	writeHeader(w, "llm_gateway_upstream_key_rate_limited_total", "counter", "Total number of requests sent with an upstream api key of a key pool which were rate limited.")
+	for _, key := range usage {
+		writeSample(w, "llm_gateway_upstream_key_rate_limited_total", key.labels(), float64(key.RateLimited))
+	}
+
+	writeHeader(w, "llm_gateway_upstream_key_tokens_total", "counter", "Total number of tokens used with an upstream api key of a key pool.")
+	for _, key := range usage {
+		writeSample(w, "llm_gateway_upstream_key_tokens_total", key.labels(), float64(key.Tokens))
+	}
+
+	writeHeader(w, "llm_gateway_upstream_key_in_flight", "gauge", "Number of requests in flight with an upstream api key of a key pool.")
+	for _, key := range usage {
+		writeSample(w, "llm_gateway_upstream_key_in_flight", key.labels(), float64(key.InFlight))
+	}
+}
+
+// labels returns the label pairs of the upstream key
+func (u UpstreamKeyUsage) labels() []string {
+	return []string{"provider", u.Provider, "api_key_fingerprint", u.Fingerprint}
+}
+
+// SetCooldown sets how long a rate limited key cools down
+func (s *KeyPoolService) SetCooldown(cooldown time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cooldown = cooldown
+}
+
+// GetCooldown returns how long a rate limited key cools down
+func (s *KeyPoolService) GetCooldown() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cooldown
+}
+
+// Reset forgets the usage and the balancing state of every key pool (useful for testing)
+func (s *KeyPoolService) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = make(map[upstreamKeyID]*upstreamKey)
+	s.pools = make(map[string]*keyPool)
+}
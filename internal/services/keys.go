@@ -0,0 +1,226 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/models"
+	"sort"
+	"sync"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+var (
+	ErrKeyNotFound = errors.New("virtual key not found")
+	ErrKeyExists   = errors.New("virtual key already exists")
+	ErrInvalidKey  = errors.New("invalid virtual key")
+)
+
+// KeyService manages the virtual keys at runtime.
+// Changes are written to the keys configuration file, which is then reloaded, so they are persisted and seen immediately
+type KeyService struct {
+	mu sync.Mutex
+}
+
+var (
+	keyServiceInstance *KeyService
+	keyServiceOnce     sync.Once
+)
+
+// GetKeyService returns the singleton instance of KeyService
+func GetKeyService() *KeyService {
+	keyServiceOnce.Do(func() {
+		keyServiceInstance = &KeyService{}
+	})
+	return keyServiceInstance
+}
+
+// List returns the configured virtual keys sorted by key
+func (k *KeyService) List() ([]models.VirtualKey, error) {
+	virtualKeys, _ := configs.Config("virtual_keys", "").(map[string]interface{})
+
+	keys := make([]models.VirtualKey, 0, len(virtualKeys))
+	for virtualKey, keyConfig := range virtualKeys {
+		key, err := decodeVirtualKey(virtualKey, keyConfig)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	return keys, nil
+}
+
+// Get returns the configuration of a virtual key
+func (k *KeyService) Get(virtualKey string) (models.VirtualKey, error) {
+	keyConfig := configs.VirtualKeyConfig(virtualKey)
+	if keyConfig == nil {
+		return models.VirtualKey{}, ErrKeyNotFound
+	}
+	return decodeVirtualKey(virtualKey, keyConfig)
+}
+
+// Create adds a virtual key, a random key is generated when none is given
+func (k *KeyService) Create(key models.VirtualKey) (models.VirtualKey, error) {
+	if key.Key == "" {
+		key.Key = GenerateVirtualKey()
+	}
+
+	err := k.transact(func(keys map[string]models.VirtualKey) error {
+		if _, ok := keys[key.Key]; ok {
+			return ErrKeyExists
+		}
+		keys[key.Key] = key
+		return nil
+	})
+	return key, err
+}
+
+// Update applies the update to the configuration of a virtual key
+func (k *KeyService) Update(virtualKey string, update func(key *models.VirtualKey)) (models.VirtualKey, error) {
+	var key models.VirtualKey
+	err := k.transact(func(keys map[string]models.VirtualKey) error {
+		var ok bool
+		if key, ok = keys[virtualKey]; !ok {
+			return ErrKeyNotFound
+		}
+		update(&key)
+		key.Key = virtualKey
+		keys[virtualKey] = key
+		return nil
+	})
+	return key, err
+}
+
+// Rotate replaces a virtual key with a newly generated one of the same configuration, the old key stops working immediately.
+// The quota usage and spend of the key move to the new key, so rotating it resets neither its quota nor its budget
+func (k *KeyService) Rotate(virtualKey string) (models.VirtualKey, error) {
+	var key models.VirtualKey
+	moved := false
+	err := k.transact(func(keys map[string]models.VirtualKey) error {
+		var ok bool
+		if key, ok = keys[virtualKey]; !ok {
+			return ErrKeyNotFound
+		}
+		delete(keys, virtualKey)
+		key.Key = GenerateVirtualKey()
+		keys[key.Key] = key
+
+		if err := GetQuotaService().MoveUsage(virtualKey, key.Key); err != nil {
+			return err
+		}
+		moved = true
+		return nil
+	})
+	// The old key is still configured, so it takes its usage back
+	if err != nil && moved {
+		if moveErr := GetQuotaService().MoveUsage(key.Key, virtualKey); moveErr != nil {
+			log.Printf("Failed to restore the quota usage of virtual key %s: %s", virtualKey, moveErr.Error())
+		}
+	}
+	return key, err
+}
+
+// Revoke removes a virtual key
+func (k *KeyService) Revoke(virtualKey string) error {
+	return k.transact(func(keys map[string]models.VirtualKey) error {
+		if _, ok := keys[virtualKey]; !ok {
+			return ErrKeyNotFound
+		}
+		delete(keys, virtualKey)
+		return nil
+	})
+}
+
+// transact passes the virtual keys of the config file to the callback, writes them back unless the callback fails
+// or leaves an invalid key, and reloads the configuration. The other sections of the file are kept as they are
+func (k *KeyService) transact(callback func(keys map[string]models.VirtualKey) error) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	path := configs.ConfigFile()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var config map[string]json.RawMessage
+	if err = json.Unmarshal(b, &config); err != nil {
+		return err
+	}
+	keys := make(map[string]models.VirtualKey)
+	if rawKeys, ok := config["virtual_keys"]; ok {
+		if err = json.Unmarshal(rawKeys, &keys); err != nil {
+			return err
+		}
+	}
+
+	if err = callback(keys); err != nil {
+		return err
+	}
+
+	for virtualKey, key := range keys {
+		if err = key.Validate(); err != nil {
+			return fmt.Errorf("%w %s: %s", ErrInvalidKey, virtualKey, err.Error())
+		}
+		// The key is the map key in the file
+		key.Key = ""
+		keys[virtualKey] = key
+	}
+	if config["virtual_keys"], err = json.Marshal(keys); err != nil {
+		return err
+	}
+	if b, err = json.MarshalIndent(config, "", "  "); err != nil {
+		return err
+	}
+	if err = writeFileAtomic(path, b); err != nil {
+		return err
+	}
+
+	return configs.ReloadConfig()
+}
+
+// GenerateVirtualKey returns a new random virtual key
+func GenerateVirtualKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "vk_" + hex.EncodeToString(b)
+}
+
+// decodeVirtualKey decodes the configuration of a virtual key as loaded by viper
+func decodeVirtualKey(virtualKey string, keyConfig interface{}) (models.VirtualKey, error) {
+	var key models.VirtualKey
+	if err := mapstructure.WeakDecode(keyConfig, &key); err != nil {
+		return models.VirtualKey{}, err
+	}
+	key.Key = virtualKey
+	return key, nil
+}
+
+// writeFileAtomic replaces the file through a temporary file, so readers never see a partially written file
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"qualifire-home-assignment/internal/configs"
+
+	"github.com/spf13/cast"
+)
+
+// RequestLimits defines the size limits of the requests of a virtual key, a zero limit means no limit
+type RequestLimits struct {
+	MaxMessages     int
+	MaxMessageChars int
+	MaxInputTokens  int
+	MaxBodyBytes    int
+}
+
+// GetRequestLimits returns the request size limits of a virtual key in the config snapshot.
+// Limits which aren't declared for the key in the keys configuration fall back to the global limits of the environment
+func GetRequestLimits(snapshot *configs.Snapshot, virtualKey string) RequestLimits {
+	limits := RequestLimits{
+		MaxMessages:     configs.EnvInt("MAX_MESSAGES", "100"),
+		MaxMessageChars: configs.EnvInt("MAX_MESSAGE_CHARS", "100000"),
+		MaxInputTokens:  configs.EnvInt("MAX_INPUT_TOKENS", "0"),
+		MaxBodyBytes:    configs.EnvInt("MAX_BODY_BYTES", "52428800"),
+	}
+
+	keyConfig := snapshot.VirtualKeyConfig(virtualKey)
+	if maxMessages, ok := keyConfig["max_messages"]; ok {
+		limits.MaxMessages = cast.ToInt(maxMessages)
+	}
+	if maxMessageChars, ok := keyConfig["max_message_chars"]; ok {
+		limits.MaxMessageChars = cast.ToInt(maxMessageChars)
+	}
+	if maxInputTokens, ok := keyConfig["max_input_tokens"]; ok {
+		limits.MaxInputTokens = cast.ToInt(maxInputTokens)
+	}
+	if maxBodyBytes, ok := keyConfig["max_body_bytes"]; ok {
+		limits.MaxBodyBytes = cast.ToInt(maxBodyBytes)
+	}
+
+	return limits
+}
@@ -1,26 +1,52 @@
 package services
 
 import (
+	"qualifire-home-assignment/internal/models"
 	"sync"
 	"time"
 )
 
 // RequestMetric tracks individual request metrics
 type RequestMetric struct {
-	Provider     string
+	Provider   string
+	VirtualKey string
+	Model      string
+	Duration   time.Duration
+	Status     int
+	Tokens     int
+	Usage      models.Usage
+	CostUSD    float64
+	// Cache is HIT, SEMANTIC_HIT or MISS when the request used the response cache, empty otherwise
+	Cache     string
+	Timestamp time.Time
+}
+
+// FailoverMetric tracks a single failover from a failed provider target to the next one
+type FailoverMetric struct {
 	VirtualKey   string
-	Duration     time.Duration
+	FromProvider string
+	ToProvider   string
 	Status       int
 	Timestamp    time.Time
 }
 
 // MetricsService collects and aggregates usage statistics
 type MetricsService struct {
-	metrics      []RequestMetric
-	mu           sync.RWMutex
-	totalRequests int
+	metrics        []RequestMetric
+	mu             sync.RWMutex
+	totalRequests  int
 	providerCounts map[string]int
-	totalDuration time.Duration
+	totalDuration  time.Duration
+	totalUsage     models.Usage
+	totalSpend     float64
+	providerSpend  map[string]float64
+	keySpend       map[string]float64
+	failovers      []FailoverMetric
+	failoverCounts map[string]int
+	requestSeries  map[requestSeriesKey]*requestSeries
+	failoverSeries map[failoverSeriesKey]int
+	cacheCounts    map[string]int
+	cacheSeries    map[cacheSeriesKey]int
 }
 
 var (
@@ -34,6 +60,14 @@ func GetMetricsService() *MetricsService {
 		metricsServiceInstance = &MetricsService{
 			metrics:        make([]RequestMetric, 0),
 			providerCounts: make(map[string]int),
+			providerSpend:  make(map[string]float64),
+			keySpend:       make(map[string]float64),
+			failovers:      make([]FailoverMetric, 0),
+			failoverCounts: make(map[string]int),
+			requestSeries:  make(map[requestSeriesKey]*requestSeries),
+			failoverSeries: make(map[failoverSeriesKey]int),
+			cacheCounts:    make(map[string]int),
+			cacheSeries:    make(map[cacheSeriesKey]int),
 		}
 	})
 	return metricsServiceInstance
@@ -48,6 +82,30 @@ func (m *MetricsService) RecordRequest(metric RequestMetric) {
 	m.totalRequests++
 	m.providerCounts[metric.Provider]++
 	m.totalDuration += metric.Duration
+	m.totalUsage.PromptTokens += metric.Usage.PromptTokens
+	m.totalUsage.CompletionTokens += metric.Usage.CompletionTokens
+	m.totalUsage.CachedTokens += metric.Usage.CachedTokens
+	m.totalSpend += metric.CostUSD
+	m.providerSpend[metric.Provider] += metric.CostUSD
+	m.keySpend[metric.VirtualKey] += metric.CostUSD
+	m.recordRequestSeries(metric)
+	if metric.Cache != "" {
+		m.cacheCounts[metric.Cache]++
+		m.cacheSeries[cacheSeriesKey{metric.VirtualKey, metric.Cache}]++
+	}
+}
+
+// RecordFailover records a failover between provider targets
+func (m *MetricsService) RecordFailover(metric FailoverMetric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if metric.Timestamp.IsZero() {
+		metric.Timestamp = time.Now()
+	}
+	m.failovers = append(m.failovers, metric)
+	m.failoverCounts[metric.FromProvider]++
+	m.failoverSeries[failoverSeriesKey{metric.VirtualKey, metric.FromProvider, metric.ToProvider}]++
 }
 
 // GetStats returns aggregated statistics
@@ -66,10 +124,36 @@ func (m *MetricsService) GetStats() map[string]interface{} {
 		providerCounts[k] = v
 	}
 
+	providerSpend := make(map[string]float64)
+	for k, v := range m.providerSpend {
+		providerSpend[k] = v
+	}
+	// The virtual keys are bearer credentials, their spend is reported by their fingerprint
+	keySpend := make(map[string]float64)
+	for k, v := range m.keySpend {
+		keySpend[models.Fingerprint(k)] = v
+	}
+
+	failoverCounts := make(map[string]int)
+	for k, v := range m.failoverCounts {
+		failoverCounts[k] = v
+	}
+
 	return map[string]interface{}{
-		"total_requests":          m.totalRequests,
-		"requests_per_provider":   providerCounts,
+		"total_requests":           m.totalRequests,
+		"requests_per_provider":    providerCounts,
 		"average_response_time_ms": avgResponseTime,
+		"total_prompt_tokens":      m.totalUsage.PromptTokens,
+		"total_completion_tokens":  m.totalUsage.CompletionTokens,
+		"total_cached_tokens":      m.totalUsage.CachedTokens,
+		"total_spend_usd":          m.totalSpend,
+		"spend_per_provider_usd":   providerSpend,
+		"spend_per_key_usd":        keySpend,
+		"total_failovers":          len(m.failovers),
+		"failovers_per_provider":   failoverCounts,
+		"cache_hits":               m.cacheCounts[models.CACHE_HIT],
+		"cache_misses":             m.cacheCounts[models.CACHE_MISS],
+		"semantic_cache_hits":      m.cacheCounts[models.CACHE_SEMANTIC_HIT],
 	}
 }
 
@@ -82,4 +166,14 @@ func (m *MetricsService) Reset() {
 	m.totalRequests = 0
 	m.providerCounts = make(map[string]int)
 	m.totalDuration = 0
+	m.totalUsage = models.Usage{}
+	m.totalSpend = 0
+	m.providerSpend = make(map[string]float64)
+	m.keySpend = make(map[string]float64)
+	m.failovers = make([]FailoverMetric, 0)
+	m.failoverCounts = make(map[string]int)
+	m.requestSeries = make(map[requestSeriesKey]*requestSeries)
+	m.failoverSeries = make(map[failoverSeriesKey]int)
+	m.cacheCounts = make(map[string]int)
+	m.cacheSeries = make(map[cacheSeriesKey]int)
 }
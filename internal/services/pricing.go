@@ -0,0 +1,99 @@
+package services
+
+import (
+	"log"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/models"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+// ModelPrice defines the price of a model in USD per million tokens
+type ModelPrice struct {
+	Input  float64 `mapstructure:"input"`
+	Output float64 `mapstructure:"output"`
+	// CachedInput is the price of prompt tokens served from the provider prompt cache, defaults to the input price
+	CachedInput float64 `mapstructure:"cached_input"`
+}
+
+// defaultModelPrices are the list prices of the common models, the "pricing" section of the keys configuration overrides them
+var defaultModelPrices = map[string]ModelPrice{
+	"gpt-4o":            {Input: 2.5, Output: 10, CachedInput: 1.25},
+	"gpt-4o-mini":       {Input: 0.15, Output: 0.6, CachedInput: 0.075},
+	"gpt-4.1":           {Input: 2, Output: 8, CachedInput: 0.5},
+	"gpt-4.1-mini":      {Input: 0.4, Output: 1.6, CachedInput: 0.1},
+	"gpt-4.1-nano":      {Input: 0.1, Output: 0.4, CachedInput: 0.025},
+	"gpt-4-turbo":       {Input: 10, Output: 30},
+	"gpt-4":             {Input: 30, Output: 60},
+	"gpt-3.5-turbo":     {Input: 0.5, Output: 1.5},
+	"o3-mini":           {Input: 1.1, Output: 4.4, CachedInput: 0.55},
+	"claude-3-5-sonnet": {Input: 3, Output: 15, CachedInput: 0.3},
+	"claude-3-5-haiku":  {Input: 0.8, Output: 4, CachedInput: 0.08},
+	"claude-3-opus":     {Input: 15, Output: 75, CachedInput: 1.5},
+	"claude-3-haiku":    {Input: 0.25, Output: 1.25, CachedInput: 0.03},
+	"claude-sonnet-4":   {Input: 3, Output: 15, CachedInput: 0.3},
+	"claude-opus-4":     {Input: 15, Output: 75, CachedInput: 1.5},
+	"gemini-2.5-pro":    {Input: 1.25, Output: 10, CachedInput: 0.31},
+	"gemini-2.5-flash":  {Input: 0.3, Output: 2.5, CachedInput: 0.075},
+	"gemini-2.0-flash":  {Input: 0.1, Output: 0.4, CachedInput: 0.025},
+	"gemini-1.5-pro":    {Input: 1.25, Output: 5},
+	"gemini-1.5-flash":  {Input: 0.075, Output: 0.3},
+}
+
+// GetModelPrice returns the price of a model in the config snapshot. Dated model versions, e.g. gpt-4o-2024-08-06,
+// are matched to the longest model name they start with
+func GetModelPrice(snapshot *configs.Snapshot, model string) (ModelPrice, bool) {
+	prices := getModelPrices(snapshot)
+	if price, ok := prices[model]; ok {
+		return price, true
+	}
+
+	var match string
+	for name := range prices {
+		if strings.HasPrefix(model, name) && len(name) > len(match) {
+			match = name
+		}
+	}
+	if match == "" {
+		return ModelPrice{}, false
+	}
+	return prices[match], true
+}
+
+// CalculateCost calculates the cost in USD of a request from its token usage and the prices of the config snapshot,
+// unknown models cost nothing
+func CalculateCost(snapshot *configs.Snapshot, model string, usage models.Usage) float64 {
+	price, ok := GetModelPrice(snapshot, model)
+	if !ok {
+		return 0
+	}
+
+	cachedInput := price.CachedInput
+	if cachedInput == 0 {
+		cachedInput = price.Input
+	}
+
+	uncachedTokens := usage.PromptTokens - usage.CachedTokens
+	return (float64(uncachedTokens)*price.Input +
+		float64(usage.CachedTokens)*cachedInput +
+		float64(usage.CompletionTokens)*price.Output) / 1_000_000
+}
+
+// getModelPrices merges the prices configured in the snapshot over the default ones
+func getModelPrices(snapshot *configs.Snapshot) map[string]ModelPrice {
+	prices := make(map[string]ModelPrice, len(defaultModelPrices))
+	for name, price := range defaultModelPrices {
+		prices[name] = price
+	}
+
+	configured := make(map[string]ModelPrice)
+	if err := mapstructure.Decode(snapshot.Config("pricing", ""), &configured); err != nil {
+		log.Printf("Failed to decode pricing configuration: %s", err.Error())
+	}
+	for name, price := range configured {
+		prices[name] = price
+	}
+
+	return prices
+}
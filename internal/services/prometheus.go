@@ -0,0 +1,235 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PROMETHEUS_CONTENT_TYPE is the content type of the Prometheus text exposition format
+const PROMETHEUS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// durationBuckets are the upper bounds, in seconds, of the request latency histogram buckets
+var durationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// requestSeriesKey identifies a single request series by its labels
+type requestSeriesKey struct {
+	Provider   string
+	VirtualKey string
+	Model      string
+	Status     int
+}
+
+// requestSeries aggregates the requests of a single series
+type requestSeries struct {
+	count           int
+	tokens          int
+	usage           models.Usage
+	spendUSD        float64
+	durationSum     float64
+	durationBuckets []int
+}
+
+// failoverSeriesKey identifies a single failover series by its labels
+type failoverSeriesKey struct {
+	VirtualKey   string
+	FromProvider string
+	ToProvider   string
+}
+
+// cacheSeriesKey identifies a single response cache series by its labels
+type cacheSeriesKey struct {
+	VirtualKey string
+	Result     string
+}
+
+// recordRequestSeries aggregates the request into its series, the caller must hold the lock
+func (m *MetricsService) recordRequestSeries(metric RequestMetric) {
+	key := requestSeriesKey{metric.Provider, metric.VirtualKey, metric.Model, metric.Status}
+	series, ok := m.requestSeries[key]
+	if !ok {
+		series = &requestSeries{durationBuckets: make([]int, len(durationBuckets))}
+		m.requestSeries[key] = series
+	}
+
+	seconds := metric.Duration.Seconds()
+	series.count++
+	series.tokens += metric.Tokens
+	series.usage.PromptTokens += metric.Usage.PromptTokens
+	series.usage.CompletionTokens += metric.Usage.CompletionTokens
+	series.usage.CachedTokens += metric.Usage.CachedTokens
+	series.spendUSD += metric.CostUSD
+	series.durationSum += seconds
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			series.durationBuckets[i]++
+		}
+	}
+}
+
+// WritePrometheus writes the request and failover metrics in the Prometheus text exposition format
+func (m *MetricsService) WritePrometheus(w io.Writer) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]requestSeriesKey, 0, len(m.requestSeries))
+	for key := range m.requestSeries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+
+	writeHeader(w, "llm_gateway_requests_total", "counter", "Total number of proxied requests.")
+	for _, key := range keys {
+		writeSample(w, "llm_gateway_requests_total", key.labels(), float64(m.requestSeries[key].count))
+	}
+
+	writeHeader(w, "llm_gateway_tokens_total", "counter", "Total number of tokens used by proxied requests.")
+	for _, key := range keys {
+		writeSample(w, "llm_gateway_tokens_total", key.labels(), float64(m.requestSeries[key].tokens))
+	}
+
+	writeHeader(w, "llm_gateway_prompt_tokens_total", "counter", "Total number of prompt tokens reported for proxied requests.")
+	for _, key := range keys {
+		writeSample(w, "llm_gateway_prompt_tokens_total", key.labels(), float64(m.requestSeries[key].usage.PromptTokens))
+	}
+
+	writeHeader(w, "llm_gateway_completion_tokens_total", "counter", "Total number of completion tokens reported for proxied requests.")
+	for _, key := range keys {
+		writeSample(w, "llm_gateway_completion_tokens_total", key.labels(), float64(m.requestSeries[key].usage.CompletionTokens))
+	}
+
+	writeHeader(w, "llm_gateway_cached_tokens_total", "counter", "Total number of prompt tokens served from the provider prompt cache.")
+	for _, key := range keys {
+		writeSample(w, "llm_gateway_cached_tokens_total", key.labels(), float64(m.requestSeries[key].usage.CachedTokens))
+	}
+
+	writeHeader(w, "llm_gateway_spend_usd_total", "counter", "Total cost in USD of proxied requests.")
+	for _, key := range keys {
+		writeSample(w, "llm_gateway_spend_usd_total", key.labels(), m.requestSeries[key].spendUSD)
+	}
+
+	writeHeader(w, "llm_gateway_request_duration_seconds", "histogram", "Latency of proxied requests in seconds.")
+	for _, key := range keys {
+		series := m.requestSeries[key]
+		for i, bound := range durationBuckets {
+			labels := append(key.labels(), "le", formatFloat(bound))
+			writeSample(w, "llm_gateway_request_duration_seconds_bucket", labels, float64(series.durationBuckets[i]))
+		}
+		writeSample(w, "llm_gateway_request_duration_seconds_bucket", append(key.labels(), "le", "+Inf"), float64(series.count))
+		writeSample(w, "llm_gateway_request_duration_seconds_sum", key.labels(), series.durationSum)
+		writeSample(w, "llm_gateway_request_duration_seconds_count", key.labels(), float64(series.count))
+	}
+
+	failoverKeys := make([]failoverSeriesKey, 0, len(m.failoverSeries))
+	for key := range m.failoverSeries {
+		failoverKeys = append(failoverKeys, key)
+	}
+	sort.Slice(failoverKeys, func(i, j int) bool {
+		return fmt.Sprint(failoverKeys[i]) < fmt.Sprint(failoverKeys[j])
+	})
+
+	writeHeader(w, "llm_gateway_failovers_total", "counter", "Total number of failovers between provider targets.")
+	for _, key := range failoverKeys {
+		labels := append(virtualKeyLabels(key.VirtualKey), "from_provider", key.FromProvider, "to_provider", key.ToProvider)
+		writeSample(w, "llm_gateway_failovers_total", labels, float64(m.failoverSeries[key]))
+	}
+
+	cacheKeys := make([]cacheSeriesKey, 0, len(m.cacheSeries))
+	for key := range m.cacheSeries {
+		cacheKeys = append(cacheKeys, key)
+	}
+	sort.Slice(cacheKeys, func(i, j int) bool {
+		return fmt.Sprint(cacheKeys[i]) < fmt.Sprint(cacheKeys[j])
+	})
+
+	writeHeader(w, "llm_gateway_cache_requests_total", "counter", "Total number of requests which used the response cache, by result.")
+	for _, key := range cacheKeys {
+		labels := append(virtualKeyLabels(key.VirtualKey), "result", strings.ToLower(key.Result))
+		writeSample(w, "llm_gateway_cache_requests_total", labels, float64(m.cacheSeries[key]))
+	}
+}
+
+// WritePrometheus writes the quota usage and limits of every tracked virtual key in the Prometheus text exposition format
+func (q *QuotaService) WritePrometheus(w io.Writer) {
+	virtualKeys := q.GetVirtualKeys()
+	snapshot := configs.Current()
+
+	writeHeader(w, "llm_gateway_quota_requests_used", "gauge", "Number of requests used in the current quota window.")
+	for _, virtualKey := range virtualKeys {
+		requests, _ := q.GetUsage(virtualKey)
+		writeSample(w, "llm_gateway_quota_requests_used", virtualKeyLabels(virtualKey), float64(requests))
+	}
+
+	writeHeader(w, "llm_gateway_quota_requests_limit", "gauge", "Maximum number of requests per quota window.")
+	for _, virtualKey := range virtualKeys {
+		writeSample(w, "llm_gateway_quota_requests_limit", virtualKeyLabels(virtualKey), float64(q.GetLimits(snapshot, virtualKey).MaxRequests))
+	}
+
+	writeHeader(w, "llm_gateway_quota_tokens_used", "gauge", "Number of tokens used in the current quota window.")
+	for _, virtualKey := range virtualKeys {
+		_, tokens := q.GetUsage(virtualKey)
+		writeSample(w, "llm_gateway_quota_tokens_used", virtualKeyLabels(virtualKey), float64(tokens))
+	}
+
+	writeHeader(w, "llm_gateway_quota_tokens_limit", "gauge", "Maximum number of tokens per quota window.")
+	for _, virtualKey := range virtualKeys {
+		writeSample(w, "llm_gateway_quota_tokens_limit", virtualKeyLabels(virtualKey), float64(q.GetLimits(snapshot, virtualKey).MaxTokens))
+	}
+
+	writeHeader(w, "llm_gateway_quota_spend_usd", "gauge", "Cumulative spend in USD.")
+	for _, virtualKey := range virtualKeys {
+		writeSample(w, "llm_gateway_quota_spend_usd", virtualKeyLabels(virtualKey), q.GetSpend(virtualKey))
+	}
+
+	writeHeader(w, "llm_gateway_quota_spend_limit_usd", "gauge", "Spend budget in USD, for keys which declare one.")
+	for _, virtualKey := range virtualKeys {
+		if maxSpend := q.GetLimits(snapshot, virtualKey).MaxSpendUSD; maxSpend > 0 {
+			writeSample(w, "llm_gateway_quota_spend_limit_usd", virtualKeyLabels(virtualKey), maxSpend)
+		}
+	}
+}
+
+// labels returns the label pairs of the request series
+func (k requestSeriesKey) labels() []string {
+	return []string{
+		"provider", k.Provider,
+		"virtual_key_fingerprint", models.Fingerprint(k.VirtualKey),
+		"model", k.Model,
+		"status", strconv.Itoa(k.Status),
+	}
+}
+
+// virtualKeyLabels returns the label pair of a virtual key, which is a bearer credential and is only exposed by its fingerprint
+func virtualKeyLabels(virtualKey string) []string {
+	return []string{"virtual_key_fingerprint", models.Fingerprint(virtualKey)}
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric family
+func writeHeader(w io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// writeSample writes a single sample line, the labels are given as name and value pairs
+func writeSample(w io.Writer, name string, labels []string, value float64) {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], escapeLabelValue(labels[i+1])))
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(value))
+}
+
+// escapeLabelValue escapes backslashes, double quotes and line feeds as required by the exposition format
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatFloat formats a sample value in its shortest representation
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
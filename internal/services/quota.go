@@ -1,24 +1,30 @@
 package services
 
 import (
+	"log"
+	"qualifire-home-assignment/internal/configs"
 	"sync"
 	"time"
+
+	"github.com/spf13/cast"
 )
 
-// QuotaEntry tracks usage for a virtual key
-type QuotaEntry struct {
-	RequestCount int
-	TokenUsage   int
-	WindowStart  time.Time
-	mu           sync.Mutex
+// QuotaLimits defines the quota limits applied to a virtual key
+type QuotaLimits struct {
+	MaxRequests  int
+	MaxTokens    int
+	WindowPeriod time.Duration
+	// MaxSpendUSD is the cumulative spend budget of the key, zero means no budget
+	MaxSpendUSD float64
 }
 
 // QuotaService manages quotas per virtual key
 type QuotaService struct {
-	quotas       sync.Map // map[string]*QuotaEntry
+	store        QuotaStore
 	maxRequests  int
 	maxTokens    int
 	windowPeriod time.Duration
+	mu           sync.RWMutex
 }
 
 var (
@@ -30,6 +36,7 @@ var (
 func GetQuotaService() *QuotaService {
 	quotaServiceOnce.Do(func() {
 		quotaServiceInstance = &QuotaService{
+			store:        NewQuotaStore(),
 			maxRequests:  100,    // 100 requests per hour
 			maxTokens:    100000, // 100k tokens per hour
 			windowPeriod: time.Hour,
@@ -38,92 +45,164 @@ func GetQuotaService() *QuotaService {
 	return quotaServiceInstance
 }
 
-// CheckQuota verifies if the virtual key has quota available
-func (q *QuotaService) CheckQuota(virtualKey string) (bool, string) {
-	entry := q.getOrCreateEntry(virtualKey)
-
-	entry.mu.Lock()
-	defer entry.mu.Unlock()
+// CheckQuota verifies if the virtual key has quota available under the limits of the config snapshot.
+// If the store is unavailable the request is allowed, so a storage outage doesn't take the gateway down
+func (q *QuotaService) CheckQuota(snapshot *configs.Snapshot, virtualKey string) (bool, string) {
+	limits := q.GetLimits(snapshot, virtualKey)
+	usage, err := q.getStore().Get(virtualKey, limits.WindowPeriod)
+	if err != nil {
+		log.Printf("Failed to read quota usage of virtual key %s: %s", virtualKey, err.Error())
+		return true, ""
+	}
 
-	now := time.Now()
+	reason := limits.exceeded(usage)
+	return reason == "", reason
+}
 
-	// Reset window if expired
-	if now.Sub(entry.WindowStart) >= q.windowPeriod {
-		entry.RequestCount = 0
-		entry.TokenUsage = 0
-		entry.TokenUsage = 0
-		entry.WindowStart = now
+// ReserveRequest counts a request of the virtual key if it has quota available under the limits of the config snapshot.
+// The check and the count are a single atomic update of the store, so concurrent requests, of this instance or of
+// instances sharing the store, can't all pass the check at the last request of the quota. The tokens and the spend
+// of the request are charged once it completes, with ChargeUsage.
+// If the store is unavailable the request is allowed, so a storage outage doesn't take the gateway down
+func (q *QuotaService) ReserveRequest(snapshot *configs.Snapshot, virtualKey string) (bool, string) {
+	reason, err := q.getStore().Reserve(virtualKey, q.GetLimits(snapshot, virtualKey))
+	if err != nil {
+		log.Printf("Failed to reserve quota of virtual key %s: %s", virtualKey, err.Error())
+		return true, ""
 	}
+	return reason == "", reason
+}
 
-	// Check request quota
-	if entry.RequestCount >= q.maxRequests {
-		return false, "request quota exceeded"
-	}
+// IncrementRequest increments the request count for a virtual key
+func (q *QuotaService) IncrementRequest(virtualKey string, tokens int) {
+	q.IncrementUsage(configs.Current(), virtualKey, tokens, 0)
+}
 
-	// Check token quota
-	if entry.TokenUsage >= q.maxTokens {
-		return false, "token quota exceeded"
+// IncrementUsage increments the request count, tokens and the cumulative spend for a virtual key, within the window
+// of the config snapshot
+func (q *QuotaService) IncrementUsage(snapshot *configs.Snapshot, virtualKey string, tokens int, costUSD float64) {
+	q.increment(snapshot, virtualKey, 1, tokens, costUSD)
+}
+
+// ChargeUsage adds the tokens and the spend of a request counted by ReserveRequest to the usage of the virtual key
+func (q *QuotaService) ChargeUsage(snapshot *configs.Snapshot, virtualKey string, tokens int, costUSD float64) {
+	q.increment(snapshot, virtualKey, 0, tokens, costUSD)
+}
+
+// increment adds the requests, tokens and spend to the usage of the virtual key, within the window of the config snapshot
+func (q *QuotaService) increment(snapshot *configs.Snapshot, virtualKey string, requests int, tokens int, costUSD float64) {
+	limits := q.GetLimits(snapshot, virtualKey)
+	if _, err := q.getStore().Increment(virtualKey, requests, tokens, costUSD, limits.WindowPeriod); err != nil {
+		log.Printf("Failed to increment quota usage of virtual key %s: %s", virtualKey, err.Error())
 	}
+}
 
-	// Check token quota
-	if entry.TokenUsage >= q.maxTokens {
-		return false, "token quota exceeded"
+// GetUsage returns the current usage for a virtual key
+func (q *QuotaService) GetUsage(virtualKey string) (requests int, tokens int) {
+	limits := q.GetLimits(configs.Current(), virtualKey)
+	usage, err := q.getStore().Get(virtualKey, limits.WindowPeriod)
+	if err != nil {
+		log.Printf("Failed to read quota usage of virtual key %s: %s", virtualKey, err.Error())
+		return 0, 0
 	}
 
-	return true, ""
+	return usage.RequestCount, usage.TokenUsage
 }
 
-// IncrementRequest increments the request count for a virtual key
-func (q *QuotaService) IncrementRequest(virtualKey string, tokens int) {
-	entry := q.getOrCreateEntry(virtualKey)
+// GetSpend returns the cumulative spend in USD of a virtual key
+func (q *QuotaService) GetSpend(virtualKey string) float64 {
+	limits := q.GetLimits(configs.Current(), virtualKey)
+	usage, err := q.getStore().Get(virtualKey, limits.WindowPeriod)
+	if err != nil {
+		log.Printf("Failed to read quota usage of virtual key %s: %s", virtualKey, err.Error())
+		return 0
+	}
 
-	entry.mu.Lock()
-	defer entry.mu.Unlock()
+	return usage.SpendUSD
+}
 
-	now := time.Now()
+// GetLimits returns the quota limits of a virtual key in the config snapshot.
+// Limits which aren't declared for the key in the keys configuration fall back to the global limits
+func (q *QuotaService) GetLimits(snapshot *configs.Snapshot, virtualKey string) QuotaLimits {
+	q.mu.RLock()
+	limits := QuotaLimits{
+		MaxRequests:  q.maxRequests,
+		MaxTokens:    q.maxTokens,
+		WindowPeriod: q.windowPeriod,
+	}
+	q.mu.RUnlock()
 
-	// Reset window if expired
-	if now.Sub(entry.WindowStart) >= q.windowPeriod {
-		entry.RequestCount = 0
-		entry.TokenUsage = 0
-		entry.WindowStart = now
+	keyConfig := snapshot.VirtualKeyConfig(virtualKey)
+	if maxRequests, ok := keyConfig["max_requests"]; ok {
+		limits.MaxRequests = cast.ToInt(maxRequests)
+	}
+	if maxTokens, ok := keyConfig["max_tokens"]; ok {
+		limits.MaxTokens = cast.ToInt(maxTokens)
+	}
+	if maxSpend, ok := keyConfig["max_spend_usd"]; ok {
+		limits.MaxSpendUSD = cast.ToFloat64(maxSpend)
+	}
+	// The window is declared as a duration string, e.g. "30m" or "24h"
+	if window, err := cast.ToDurationE(keyConfig["window"]); err == nil && window > 0 {
+		limits.WindowPeriod = window
 	}
 
-	entry.RequestCount++
-	entry.TokenUsage += tokens
+	return limits
 }
 
-// GetUsage returns the current usage for a virtual key
-func (q *QuotaService) GetUsage(virtualKey string) (requests int, tokens int) {
-	value, ok := q.quotas.Load(virtualKey)
-	if !ok {
-		return 0, 0
+// exceeded returns the limit the usage reached, or an empty string if the usage is within the limits
+func (l QuotaLimits) exceeded(usage QuotaUsage) string {
+	// Check request quota
+	if usage.RequestCount >= l.MaxRequests {
+		return "request quota exceeded"
+	}
+
+	// Check token quota
+	if usage.TokenUsage >= l.MaxTokens {
+		return "token quota exceeded"
+	}
+
+	// Check spend budget
+	if l.MaxSpendUSD > 0 && usage.SpendUSD >= l.MaxSpendUSD {
+		return "spend budget exceeded"
 	}
 
-	entry := value.(*QuotaEntry)
-	entry.mu.Lock()
-	defer entry.mu.Unlock()
+	return ""
+}
 
-	now := time.Now()
+// MoveUsage transfers the usage and spend of a virtual key to another one, so a rotated key keeps its quota and budget
+func (q *QuotaService) MoveUsage(from string, to string) error {
+	return q.getStore().Move(from, to)
+}
 
-	// Return 0 if window expired
-	if now.Sub(entry.WindowStart) >= q.windowPeriod {
-		return 0, 0
+// GetVirtualKeys returns the virtual keys tracked by the quota store
+func (q *QuotaService) GetVirtualKeys() []string {
+	virtualKeys, err := q.getStore().Keys()
+	if err != nil {
+		log.Printf("Failed to list quota usage: %s", err.Error())
 	}
+	return virtualKeys
+}
 
-	return entry.RequestCount, entry.TokenUsage
+// getStore returns the current quota store
+func (q *QuotaService) getStore() QuotaStore {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.store
 }
 
-// getOrCreateEntry gets or creates a quota entry for a virtual key
-func (q *QuotaService) getOrCreateEntry(virtualKey string) *QuotaEntry {
-	value, _ := q.quotas.LoadOrStore(virtualKey, &QuotaEntry{
-		WindowStart: time.Now(),
-	})
-	return value.(*QuotaEntry)
+// SetStore replaces the quota storage backend (useful for testing)
+func (q *QuotaService) SetStore(store QuotaStore) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.store = store
 }
 
-// SetLimits allows updating quota limits (useful for testing)
+// SetLimits allows updating the global quota limits, which apply to keys that don't declare their own (useful for testing)
 func (q *QuotaService) SetLimits(maxRequests, maxTokens int, windowPeriod time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
 	q.maxRequests = maxRequests
 	q.maxTokens = maxTokens
 	q.windowPeriod = windowPeriod
@@ -131,8 +210,7 @@ func (q *QuotaService) SetLimits(maxRequests, maxTokens int, windowPeriod time.D
 
 // Reset clears all quota entries (useful for testing)
 func (q *QuotaService) Reset() {
-	q.quotas.Range(func(key, value interface{}) bool {
-		q.quotas.Delete(key)
-		return true
-	})
+	if err := q.getStore().Reset(); err != nil {
+		log.Printf("Failed to reset quota usage: %s", err.Error())
+	}
 }
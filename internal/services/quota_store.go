@@ -0,0 +1,158 @@
+package services
+
+import (
+	"qualifire-home-assignment/internal/configs"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	MEMORY_QUOTA_STORE = "memory"
+	FILE_QUOTA_STORE   = "file"
+)
+
+// QuotaUsage represents the usage of a virtual key in its current quota window
+type QuotaUsage struct {
+	RequestCount int       `json:"request_count"`
+	TokenUsage   int       `json:"token_usage"`
+	WindowStart  time.Time `json:"window_start"`
+	// SpendUSD is the cumulative spend of the key, which isn't reset with the window
+	SpendUSD float64 `json:"spend_usd"`
+}
+
+// QuotaStore defines the interface for the quota usage storage backends.
+// Implementations must apply every update atomically, so gateway instances sharing a store enforce a single limit
+type QuotaStore interface {
+	// Increment adds the requests, tokens and spend to the usage of the key, starting a new window if the current one expired
+	Increment(virtualKey string, requests int, tokens int, spendUSD float64, window time.Duration) (QuotaUsage, error)
+	// Reserve counts a request of the key unless its usage in the current window reached the limits, in which case
+	// it returns the limit which was reached. The check and the count are a single atomic update
+	Reserve(virtualKey string, limits QuotaLimits) (string, error)
+	// Get returns the usage of the key in its current window
+	Get(virtualKey string, window time.Duration) (QuotaUsage, error)
+	// Move transfers the usage of a key to another key, e.g. when the key is rotated
+	Move(from string, to string) error
+	// Keys returns the tracked virtual keys
+	Keys() ([]string, error)
+	// Reset clears the usage of all keys
+	Reset() error
+}
+
+// NewQuotaStore creates the quota store configured by the QUOTA_STORE environment variable
+func NewQuotaStore() QuotaStore {
+	switch configs.Env("QUOTA_STORE", MEMORY_QUOTA_STORE) {
+	case FILE_QUOTA_STORE:
+		return NewFileQuotaStore(configs.Env("QUOTA_STORE_PATH", "quota_usage.json"))
+	default:
+		return NewMemoryQuotaStore()
+	}
+}
+
+// QuotaEntry tracks usage for a virtual key
+type QuotaEntry struct {
+	QuotaUsage
+	mu sync.Mutex
+}
+
+// MemoryQuotaStore keeps the quota usage in process memory, so it's reset on restart and isn't shared between instances
+type MemoryQuotaStore struct {
+	quotas sync.Map // map[string]*QuotaEntry
+}
+
+// NewMemoryQuotaStore creates an in-memory quota store
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{}
+}
+
+// Increment adds the requests, tokens and spend to the usage of the key
+func (s *MemoryQuotaStore) Increment(virtualKey string, requests int, tokens int, spendUSD float64, window time.Duration) (QuotaUsage, error) {
+	value, _ := s.quotas.LoadOrStore(virtualKey, &QuotaEntry{
+		QuotaUsage: QuotaUsage{WindowStart: time.Now()},
+	})
+	entry := value.(*QuotaEntry)
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	entry.QuotaUsage = entry.QuotaUsage.increment(requests, tokens, spendUSD, window, time.Now())
+	return entry.QuotaUsage, nil
+}
+
+// Reserve counts a request of the key unless its usage reached the limits, under the lock of the key entry
+func (s *MemoryQuotaStore) Reserve(virtualKey string, limits QuotaLimits) (string, error) {
+	value, _ := s.quotas.LoadOrStore(virtualKey, &QuotaEntry{
+		QuotaUsage: QuotaUsage{WindowStart: time.Now()},
+	})
+	entry := value.(*QuotaEntry)
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	usage := entry.QuotaUsage.current(limits.WindowPeriod, time.Now())
+	if reason := limits.exceeded(usage); reason != "" {
+		return reason, nil
+	}
+	usage.RequestCount++
+	entry.QuotaUsage = usage
+	return "", nil
+}
+
+// Get returns the usage of the key in its current window
+func (s *MemoryQuotaStore) Get(virtualKey string, window time.Duration) (QuotaUsage, error) {
+	value, ok := s.quotas.Load(virtualKey)
+	if !ok {
+		return QuotaUsage{}, nil
+	}
+
+	entry := value.(*QuotaEntry)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	return entry.QuotaUsage.current(window, time.Now()), nil
+}
+
+// Move transfers the usage of a key to another key
+func (s *MemoryQuotaStore) Move(from string, to string) error {
+	if value, ok := s.quotas.LoadAndDelete(from); ok {
+		s.quotas.Store(to, value)
+	}
+	return nil
+}
+
+// Keys returns the tracked virtual keys
+func (s *MemoryQuotaStore) Keys() ([]string, error) {
+	virtualKeys := make([]string, 0)
+	s.quotas.Range(func(key, value interface{}) bool {
+		virtualKeys = append(virtualKeys, key.(string))
+		return true
+	})
+	sort.Strings(virtualKeys)
+	return virtualKeys, nil
+}
+
+// Reset clears the usage of all keys
+func (s *MemoryQuotaStore) Reset() error {
+	s.quotas.Range(func(key, value interface{}) bool {
+		s.quotas.Delete(key)
+		return true
+	})
+	return nil
+}
+
+// current returns the usage in the current window, which has no requests and tokens if the window expired
+func (u QuotaUsage) current(window time.Duration, now time.Time) QuotaUsage {
+	if now.Sub(u.WindowStart) >= window {
+		return QuotaUsage{WindowStart: now, SpendUSD: u.SpendUSD}
+	}
+	return u
+}
+
+// increment returns the usage with the added requests, tokens and spend, resetting the window if expired
+func (u QuotaUsage) increment(requests int, tokens int, spendUSD float64, window time.Duration, now time.Time) QuotaUsage {
+	u = u.current(window, now)
+	u.RequestCount += requests
+	u.TokenUsage += tokens
+	u.SpendUSD += spendUSD
+	return u
+}
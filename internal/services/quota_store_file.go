@@ -0,0 +1,154 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileQuotaStore keeps the quota usage in a JSON file, so it survives restarts.
+// Every update is a read-modify-write under an exclusive file lock, hence instances sharing the file enforce a single limit
+type FileQuotaStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileQuotaStore creates a quota store backed by the file at the given path
+func NewFileQuotaStore(path string) *FileQuotaStore {
+	return &FileQuotaStore{path: path}
+}
+
+// Increment adds the requests, tokens and spend to the usage of the key
+func (s *FileQuotaStore) Increment(virtualKey string, requests int, tokens int, spendUSD float64, window time.Duration) (QuotaUsage, error) {
+	var usage QuotaUsage
+	err := s.transact(func(quotas map[string]QuotaUsage) bool {
+		now := time.Now()
+		current, ok := quotas[virtualKey]
+		if !ok {
+			current = QuotaUsage{WindowStart: now}
+		}
+		usage = current.increment(requests, tokens, spendUSD, window, now)
+		quotas[virtualKey] = usage
+		return true
+	})
+	return usage, err
+}
+
+// Reserve counts a request of the key unless its usage reached the limits, under the file lock
+func (s *FileQuotaStore) Reserve(virtualKey string, limits QuotaLimits) (string, error) {
+	var reason string
+	err := s.transact(func(quotas map[string]QuotaUsage) bool {
+		now := time.Now()
+		current, ok := quotas[virtualKey]
+		if !ok {
+			current = QuotaUsage{WindowStart: now}
+		}
+		usage := current.current(limits.WindowPeriod, now)
+		if reason = limits.exceeded(usage); reason != "" {
+			return false
+		}
+		usage.RequestCount++
+		quotas[virtualKey] = usage
+		return true
+	})
+	return reason, err
+}
+
+// Get returns the usage of the key in its current window
+func (s *FileQuotaStore) Get(virtualKey string, window time.Duration) (QuotaUsage, error) {
+	var usage QuotaUsage
+	err := s.transact(func(quotas map[string]QuotaUsage) bool {
+		if current, ok := quotas[virtualKey]; ok {
+			usage = current.current(window, time.Now())
+		}
+		return false
+	})
+	return usage, err
+}
+
+// Move transfers the usage of a key to another key
+func (s *FileQuotaStore) Move(from string, to string) error {
+	return s.transact(func(quotas map[string]QuotaUsage) bool {
+		usage, ok := quotas[from]
+		if !ok {
+			return false
+		}
+		delete(quotas, from)
+		quotas[to] = usage
+		return true
+	})
+}
+
+// Keys returns the tracked virtual keys
+func (s *FileQuotaStore) Keys() ([]string, error) {
+	virtualKeys := make([]string, 0)
+	err := s.transact(func(quotas map[string]QuotaUsage) bool {
+		for virtualKey := range quotas {
+			virtualKeys = append(virtualKeys, virtualKey)
+		}
+		return false
+	})
+	sort.Strings(virtualKeys)
+	return virtualKeys, err
+}
+
+// Reset clears the usage of all keys
+func (s *FileQuotaStore) Reset() error {
+	return s.transact(func(quotas map[string]QuotaUsage) bool {
+		clear(quotas)
+		return true
+	})
+}
+
+// transact locks the store, passes the stored usage to the callback and writes it back if the callback reports a change
+func (s *FileQuotaStore) transact(callback func(quotas map[string]QuotaUsage) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	quotas, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	if !callback(quotas) {
+		return nil
+	}
+	return s.write(quotas)
+}
+
+// read reads the stored usage, a missing file is an empty store
+func (s *FileQuotaStore) read() (map[string]QuotaUsage, error) {
+	quotas := make(map[string]QuotaUsage)
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return quotas, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) > 0 {
+		if err = json.Unmarshal(b, &quotas); err != nil {
+			return nil, err
+		}
+	}
+	return quotas, nil
+}
+
+// write replaces the stored usage through a temporary file, so readers never see a partially written file
+func (s *FileQuotaStore) write(quotas map[string]QuotaUsage) error {
+	b, err := json.Marshal(quotas)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, b)
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"qualifire-home-assignment/internal/configs"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// SemanticCacheService caches the responses of the requests by the embedding of their last user message, so
+// rephrased questions are served the answer of the original one. The responses are only shared within the scope
+// of a virtual key and a model, between the requests of the same conversation, and each scope keeps its most recent entries
+type SemanticCacheService struct {
+	embedder   Embedder
+	scopes     map[string][]semanticEntry
+	maxEntries int
+	ttl        time.Duration
+	mu         sync.RWMutex
+}
+
+// semanticEntry represents a single cached response and the embedding of its question
+type semanticEntry struct {
+	// conversation is the hash of everything the request sent but its last user message, which must match exactly
+	conversation string
+	vector       []float64
+	value        interface{}
+	storedAt     time.Time
+}
+
+var (
+	semanticCacheServiceInstance *SemanticCacheService
+	semanticCacheServiceOnce     sync.Once
+)
+
+// GetSemanticCacheService returns the singleton instance of SemanticCacheService
+func GetSemanticCacheService() *SemanticCacheService {
+	semanticCacheServiceOnce.Do(func() {
+		semanticCacheServiceInstance = &SemanticCacheService{
+			embedder:   NewEmbedder(),
+			scopes:     make(map[string][]semanticEntry),
+			maxEntries: configs.EnvInt("SEMANTIC_CACHE_MAX_ENTRIES", "1000"),
+			ttl:        time.Duration(configs.EnvInt("CACHE_TTL", "3600")) * time.Second,
+		}
+	})
+	return semanticCacheServiceInstance
+}
+
+// Embed returns the embedding of the text by the configured embedder
+func (s *SemanticCacheService) Embed(ctx context.Context, text string) ([]float64, error) {
+	s.mu.RLock()
+	embedder := s.embedder
+	s.mu.RUnlock()
+	return embedder.Embed(ctx, text)
+}
+
+// Search returns the cached value of the conversation whose embedding is the most similar to the vector in the scope,
+// its similarity and its age. Only the entries at least as similar as the threshold, and younger than maxAge unless
+// it's zero, match
+func (s *SemanticCacheService) Search(scope string, conversation string, vector []float64, threshold float64, maxAge time.Duration) (interface{}, float64, time.Duration, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var best *semanticEntry
+	bestSimilarity := threshold
+	for i, entry := range s.scopes[scope] {
+		age := time.Since(entry.storedAt)
+		if entry.conversation != conversation || age > s.ttl || (maxAge > 0 && age > maxAge) {
+			continue
+		}
+		if similarity := CosineSimilarity(vector, entry.vector); similarity >= bestSimilarity {
+			best, bestSimilarity = &s.scopes[scope][i], similarity
+		}
+	}
+	if best == nil {
+		return nil, 0, 0, false
+	}
+	return best.value, bestSimilarity, time.Since(best.storedAt), true
+}
+
+// Add stores the value of the conversation under the embedding in the scope, dropping the expired entries and
+// the oldest ones beyond the maximum number of entries of a scope
+func (s *SemanticCacheService) Add(scope string, conversation string, vector []float64, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]semanticEntry, 0, len(s.scopes[scope])+1)
+	for _, entry := range s.scopes[scope] {
+		if time.Since(entry.storedAt) <= s.ttl {
+			entries = append(entries, entry)
+		}
+	}
+	entries = append(entries, semanticEntry{conversation: conversation, vector: vector, value: value, storedAt: time.Now()})
+	if s.maxEntries > 0 && len(entries) > s.maxEntries {
+		entries = entries[len(entries)-s.maxEntries:]
+	}
+	s.scopes[scope] = entries
+}
+
+// SetEmbedder replaces the embedder, the cached entries embedded by the previous one are dropped
+func (s *SemanticCacheService) SetEmbedder(embedder Embedder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.embedder = embedder
+	s.scopes = make(map[string][]semanticEntry)
+}
+
+// Reset clears the cache (useful for testing)
+func (s *SemanticCacheService) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scopes = make(map[string][]semanticEntry)
+}
+
+// GetSemanticCacheThreshold returns the minimum similarity of a semantic cache hit for the virtual key in the config snapshot.
+// Keys which enable the semantic cache in the keys configuration may override the global threshold, and a zero
+// threshold means the semantic cache is disabled for the key
+func GetSemanticCacheThreshold(snapshot *configs.Snapshot, virtualKey string) float64 {
+	keyConfig := snapshot.VirtualKeyConfig(virtualKey)
+	if !cast.ToBool(keyConfig["semantic_cache"]) {
+		return 0
+	}
+	if threshold, ok := keyConfig["semantic_cache_threshold"]; ok {
+		return cast.ToFloat64(threshold)
+	}
+	return cast.ToFloat64(configs.Env("SEMANTIC_CACHE_THRESHOLD", "0.9"))
+}
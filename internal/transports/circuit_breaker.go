@@ -0,0 +1,37 @@
+package transports
+
+import (
+	"net/http"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/services"
+)
+
+// CircuitBreakerTransport is an http.RoundTripper that fails fast the requests to a provider target whose circuit
+// is open, and records the outcome of the others. A request counts as failed on a transport error, a timeout
+// or a server error, once its retries are exhausted
+type CircuitBreakerTransport struct {
+	Base http.RoundTripper
+	Req  models.ProxyRequest
+}
+
+func (t *CircuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	breakers := services.GetCircuitBreakerService()
+	key := services.NewBreakerKey(t.Req.Provider, req.URL.Scheme+"://"+req.URL.Host, t.Req.ApiKey)
+	if err := breakers.Allow(key); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base().RoundTrip(req)
+	canceled := req.Context().Err() != nil
+	failed := err != nil || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= http.StatusInternalServerError
+	breakers.Record(key, failed, canceled)
+	return resp, err
+}
+
+// Fallback base transport
+func (t *CircuitBreakerTransport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
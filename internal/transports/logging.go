@@ -7,9 +7,13 @@ import (
 	"net/http"
 	"qualifire-home-assignment/internal/loggers"
 	"qualifire-home-assignment/internal/models"
+	"sync"
 	"time"
 )
 
+// RESPONSE_LOG_LIMIT is the number of bytes of a response body which are captured for the logs
+const RESPONSE_LOG_LIMIT = 2048
+
 // LoggingTransport is an http.RoundTripper that logs the request method and duration
 type LoggingTransport struct {
 	Base http.RoundTripper
@@ -37,6 +41,7 @@ func (t *LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error)
 		Provider:    t.Req.Provider,
 		VirtualKey:  t.Req.VirtualKey,
 		RequestBody: data,
+		Attempt:     AttemptFromContext(req.Context()),
 	}
 
 	l := loggers.Logger{Entry: entry}
@@ -48,28 +53,51 @@ func (t *LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error)
 	}
 
 	l.Entry.Status = resp.StatusCode
-	// Use TeeReader to capture streamed data as it’s read
-	var buf bytes.Buffer
-	resp.Body = io.NopCloser(io.TeeReader(resp.Body, &buf))
+	// The response is logged once the caller read it to the end or closed it, so every attempt is logged,
+	// including the ones the retry transport discards
+	resp.Body = &loggingBody{ReadCloser: resp.Body, logger: l}
+	return resp, nil
+}
 
-	// When the caller finishes reading, log asynchronously
-	go func() {
-		// Wait until caller closes Body (i.e., finishes reading)
-		defer func() {
-			res := truncate(buf.String(), 2048)
-			if err = json.Unmarshal(res, &data); err != nil {
-				return
-			}
-			l.Entry.ResponseBody = data
-			if l.Entry.Status >= 400 {
-				l.Error()
-			} else {
-				l.Info()
-			}
-		}()
-	}()
+// loggingBody captures the beginning of a response body as it's read,
+// and logs the response once the body is read to the end or closed
+type loggingBody struct {
+	io.ReadCloser
+	logger loggers.Logger
+	buf    bytes.Buffer
+	once   sync.Once
+}
 
-	return resp, nil
+func (b *loggingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if remaining := RESPONSE_LOG_LIMIT - b.buf.Len(); remaining > 0 {
+		b.buf.Write(p[:min(n, remaining)])
+	}
+	if err == io.EOF {
+		b.log()
+	}
+	return n, err
+}
+
+func (b *loggingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.log()
+	return err
+}
+
+// log logs the response once, with its body if it's a JSON object which fits in RESPONSE_LOG_LIMIT
+func (b *loggingBody) log() {
+	b.once.Do(func() {
+		var data map[string]interface{}
+		if err := json.Unmarshal(b.buf.Bytes(), &data); err == nil {
+			b.logger.Entry.ResponseBody = data
+		}
+		if b.logger.Entry.Status >= 400 {
+			b.logger.Error()
+		} else {
+			b.logger.Info()
+		}
+	})
 }
 
 // Fallback base transport
@@ -79,11 +107,3 @@ func (t *LoggingTransport) base() http.RoundTripper {
 	}
 	return http.DefaultTransport
 }
-
-// Optional truncation helper to avoid huge logs
-func truncate(s string, limit int) []byte {
-	if len(s) <= limit {
-		return []byte(s)
-	}
-	return []byte(s[:limit] + "...(truncated)")
-}
@@ -0,0 +1,244 @@
+package transports
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/loggers"
+	"qualifire-home-assignment/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// attemptKey is the context key of the attempt number of a request
+type attemptKey struct{}
+
+// RetryPolicy defines how the failed attempts of a provider request are retried
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, it doubles on each retry up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// RetryOn are the response status codes which are retried, transport errors are always retried
+	RetryOn []int
+	// Budget bounds the total time of the attempts and the delays between them
+	Budget time.Duration
+	// AttemptTimeout bounds each attempt, including the read of its response body, zero means no bound
+	AttemptTimeout time.Duration
+}
+
+// NewRetryPolicy returns the retry policy configured in the environment, whose attempts are bounded by attemptTimeout
+func NewRetryPolicy(attemptTimeout time.Duration) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts:    configs.EnvInt("PROVIDER_MAX_RETRIES", "0") + 1,
+		BaseDelay:      time.Duration(configs.EnvInt("PROVIDER_RETRY_BASE_DELAY_MS", "500")) * time.Millisecond,
+		MaxDelay:       time.Duration(configs.EnvInt("PROVIDER_RETRY_MAX_DELAY_MS", "10000")) * time.Millisecond,
+		Budget:         time.Duration(configs.EnvInt("PROVIDER_RETRY_BUDGET", "60")) * time.Second,
+		AttemptTimeout: attemptTimeout,
+	}
+	for _, status := range strings.Split(configs.Env("PROVIDER_RETRY_ON", "408,409,429,500,502,503,504,529"), ",") {
+		if code, err := strconv.Atoi(strings.TrimSpace(status)); err == nil {
+			policy.RetryOn = append(policy.RetryOn, code)
+		}
+	}
+	return policy
+}
+
+// Except returns a copy of the policy which doesn't retry the given status
+func (p RetryPolicy) Except(status int) RetryPolicy {
+	retryOn := make([]int, 0, len(p.RetryOn))
+	for _, code := range p.RetryOn {
+		if code != status {
+			retryOn = append(retryOn, code)
+		}
+	}
+	p.RetryOn = retryOn
+	return p
+}
+
+// Timeout returns the maximum total time of a request: its attempts and the delays between them.
+// The last attempt may start just before the budget runs out
+func (p RetryPolicy) Timeout() time.Duration {
+	if p.MaxAttempts <= 1 {
+		return p.AttemptTimeout
+	}
+	return p.Budget + p.AttemptTimeout
+}
+
+// RetryTransport is an http.RoundTripper that retries the failed attempts of a request with a jittered exponential
+// backoff. A Retry-After delay of the provider takes precedence over the backoff, and no attempt is made which
+// couldn't start within the time budget
+type RetryTransport struct {
+	Base   http.RoundTripper
+	Policy RetryPolicy
+	Req    models.ProxyRequest
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The body is read once, so it can be sent again by each attempt
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	deadline := time.Now().Add(t.Policy.Budget)
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := t.attemptContext(req.Context(), attempt)
+		attemptReq := req.Clone(ctx)
+		if body != nil {
+			attemptReq.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		resp, err := t.base().RoundTrip(attemptReq)
+		delay, retry := t.retryDelay(req.Context(), attempt, resp, err, deadline)
+		if !retry {
+			if resp == nil {
+				cancel()
+				return nil, err
+			}
+			// The attempt timeout keeps bounding the read of the response body, until it's closed
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+
+		t.logRetry(attempt, resp, err, delay)
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+		cancel()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// attemptContext returns the context of an attempt, which carries the attempt number and its timeout
+func (t *RetryTransport) attemptContext(ctx context.Context, attempt int) (context.Context, context.CancelFunc) {
+	ctx = context.WithValue(ctx, attemptKey{}, attempt)
+	if t.Policy.AttemptTimeout > 0 {
+		return context.WithTimeout(ctx, t.Policy.AttemptTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// retryDelay returns the delay before the next attempt, and false if the attempt mustn't be retried:
+// it succeeded or failed with a status which isn't retried, the attempts are exhausted, the request was canceled
+// or the next attempt couldn't start within the budget
+func (t *RetryTransport) retryDelay(ctx context.Context, attempt int, resp *http.Response, err error, deadline time.Time) (time.Duration, bool) {
+	if attempt >= t.Policy.MaxAttempts || ctx.Err() != nil {
+		return 0, false
+	}
+	if err == nil && !t.isRetried(resp.StatusCode) {
+		return 0, false
+	}
+
+	delay := t.backoff(attempt)
+	if resp != nil {
+		if retryAfter, ok := RetryAfter(resp.Header); ok {
+			delay = retryAfter
+		}
+	}
+	if time.Now().Add(delay).After(deadline) {
+		return 0, false
+	}
+	return delay, true
+}
+
+// isRetried tells whether the status code is retried
+func (t *RetryTransport) isRetried(status int) bool {
+	for _, code := range t.Policy.RetryOn {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the jittered exponential delay after the attempt, between half and all of the exponential delay
+func (t *RetryTransport) backoff(attempt int) time.Duration {
+	delay := t.Policy.BaseDelay
+	for i := 1; i < attempt && delay < t.Policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, t.Policy.MaxDelay)
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// logRetry records the failed attempt and the delay before the next one in the structured logs
+func (t *RetryTransport) logRetry(attempt int, resp *http.Response, err error, delay time.Duration) {
+	entry := &models.LogEntry{
+		Timestamp:  time.Now().Format(time.RFC3339),
+		Provider:   t.Req.Provider,
+		VirtualKey: t.Req.VirtualKey,
+		Attempt:    attempt,
+		RetryInMS:  delay.Milliseconds(),
+	}
+	if err != nil {
+		entry.Error = "transport error: " + err.Error()
+	} else {
+		entry.Status = resp.StatusCode
+		entry.Error = "retryable status " + strconv.Itoa(resp.StatusCode)
+	}
+	loggers.Logger{Entry: entry}.Error()
+}
+
+// Fallback base transport
+func (t *RetryTransport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// RetryAfter returns the delay the provider asked for in the retry-after-ms or Retry-After headers.
+// Retry-After is either a number of seconds or a HTTP date
+func RetryAfter(header http.Header) (time.Duration, bool) {
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	value := header.Get("Retry-After")
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// AttemptFromContext returns the attempt number of the request, 0 outside of a RetryTransport
+func AttemptFromContext(ctx context.Context) int {
+	attempt, _ := ctx.Value(attemptKey{}).(int)
+	return attempt
+}
+
+// cancelBody releases the context of the attempt once its response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -1,10 +1,46 @@
 package utils
 
 import (
+	"log"
 	"qualifire-home-assignment/internal/models"
+	"strings"
+	"sync"
 	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktokenloader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+const (
+	CL100K_BASE = tiktoken.MODEL_CL100K_BASE
+	O200K_BASE  = tiktoken.MODEL_O200K_BASE
 )
 
+// modelPrefixToEncoding maps model families missing from the tiktoken tables to their encodings
+var modelPrefixToEncoding = map[string]string{
+	"gpt-5":      O200K_BASE,
+	"chatgpt-4o": O200K_BASE,
+	"o1":         O200K_BASE,
+	"o3":         O200K_BASE,
+	"o4":         O200K_BASE,
+	// Anthropic doesn't publish its vocabulary, so Claude models are approximated with cl100k
+	"claude": CL100K_BASE,
+}
+
+// encoders caches the loaded encoders, map[string]*encoder
+var encoders sync.Map
+
+// encoder lazily loads a BPE encoding from the bundled vocabularies
+type encoder struct {
+	once     sync.Once
+	tiktoken *tiktoken.Tiktoken
+}
+
+func init() {
+	// Use the vocabularies bundled into the binary instead of downloading them on first use
+	tiktoken.SetBpeLoader(tiktokenloader.NewOfflineLoader())
+}
+
 // EstimateTokens estimates the number of tokens in a text string
 // Uses the approximation: 1 token ≈ 4 characters (OpenAI/Anthropic standard)
 func EstimateTokens(text string) int {
@@ -16,6 +52,38 @@ func EstimateTokens(text string) int {
 	return tokens
 }
 
+// EncodingForModel returns the name of the BPE encoding used by the model, cl100k is used for unknown models
+func EncodingForModel(model string) string {
+	if encoding, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		return encoding
+	}
+	for prefix, encoding := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(model, prefix) {
+			return encoding
+		}
+	}
+	for prefix, encoding := range modelPrefixToEncoding {
+		if strings.HasPrefix(model, prefix) {
+			return encoding
+		}
+	}
+	return CL100K_BASE
+}
+
+// CountTokens counts the tokens of a text string with the BPE encoding of the model.
+// Falls back to the estimation if the encoding can't be loaded
+func CountTokens(text string, model string) int {
+	if text == "" {
+		return 0
+	}
+
+	tokenizer := getTokenizer(EncodingForModel(model))
+	if tokenizer == nil {
+		return EstimateTokens(text)
+	}
+	return len(tokenizer.EncodeOrdinary(text))
+}
+
 // CalculateRequestTokens calculates total tokens for a chat completion request
 // Includes tokens from all messages plus overhead for formatting
 func CalculateRequestTokens(messages []models.Message, model string) int {
@@ -23,26 +91,40 @@ func CalculateRequestTokens(messages []models.Message, model string) int {
 
 	// Count tokens in each message
 	for _, msg := range messages {
-		// Role tokens (typically 1 token for role)
-		totalTokens += 1
+		// Role tokens
+		totalTokens += CountTokens(msg.Role, model)
 		// Content tokens
-		totalTokens += EstimateTokens(msg.Content)
-		// Message formatting overhead (approximately 3-4 tokens per message)
+		totalTokens += CountTokens(msg.Content, model)
+		// Tool call tokens, the called function and its arguments
+		for _, toolCall := range msg.ToolCalls {
+			totalTokens += CountTokens(toolCall.Function.Name, model) + CountTokens(toolCall.Function.Arguments, model)
+		}
+		// Message formatting overhead (<|start|>, <|message|> and <|end|>)
 		totalTokens += 3
 	}
 
-	// Model-specific base overhead
-	// OpenAI models typically add 3 tokens, Anthropic similar
+	// Every reply is primed with <|start|>assistant<|message|>
 	totalTokens += 3
 
 	return totalTokens
 }
 
 // CalculateResponseTokens calculates tokens in a response
-func CalculateResponseTokens(content string) int {
-	// Response content tokens
-	tokens := EstimateTokens(content)
-	// Response formatting overhead
-	tokens += 3
-	return tokens
+func CalculateResponseTokens(content string, model string) int {
+	return CountTokens(content, model)
+}
+
+// getTokenizer returns the cached tokenizer of the encoding, or nil if it can't be loaded
+func getTokenizer(encoding string) *tiktoken.Tiktoken {
+	value, _ := encoders.LoadOrStore(encoding, &encoder{})
+	e := value.(*encoder)
+	e.once.Do(func() {
+		tokenizer, err := tiktoken.GetEncoding(encoding)
+		if err != nil {
+			log.Printf("Failed to load %s encoding, falling back to estimation: %s", encoding, err.Error())
+			return
+		}
+		e.tiktoken = tokenizer
+	})
+	return e.tiktoken
 }
@@ -0,0 +1,203 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/routes"
+	"qualifire-home-assignment/internal/http/validators"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/services"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAdminKey = "admin-secret"
+
+// setupAdminKeys loads a copy of the keys configuration, so the tests can modify it, and enables the admin API
+func setupAdminKeys(t *testing.T) (*gin.Engine, string) {
+	gin.SetMode(gin.TestMode)
+	path := setupConfigCopy(t)
+
+	os.Setenv("ADMIN_API_KEY", testAdminKey)
+	t.Cleanup(func() { os.Unsetenv("ADMIN_API_KEY") })
+
+	return routes.HandleRequests(), path
+}
+
+// adminRequest sends an authenticated admin request
+func adminRequest(router *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testAdminKey)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestAdminKeys_Unauthorized verifies that admin requests require the admin API key
+func TestAdminKeys_Unauthorized(t *testing.T) {
+	router, _ := setupAdminKeys(t)
+
+	req := httptest.NewRequest("GET", "/admin/keys", nil)
+	req.Header.Set("Authorization", "Bearer vk_user1_openai")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	os.Unsetenv("ADMIN_API_KEY")
+	w = adminRequest(router, "GET", "/admin/keys", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+// TestAdminKeys_ListMasksApiKeys verifies that listed keys never expose the upstream api keys
+func TestAdminKeys_ListMasksApiKeys(t *testing.T) {
+	router, _ := setupAdminKeys(t)
+
+	w := adminRequest(router, "GET", "/admin/keys", "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"virtual_key":"vk_user1_openai"`)
+	assert.Contains(t, w.Body.String(), `"api_key":"******************-123"`)
+	assert.NotContains(t, w.Body.String(), "sk-real-openai-key-123")
+	assert.NotContains(t, w.Body.String(), "sk-ant-REDACTED")
+}
+
+// TestAdminKeys_Create verifies that a created key is persisted and immediately usable
+func TestAdminKeys_Create(t *testing.T) {
+	router, path := setupAdminKeys(t)
+
+	w := adminRequest(router, "POST", "/admin/keys", `{"provider":"openai","api_key":"sk-new-openai-key-000","max_requests":5}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var created models.VirtualKey
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.True(t, strings.HasPrefix(created.Key, "vk_"))
+	assert.NotContains(t, w.Body.String(), "sk-new-openai-key-000")
+
+	keyInfo, virtualKey := validators.GetKeyInfo(configs.Current(), &validators.ChatCompletion{AuthToken: "Bearer " + created.Key})
+	assert.Equal(t, created.Key, virtualKey)
+	assert.Equal(t, "sk-new-openai-key-000", keyInfo["api_key"])
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), created.Key)
+	assert.Contains(t, string(b), `"pricing"`)
+}
+
+// TestAdminKeys_CreateKeyPool verifies that a key routed to a pool of upstream keys is persisted with its pool,
+// whose api keys are masked in the responses
+func TestAdminKeys_CreateKeyPool(t *testing.T) {
+	router, _ := setupAdminKeys(t)
+
+	w := adminRequest(router, "POST", "/admin/keys", `{"provider":"openai","api_keys":[{"api_key":"sk-pool-key-0001","weight":2},{"api_key":"sk-pool-key-0002"}],"balancing":"weighted"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "sk-pool-key-0001")
+
+	var created models.VirtualKey
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, []models.UpstreamKey{{ApiKey: "************0001", Weight: 2}, {ApiKey: "************0002"}}, created.ApiKeys)
+
+	keyInfo, _ := validators.GetKeyInfo(configs.Current(), &validators.ChatCompletion{AuthToken: "Bearer " + created.Key})
+	targets := validators.GetTargets(keyInfo)
+	assert.Equal(t, []models.Target{{
+		Provider:  "openai",
+		ApiKeys:   []models.UpstreamKey{{ApiKey: "sk-pool-key-0001", Weight: 2}, {ApiKey: "sk-pool-key-0002"}},
+		Balancing: models.BALANCING_WEIGHTED,
+	}}, targets)
+
+	w = adminRequest(router, "POST", "/admin/keys", `{"provider":"openai","api_keys":[{"api_key":"sk-x"}],"balancing":"random"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestAdminKeys_CreateExisting verifies that an existing key can't be overwritten
+func TestAdminKeys_CreateExisting(t *testing.T) {
+	router, _ := setupAdminKeys(t)
+
+	w := adminRequest(router, "POST", "/admin/keys", `{"virtual_key":"vk_user1_openai","provider":"openai","api_key":"sk-x"}`)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "VIRTUAL_KEY_EXISTS")
+}
+
+// TestAdminKeys_CreateInvalid verifies that a key must be routed to a provider or to targets
+func TestAdminKeys_CreateInvalid(t *testing.T) {
+	router, _ := setupAdminKeys(t)
+
+	w := adminRequest(router, "POST", "/admin/keys", `{"provider":"openai"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = adminRequest(router, "POST", "/admin/keys", `{"provider":"openai","api_key":"sk-x","window":"daily"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestAdminKeys_Update verifies that only the given fields are updated
+func TestAdminKeys_Update(t *testing.T) {
+	router, _ := setupAdminKeys(t)
+
+	w := adminRequest(router, "PATCH", "/admin/keys/vk_user1_openai", `{"max_requests":7,"window":"30m"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	keyInfo, _ := validators.GetKeyInfo(configs.Current(), &validators.ChatCompletion{AuthToken: "Bearer vk_user1_openai"})
+	assert.EqualValues(t, 7, keyInfo["max_requests"])
+	assert.Equal(t, "30m", keyInfo["window"])
+	assert.Equal(t, "sk-real-openai-key-123", keyInfo["api_key"])
+
+	w = adminRequest(router, "PATCH", "/admin/keys/vk_missing", `{"max_requests":7}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestAdminKeys_Rotate verifies that a rotated key is replaced by a new key of the same configuration,
+// which keeps the quota usage and spend of the old key
+func TestAdminKeys_Rotate(t *testing.T) {
+	router, _ := setupAdminKeys(t)
+	quota := services.GetQuotaService()
+	quota.Reset()
+	quota.IncrementUsage(configs.Current(), "vk_user2_anthropic", 250, 1.5)
+
+	w := adminRequest(router, "POST", "/admin/keys/vk_user2_anthropic/rotate", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var rotated models.VirtualKey
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rotated))
+	assert.NotEqual(t, "vk_user2_anthropic", rotated.Key)
+	assert.Equal(t, "anthropic", rotated.Provider)
+
+	w = adminRequest(router, "GET", "/admin/keys/vk_user2_anthropic", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = adminRequest(router, "GET", "/admin/keys/"+rotated.Key, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	requests, tokens := quota.GetUsage(rotated.Key)
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, 250, tokens)
+	assert.Equal(t, 1.5, quota.GetSpend(rotated.Key))
+	assert.Equal(t, []string{rotated.Key}, quota.GetVirtualKeys())
+}
+
+// TestAdminKeys_Revoke verifies that a revoked key is rejected immediately
+func TestAdminKeys_Revoke(t *testing.T) {
+	router, _ := setupAdminKeys(t)
+
+	w := adminRequest(router, "DELETE", "/admin/keys/vk_user1_openai", "")
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	assert.Panics(t, func() {
+		validators.GetKeyInfo(configs.Current(), &validators.ChatCompletion{AuthToken: "Bearer vk_user1_openai"})
+	})
+	w = adminRequest(router, "DELETE", "/admin/keys/vk_user1_openai", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestMaskSecret verifies that only the last 4 characters of a secret are kept
+func TestMaskSecret(t *testing.T) {
+	assert.Equal(t, "", models.MaskSecret(""))
+	assert.Equal(t, "*****", models.MaskSecret("sk-ab"))
+	assert.Equal(t, "********-456", models.MaskSecret("sk-ant-x-456"))
+}
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/routes"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/services"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sendAnthropicMessage sends a messages request authenticated the way the Anthropic clients do
+func sendAnthropicMessage(virtualKey string, body string) *httptest.ResponseRecorder {
+	os.Setenv("IS_TEST", "1")
+	gin.SetMode(gin.TestMode)
+	configs.LoadConfig()
+	services.GetMetricsService().Reset()
+	services.GetQuotaService().Reset()
+
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", virtualKey)
+	req.Header.Set("anthropic-version", "2023-06-01")
+	w := httptest.NewRecorder()
+	routes.HandleRequests().ServeHTTP(w, req)
+	return w
+}
+
+// TestAnthropicMessages_OpenAITarget verifies that a messages request is translated to OpenAI
+// and the response is returned in the Anthropic schema
+func TestAnthropicMessages_OpenAITarget(t *testing.T) {
+	server := newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"id": "chatcmpl-1",
+		"model": "gpt-4o",
+		"choices": [{"index": 0, "finish_reason": "length", "message": {"role": "assistant", "content": "Hello!"}}],
+		"usage": {"prompt_tokens": 20, "completion_tokens": 10, "total_tokens": 30, "prompt_tokens_details": {"cached_tokens": 5}}
+	}`)
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+
+	w := sendAnthropicMessage("vk_user1_openai", `{
+		"model": "gpt-4o",
+		"max_tokens": 64,
+		"system": [{"type": "text", "text": "Be brief"}],
+		"stop_sequences": ["END"],
+		"messages": [{"role": "user", "content": [{"type": "text", "text": "Hello"}, {"type": "text", "text": "there"}]}]
+	}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var message models.AnthropicMessage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &message))
+	assert.Equal(t, "message", message.Type)
+	assert.Equal(t, "assistant", message.Role)
+	assert.Equal(t, []models.AnthropicContentBlock{{Type: "text", Text: "Hello!"}}, message.Content)
+	assert.Equal(t, "max_tokens", *message.StopReason)
+	assert.Equal(t, models.AnthropicUsage{InputTokens: 15, OutputTokens: 10, CacheReadInputTokens: 5}, message.Usage)
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"role": "system", "content": "Be brief"},
+		map[string]interface{}{"role": "user", "content": "Hello\nthere"},
+	}, server.Last().Body["messages"])
+	assert.Equal(t, float64(64), server.Last().Body["max_tokens"])
+	assert.Equal(t, []interface{}{"END"}, server.Last().Body["stop"])
+
+	requests, tokens := services.GetQuotaService().GetUsage("vk_user1_openai")
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, 30, tokens)
+}
+
+// TestAnthropicMessages_AnthropicTarget verifies that the system prompt reaches Anthropic as the top-level system
+func TestAnthropicMessages_AnthropicTarget(t *testing.T) {
+	server := newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"id": "msg_1",
+		"role": "assistant",
+		"content": "Hello!",
+		"stop_reason": "end_turn",
+		"usage": {"input_tokens": 12, "output_tokens": 5}
+	}`)
+	os.Setenv("ANTHROPIC_ENDPOINT", server.URL)
+	defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+
+	w := sendAnthropicMessage("vk_user2_anthropic", `{
+		"model": "claude-3-5-sonnet-20240620",
+		"max_tokens": 64,
+		"system": "Be brief",
+		"top_k": 5,
+		"messages": [{"role": "user", "content": "Hello"}]
+	}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var message models.AnthropicMessage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &message))
+	assert.Equal(t, "msg_1", message.ID)
+	assert.Equal(t, "end_turn", *message.StopReason)
+
+	assert.Equal(t, "Be brief", server.Last().Body["system"])
+	assert.Equal(t, float64(5), server.Last().Body["top_k"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"role": "user", "content": "Hello"}}, server.Last().Body["messages"])
+}
+
+// TestAnthropicMessages_Stream verifies that a stream served by OpenAI is relayed as Anthropic message events
+func TestAnthropicMessages_Stream(t *testing.T) {
+	server := newOpenAIStreamServer("Hello", ", world")
+	defer server.Close()
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+
+	w := sendAnthropicMessage("vk_user1_openai", `{
+		"model": "gpt-4o",
+		"max_tokens": 64,
+		"stream": true,
+		"messages": [{"role": "user", "content": "Hello"}]
+	}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var events []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if event, ok := strings.CutPrefix(line, "event:"); ok {
+			events = append(events, event)
+		}
+	}
+	assert.Equal(t, []string{
+		"message_start", "content_block_start", "content_block_delta", "content_block_delta",
+		"content_block_stop", "message_delta", "message_stop",
+	}, events)
+	assert.Contains(t, w.Body.String(), `"delta":{"text":", world","type":"text_delta"}`)
+	assert.Contains(t, w.Body.String(), `"stop_reason":"end_turn"`)
+	assert.Contains(t, w.Body.String(), `"usage":{"input_tokens":12,"output_tokens":4,"cache_read_input_tokens":0}`)
+}
+
+// TestAnthropicMessages_ValidationError verifies that errors are returned in the Anthropic error format
+func TestAnthropicMessages_ValidationError(t *testing.T) {
+	w := sendAnthropicMessage("vk_user2_anthropic", `{
+		"model": "claude-3-5-sonnet-20240620",
+		"messages": [{"role": "user", "content": "Hello"}]
+	}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "error", body["type"])
+	assert.Equal(t, "invalid_request_error", body["error"].(map[string]interface{})["type"])
+}
+
+// TestAnthropicMessages_UnsupportedContentBlock verifies that non-text content blocks are rejected
+func TestAnthropicMessages_UnsupportedContentBlock(t *testing.T) {
+	w := sendAnthropicMessage("vk_user2_anthropic", `{
+		"model": "claude-3-5-sonnet-20240620",
+		"max_tokens": 64,
+		"messages": [{"role": "user", "content": [{"type": "search_result", "title": "Results"}]}]
+	}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "unsupported content block type: search_result")
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/providers"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sendAnthropicRequest sends the messages to Anthropic through the gateway provider
+func sendAnthropicRequest(messages []models.Message, options models.ChatOptions) *providers.Response {
+	return providers.Factory(models.ProxyRequest{
+		Provider: "anthropic",
+		ApiKey:   "sk-ant-test",
+		Messages: messages,
+		Model:    "claude-3-5-sonnet-20240620",
+		Options:  options,
+	}).SendRequest(context.Background())
+}
+
+const anthropicHello = `{
+	"id": "msg_1",
+	"type": "message",
+	"role": "assistant",
+	"content": [{"type": "text", "text": "Bonjour"}],
+	"stop_reason": "end_turn",
+	"usage": {"input_tokens": 20, "output_tokens": 3}
+}`
+
+// TestAnthropic_Request verifies that the system and developer messages become the top-level system prompt,
+// that the consecutive turns of a role are merged, and that max_tokens and the API version are sent
+func TestAnthropic_Request(t *testing.T) {
+	upstream := newFakeUpstream(t, http.StatusOK, "application/json", anthropicHello)
+	upstream.SetEndpoint(t, "ANTHROPIC_ENDPOINT")
+
+	sendAnthropicRequest([]models.Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Hi"},
+		{Role: "developer", Content: "Answer in French."},
+		{Role: "user", Content: "How are you?"},
+		{Role: "assistant", Content: "Bien."},
+		{Role: "assistant", Content: "Et toi ?"},
+		{Role: "user", Parts: []models.ContentPart{{Type: models.CONTENT_PART_TEXT, Text: "Describe it"}, {Type: models.CONTENT_PART_IMAGE, ImageURL: &models.ImageURL{URL: "https://example.com/cat.png"}}}},
+		{Role: "user", Content: "briefly"},
+	}, models.ChatOptions{})
+
+	assert.Equal(t, "2023-06-01", upstream.Last().Header.Get("anthropic-version"))
+	assert.Equal(t, "sk-ant-test", upstream.Last().Header.Get("x-api-key"))
+	assert.Equal(t, "Be brief.\n\nAnswer in French.", upstream.Last().Body["system"])
+	assert.Equal(t, float64(4096), upstream.Last().Body["max_tokens"])
+	assert.NotContains(t, upstream.Last().Body, "temperature")
+	assert.NotContains(t, upstream.Last().Body, "stream")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"role": "user", "content": "Hi\n\nHow are you?"},
+		map[string]interface{}{"role": "assistant", "content": "Bien.\n\nEt toi ?"},
+		map[string]interface{}{"role": "user", "content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "Describe it"},
+			map[string]interface{}{"type": "image", "source": map[string]interface{}{"type": "url", "url": "https://example.com/cat.png"}},
+			map[string]interface{}{"type": "text", "text": "briefly"},
+		}},
+	}, upstream.Last().Body["messages"])
+}
+
+// TestAnthropic_RequestConfiguration verifies that the API version and the default max_tokens are configurable,
+// and that the max_tokens of the request take precedence
+func TestAnthropic_RequestConfiguration(t *testing.T) {
+	upstream := newFakeUpstream(t, http.StatusOK, "application/json", anthropicHello)
+	upstream.SetEndpoint(t, "ANTHROPIC_ENDPOINT")
+	os.Setenv("ANTHROPIC_VERSION", "2024-01-01")
+	os.Setenv("ANTHROPIC_MAX_TOKENS", "1024")
+	defer os.Unsetenv("ANTHROPIC_VERSION")
+	defer os.Unsetenv("ANTHROPIC_MAX_TOKENS")
+
+	sendAnthropicRequest([]models.Message{{Role: "user", Content: "Hi"}}, models.ChatOptions{})
+	assert.Equal(t, "2024-01-01", upstream.Last().Header.Get("anthropic-version"))
+	assert.Equal(t, float64(1024), upstream.Last().Body["max_tokens"])
+
+	maxTokens := int64(16)
+	sendAnthropicRequest([]models.Message{{Role: "user", Content: "Hi"}}, models.ChatOptions{MaxTokens: &maxTokens})
+	assert.Equal(t, float64(16), upstream.Last().Body["max_tokens"])
+}
+
+// TestAnthropic_Response verifies that the text and tool_use content blocks and the stop reason of the response are parsed
+func TestAnthropic_Response(t *testing.T) {
+	newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"id": "msg_2",
+		"type": "message",
+		"role": "assistant",
+		"content": [
+			{"type": "text", "text": "Let me check."},
+			{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+		],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 30, "output_tokens": 12}
+	}`).SetEndpoint(t, "ANTHROPIC_ENDPOINT")
+
+	result := sendAnthropicRequest([]models.Message{{Role: "user", Content: "Weather in Paris?"}}, models.ChatOptions{})
+
+	require.Len(t, result.Choices, 1)
+	assert.Equal(t, "msg_2", result.ID)
+	assert.Equal(t, "Let me check.", result.Choices[0].Content)
+	assert.Equal(t, "tool_calls", result.Choices[0].FinishReason)
+	require.Len(t, result.Choices[0].ToolCalls, 1)
+	assert.Equal(t, "get_weather", result.Choices[0].ToolCalls[0].Function.Name)
+	assert.JSONEq(t, `{"city": "Paris"}`, result.Choices[0].ToolCalls[0].Function.Arguments)
+	assert.Equal(t, 42, result.TokensUsed)
+}
+
+// TestAnthropic_Errors verifies that the Anthropic errors are propagated with their message and status,
+// and that a request without user or assistant messages is rejected before calling Anthropic
+func TestAnthropic_Errors(t *testing.T) {
+	newFakeUpstream(t, http.StatusBadRequest, "application/json", `{"type": "error", "error": {"type": "invalid_request_error", "message": "max_tokens: 100000 > 8192"}}`).SetEndpoint(t, "ANTHROPIC_ENDPOINT")
+	err := recoverProviderError(func() { sendAnthropicRequest([]models.Message{{Role: "user", Content: "Hi"}}, models.ChatOptions{}) })
+	assert.Equal(t, http.StatusBadRequest, err.StatusCode)
+	assert.Equal(t, "max_tokens: 100000 > 8192", err.Message)
+
+	newFakeUpstream(t, http.StatusBadGateway, "application/json", `<html>Bad Gateway</html>`).SetEndpoint(t, "ANTHROPIC_ENDPOINT")
+	err = recoverProviderError(func() { sendAnthropicRequest([]models.Message{{Role: "user", Content: "Hi"}}, models.ChatOptions{}) })
+	assert.Equal(t, http.StatusBadGateway, err.StatusCode)
+	assert.Equal(t, "502 Bad Gateway", err.Message)
+
+	upstream := newFakeUpstream(t, http.StatusOK, "application/json", anthropicHello)
+	upstream.SetEndpoint(t, "ANTHROPIC_ENDPOINT")
+	err = recoverProviderError(func() {
+		sendAnthropicRequest([]models.Message{{Role: "system", Content: "Be brief."}}, models.ChatOptions{})
+	})
+	assert.Equal(t, http.StatusBadRequest, err.StatusCode)
+	assert.Empty(t, upstream.Requests)
+}
+
+// recoverProviderError runs the call and returns the provider error it panicked with
+func recoverProviderError(call func()) (err errors.ApiProvider) {
+	defer func() {
+		err = recover().(errors.ApiProvider)
+	}()
+	call()
+	return
+}
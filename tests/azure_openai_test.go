@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAzureOpenAI_Deployments verifies that the requests are sent to the deployment of their model with the api-version
+// of the key and the api-key header, and that a model without a deployment is sent to the deployment of its name
+func TestAzureOpenAI_Deployments(t *testing.T) {
+	client, requests := setupUpstreamKey(t, "vk_azure", "Hello from Azure!", func(endpoint string) string {
+		return `{
+			"provider": "azure_openai",
+			"api_key": "azure-resource-key",
+			"base_url": "` + endpoint + `",
+			"deployments": {"gpt-4o": "prod-gpt4o"},
+			"api_version": "2024-06-01"
+		}`
+	})
+
+	completion, err := complete(client, "gpt-4o")
+	require.NoError(t, err)
+	assert.Equal(t, "Hello from Azure!", completion.Choices[0].Message.Content)
+	_, err = complete(client, "gpt-4o-mini")
+	require.NoError(t, err)
+
+	require.Len(t, *requests, 2)
+	assert.Equal(t, "/openai/deployments/prod-gpt4o/chat/completions", (*requests)[0].Path)
+	assert.Equal(t, "/openai/deployments/gpt-4o-mini/chat/completions", (*requests)[1].Path)
+	for _, request := range *requests {
+		assert.Equal(t, "2024-06-01", request.ApiVersion)
+		assert.Equal(t, "azure-resource-key", request.Header.Get("api-key"))
+		assert.Empty(t, request.Header.Get("Authorization"))
+	}
+}
+
+// TestAzureOpenAI_DefaultApiVersion verifies that a key without an api_version uses AZURE_OPENAI_API_VERSION
+func TestAzureOpenAI_DefaultApiVersion(t *testing.T) {
+	client, requests := setupUpstreamKey(t, "vk_azure", "Hello from Azure!", func(endpoint string) string {
+		return `{"provider": "azure_openai", "api_key": "azure-resource-key", "base_url": "` + endpoint + `"}`
+	})
+
+	_, err := complete(client, "gpt-4o")
+	require.NoError(t, err)
+	os.Setenv("AZURE_OPENAI_API_VERSION", "2025-01-01-preview")
+	defer os.Unsetenv("AZURE_OPENAI_API_VERSION")
+	_, err = complete(client, "gpt-4o")
+	require.NoError(t, err)
+
+	require.Len(t, *requests, 2)
+	assert.Equal(t, "2024-10-21", (*requests)[0].ApiVersion)
+	assert.Equal(t, "2025-01-01-preview", (*requests)[1].ApiVersion)
+}
+
+// TestAzureOpenAI_Validation verifies that the azure_openai provider requires the endpoint of its resource and an api key,
+// and that the deployments are only accepted by the azure_openai provider
+func TestAzureOpenAI_Validation(t *testing.T) {
+	router, _ := setupAdminKeys(t)
+
+	testCases := map[string]string{
+		"missing base_url":       `{"provider":"azure_openai","api_key":"azure-key"}`,
+		"missing api_key":        `{"provider":"azure_openai","base_url":"https://my-resource.openai.azure.com"}`,
+		"deployments of openai":  `{"provider":"openai","api_key":"sk-x","deployments":{"gpt-4o":"prod-gpt4o"}}`,
+		"empty deployment":       `{"provider":"azure_openai","api_key":"azure-key","base_url":"https://my-resource.openai.azure.com","deployments":{"gpt-4o":""}}`,
+		"target without api_key": `{"targets":[{"provider":"azure_openai","base_url":"https://my-resource.openai.azure.com"}]}`,
+	}
+	for name, body := range testCases {
+		w := adminRequest(router, "POST", "/admin/keys", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+	}
+
+	w := adminRequest(router, "POST", "/admin/keys", `{"provider":"azure_openai","api_key":"azure-resource-key","base_url":"https://my-resource.openai.azure.com","deployments":{"gpt-4o":"prod-gpt4o"},"api_version":"2024-06-01"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "azure-resource-key")
+}
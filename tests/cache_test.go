@@ -0,0 +1,224 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/services"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cachedCompletion is the answer of the fake provider in the cache tests
+const cachedCompletion = `{
+	"id": "chatcmpl-1",
+	"model": "gpt-4o",
+	"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "Paris."}}],
+	"usage": {"prompt_tokens": 20, "completion_tokens": 2, "total_tokens": 22}
+}`
+
+// newCachedGatewayClient returns a gateway client whose provider is a fake OpenAI server, with an empty response cache
+func newCachedGatewayClient(t *testing.T, virtualKey string) (openai.Client, *[]upstreamRequest) {
+	server := newFakeUpstream(t, http.StatusOK, "application/json", cachedCompletion)
+	server.SetEndpoint(t, "OPENAI_BASE_URL")
+	services.GetCacheService().Reset()
+	return newGatewayClient(t, virtualKey), &server.Requests
+}
+
+// askCapital sends the cache tests question with the temperature and returns the response headers
+func askCapital(t *testing.T, client openai.Client, temperature float64, opts ...option.RequestOption) http.Header {
+	return askCapitalWith(t, client, openai.ChatCompletionNewParams{Temperature: openai.Float(temperature)}, opts...)
+}
+
+// askCapitalWith sends the cache tests question with the generation parameters and returns the response headers
+func askCapitalWith(t *testing.T, client openai.Client, params openai.ChatCompletionNewParams, opts ...option.RequestOption) http.Header {
+	params.Model = "gpt-4o"
+	params.Messages = []openai.ChatCompletionMessageParamUnion{openai.UserMessage("What is the capital of France?")}
+	var response *http.Response
+	completion, err := client.Chat.Completions.New(context.Background(), params, append(opts, option.WithResponseInto(&response))...)
+	require.NoError(t, err)
+	assert.Equal(t, "Paris.", completion.Choices[0].Message.Content)
+	return response.Header
+}
+
+// TestCache_HeaderOptIn verifies that a request opts in to the cache with max-age, that an identical request is
+// served from the cache without calling the provider or using up the token quota, and that hits are counted in metrics
+func TestCache_HeaderOptIn(t *testing.T) {
+	client, captured := newCachedGatewayClient(t, "vk_user1_openai")
+	cacheControl := option.WithHeader("Cache-Control", "max-age=60")
+
+	assert.Equal(t, models.CACHE_MISS, askCapital(t, client, 0, cacheControl).Get("X-Cache"))
+	headers := askCapital(t, client, 0, cacheControl)
+	assert.Equal(t, models.CACHE_HIT, headers.Get("X-Cache"))
+	assert.Equal(t, "0", headers.Get("Age"))
+	assert.Len(t, *captured, 1)
+
+	_, tokens := services.GetQuotaService().GetUsage("vk_user1_openai")
+	assert.Equal(t, 22, tokens)
+	stats := services.GetMetricsService().GetStats()
+	assert.Equal(t, 1, stats["cache_hits"])
+	assert.Equal(t, 1, stats["cache_misses"])
+	var buf bytes.Buffer
+	services.GetMetricsService().WritePrometheus(&buf)
+	assert.Contains(t, buf.String(), `llm_gateway_cache_requests_total{virtual_key_fingerprint="`+models.Fingerprint("vk_user1_openai")+`",result="hit"} 1`)
+
+	// A request which doesn't opt in neither uses the cache nor reports it
+	assert.Empty(t, askCapital(t, client, 0).Get("X-Cache"))
+	assert.Len(t, *captured, 2)
+}
+
+// TestCache_KeyedOnParameters verifies that requests which differ in their generation parameters don't share a response
+func TestCache_KeyedOnParameters(t *testing.T) {
+	client, captured := newCachedGatewayClient(t, "vk_user1_openai")
+	cacheControl := option.WithHeader("Cache-Control", "max-age=60")
+
+	sampled := option.WithHeader("Cache-Control", "max-age=60, allow-sampled")
+
+	askCapital(t, client, 0, cacheControl)
+	assert.Equal(t, models.CACHE_MISS, askCapital(t, client, 0.5, sampled).Get("X-Cache"))
+	assert.Equal(t, models.CACHE_HIT, askCapital(t, client, 0.5, sampled).Get("X-Cache"))
+	assert.Len(t, *captured, 2)
+}
+
+// TestCache_SampledRequests verifies that a request with a non-zero temperature bypasses the cache unless it opts in
+func TestCache_SampledRequests(t *testing.T) {
+	client, captured := newCachedGatewayClient(t, "vk_user1_openai")
+	cacheControl := option.WithHeader("Cache-Control", "max-age=60")
+
+	assert.Empty(t, askCapital(t, client, 0.7, cacheControl).Get("X-Cache"))
+	assert.Empty(t, askCapital(t, client, 0.7, cacheControl).Get("X-Cache"))
+	assert.Len(t, *captured, 2)
+}
+
+// TestCache_TemperatureOmitted verifies that a request without a temperature bypasses the cache, as the providers
+// sample it with their default temperature
+func TestCache_TemperatureOmitted(t *testing.T) {
+	client, captured := newCachedGatewayClient(t, "vk_user1_openai")
+	cacheControl := option.WithHeader("Cache-Control", "max-age=60")
+
+	assert.Empty(t, askCapitalWith(t, client, openai.ChatCompletionNewParams{}, cacheControl).Get("X-Cache"))
+	assert.Empty(t, askCapitalWith(t, client, openai.ChatCompletionNewParams{}, cacheControl).Get("X-Cache"))
+	assert.Len(t, *captured, 2)
+}
+
+// TestCache_ZeroTemperatureWithTopP verifies that a request with a zero temperature is cached whatever its top_p
+func TestCache_ZeroTemperatureWithTopP(t *testing.T) {
+	client, captured := newCachedGatewayClient(t, "vk_user1_openai")
+	cacheControl := option.WithHeader("Cache-Control", "max-age=60")
+	params := openai.ChatCompletionNewParams{Temperature: openai.Float(0), TopP: openai.Float(1)}
+
+	assert.Equal(t, models.CACHE_MISS, askCapitalWith(t, client, params, cacheControl).Get("X-Cache"))
+	assert.Equal(t, models.CACHE_HIT, askCapitalWith(t, client, params, cacheControl).Get("X-Cache"))
+	assert.Len(t, *captured, 1)
+}
+
+// TestCache_KeyedOnVirtualKey verifies that the virtual keys don't share their cached responses
+func TestCache_KeyedOnVirtualKey(t *testing.T) {
+	client, captured := newCachedGatewayClient(t, "vk_user1_openai")
+	cacheControl := option.WithHeader("Cache-Control", "max-age=60")
+	askCapital(t, client, 0, cacheControl)
+
+	other := newGatewayClient(t, "vk_admin_openai")
+	assert.Equal(t, models.CACHE_MISS, askCapital(t, other, 0, cacheControl).Get("X-Cache"))
+	assert.Equal(t, models.CACHE_HIT, askCapital(t, other, 0, cacheControl).Get("X-Cache"))
+	assert.Len(t, *captured, 2)
+}
+
+// TestCache_KeyOptIn verifies that a key opts in to the cache in the keys configuration,
+// and that its requests can skip the lookup with no-cache or bypass the cache with no-store
+func TestCache_KeyOptIn(t *testing.T) {
+	client, captured := newCachedGatewayClient(t, "vk_cached")
+	path := setupConfigCopy(t)
+	require.NoError(t, os.WriteFile(path, []byte(`{"virtual_keys": {"vk_cached": {"provider": "openai", "api_key": "sk-x", "cache": true}}}`), 0600))
+	require.NoError(t, configs.ReloadConfig())
+
+	assert.Equal(t, models.CACHE_MISS, askCapital(t, client, 0).Get("X-Cache"))
+	assert.Equal(t, models.CACHE_HIT, askCapital(t, client, 0).Get("X-Cache"))
+	assert.Equal(t, models.CACHE_MISS, askCapital(t, client, 0, option.WithHeader("Cache-Control", "no-cache")).Get("X-Cache"))
+	assert.Empty(t, askCapital(t, client, 0, option.WithHeader("Cache-Control", "no-store")).Get("X-Cache"))
+	assert.Len(t, *captured, 3)
+}
+
+// TestCache_QuotaPolicyCharge verifies that the cache hits are charged the tokens of the cached response
+// under the charge policy
+func TestCache_QuotaPolicyCharge(t *testing.T) {
+	os.Setenv("CACHE_QUOTA_POLICY", services.CACHE_QUOTA_CHARGE)
+	defer os.Unsetenv("CACHE_QUOTA_POLICY")
+	client, _ := newCachedGatewayClient(t, "vk_user1_openai")
+	cacheControl := option.WithHeader("Cache-Control", "max-age=60")
+
+	askCapital(t, client, 0, cacheControl)
+	assert.Equal(t, models.CACHE_HIT, askCapital(t, client, 0, cacheControl).Get("X-Cache"))
+
+	requests, tokens := services.GetQuotaService().GetUsage("vk_user1_openai")
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 44, tokens)
+	// Only the request which reached the provider costs anything
+	assert.Equal(t, services.CalculateCost(configs.Current(), "gpt-4o", models.Usage{PromptTokens: 20, CompletionTokens: 2}), services.GetMetricsService().GetStats()["total_spend_usd"])
+}
+
+// TestCache_Stream verifies that a streamed response is cached once complete and replayed as a stream
+func TestCache_Stream(t *testing.T) {
+	server := newOpenAIStreamServer("Par", "is.")
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+	services.GetCacheService().Reset()
+	client := newGatewayClient(t, "vk_user1_openai")
+
+	stream := func() string {
+		s := client.Chat.Completions.NewStreaming(context.Background(), openai.ChatCompletionNewParams{
+			Model:       "gpt-4o",
+			Messages:    []openai.ChatCompletionMessageParamUnion{openai.UserMessage("What is the capital of France?")},
+			Temperature: openai.Float(0),
+		}, option.WithHeader("Cache-Control", "max-age=60"))
+		var accumulator openai.ChatCompletionAccumulator
+		for s.Next() {
+			accumulator.AddChunk(s.Current())
+		}
+		require.NoError(t, s.Err())
+		return accumulator.Choices[0].Message.Content
+	}
+
+	assert.Equal(t, "Paris.", stream())
+	server.Close()
+	assert.Equal(t, "Paris.", stream())
+	assert.Equal(t, 1, services.GetMetricsService().GetStats()["cache_hits"])
+}
+
+// TestCacheService_Eviction verifies that the least recently used entries are evicted beyond the maximum number
+// of entries, and that the entries expire after the TTL
+func TestCacheService_Eviction(t *testing.T) {
+	cache := services.GetCacheService()
+	cache.Reset()
+	cache.SetLimits(2, time.Hour)
+	defer cache.SetLimits(1000, time.Hour)
+
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	_, _, ok := cache.Get("a", 0)
+	require.True(t, ok)
+	cache.Set("c", 3)
+
+	_, _, ok = cache.Get("b", 0)
+	assert.False(t, ok)
+	value, _, ok := cache.Get("a", 0)
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	assert.Equal(t, 2, cache.Len())
+
+	time.Sleep(20 * time.Millisecond)
+	_, _, ok = cache.Get("a", 10*time.Millisecond)
+	assert.False(t, ok, "entries older than the max age are ignored")
+	cache.SetLimits(2, 10*time.Millisecond)
+	_, _, ok = cache.Get("a", 0)
+	assert.False(t, ok, "entries older than the TTL expire")
+	assert.Equal(t, 1, cache.Len())
+}
@@ -200,7 +200,7 @@ func TestGetKeyInfo_Success(t *testing.T) {
 		AuthToken: "Bearer vk_user1_openai",
 	}
 
-	keyInfo, virtualKey := validators.GetKeyInfo(cc)
+	keyInfo, virtualKey := validators.GetKeyInfo(configs.Current(), cc)
 
 	assert.NotNil(t, keyInfo)
 	assert.Equal(t, "vk_user1_openai", virtualKey)
@@ -222,7 +222,7 @@ func TestGetKeyInfo_WrongFormat(t *testing.T) {
 		}
 	}()
 
-	validators.GetKeyInfo(cc)
+	validators.GetKeyInfo(configs.Current(), cc)
 }
 
 // TestGetProxyRequest_Success tests successful creation of proxy request from chat completion parameters
@@ -237,7 +237,7 @@ func TestGetProxyRequest_Success(t *testing.T) {
 		AuthToken: "Bearer vk_user1_openai",
 	}
 
-	result := validators.GetProxyRequest(cc)
+	result := validators.GetProxyRequest(configs.Current(), cc)
 
 	assert.NotNil(t, result)
 }
@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"qualifire-home-assignment/internal/http/routes"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/services"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setCircuitBreakerConfig configures the circuit breakers for the test
+func setCircuitBreakerConfig(t *testing.T, config services.CircuitBreakerConfig) *services.CircuitBreakerService {
+	breakers := services.GetCircuitBreakerService()
+	previous := breakers.GetConfig()
+	breakers.SetConfig(config)
+	breakers.Reset()
+	t.Cleanup(func() {
+		breakers.SetConfig(previous)
+		breakers.Reset()
+	})
+	return breakers
+}
+
+// TestCircuitBreaker_OpensAndCloses verifies that a circuit opens at the error rate, fails fast while open,
+// lets a single probe through once half-open and closes when the probe succeeds
+func TestCircuitBreaker_OpensAndCloses(t *testing.T) {
+	breakers := setCircuitBreakerConfig(t, services.CircuitBreakerConfig{
+		ErrorRate: 0.5, MinRequests: 4, Window: time.Minute, OpenDuration: 50 * time.Millisecond, HalfOpenProbes: 1,
+	})
+	key := services.NewBreakerKey("openai", "https://api.openai.com", "sk-real-openai-key-123")
+
+	for _, failed := range []bool{false, true, false} {
+		require.NoError(t, breakers.Allow(key))
+		breakers.Record(key, failed, false)
+	}
+	require.NoError(t, breakers.Allow(key), "below the minimum number of requests")
+	breakers.Record(key, true, false)
+
+	var circuitErr *services.CircuitOpenError
+	require.ErrorAs(t, breakers.Allow(key), &circuitErr)
+	assert.Contains(t, circuitErr.Error(), "openai is unavailable at https://api.openai.com")
+	states := breakers.GetStates()
+	require.Len(t, states, 1)
+	assert.Equal(t, services.CIRCUIT_OPEN, states[0].State)
+	assert.Equal(t, "******************-123", states[0].ApiKey)
+
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, breakers.Allow(key), "the probe")
+	assert.Error(t, breakers.Allow(key), "a single probe at a time")
+	breakers.Record(key, false, false)
+
+	require.NoError(t, breakers.Allow(key))
+	assert.Equal(t, services.CIRCUIT_CLOSED, breakers.GetStates()[0].State)
+}
+
+// TestCircuitBreaker_FailedProbeReopens verifies that a failed probe opens the circuit again,
+// and that a canceled probe frees its slot
+func TestCircuitBreaker_FailedProbeReopens(t *testing.T) {
+	breakers := setCircuitBreakerConfig(t, services.CircuitBreakerConfig{
+		ErrorRate: 0.5, MinRequests: 1, Window: time.Minute, OpenDuration: 20 * time.Millisecond, HalfOpenProbes: 1,
+	})
+	key := services.NewBreakerKey("anthropic", "https://api.anthropic.com", "sk-ant")
+
+	require.NoError(t, breakers.Allow(key))
+	breakers.Record(key, true, false)
+	time.Sleep(30 * time.Millisecond)
+
+	require.NoError(t, breakers.Allow(key))
+	breakers.Record(key, false, true)
+	require.NoError(t, breakers.Allow(key), "the canceled probe freed its slot")
+	breakers.Record(key, true, false)
+
+	assert.Error(t, breakers.Allow(key))
+	assert.Equal(t, 2, breakers.GetStates()[0].Opened)
+}
+
+// TestCircuitBreaker_FailsFast verifies that the gateway stops calling a failing provider once its circuit opens,
+// with a LLM_PROVIDER_UNAVAILABLE error, and reports the open circuit in /health and /metrics
+func TestCircuitBreaker_FailsFast(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"type": "error", "error": {"type": "api_error", "message": "Internal server error"}}`))
+	}))
+	defer server.Close()
+	os.Setenv("ANTHROPIC_ENDPOINT", server.URL)
+	defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+	client := newGatewayClient(t, "vk_user2_anthropic")
+	setCircuitBreakerConfig(t, services.CircuitBreakerConfig{
+		ErrorRate: 0.5, MinRequests: 2, Window: time.Minute, OpenDuration: time.Minute, HalfOpenProbes: 1,
+	})
+
+	send := func() *openai.Error {
+		_, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+			Model:    "claude-3-5-sonnet-20240620",
+			Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hello")},
+		})
+		var apiErr *openai.Error
+		require.ErrorAs(t, err, &apiErr)
+		return apiErr
+	}
+	assert.Equal(t, http.StatusInternalServerError, send().StatusCode)
+	assert.Equal(t, http.StatusInternalServerError, send().StatusCode)
+
+	apiErr := send()
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+	assert.Equal(t, "llm_provider_unavailable", apiErr.Code)
+	assert.Equal(t, 2, calls)
+
+	w := httptest.NewRecorder()
+	routes.HandleRequests().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	var health struct {
+		Status          string                  `json:"status"`
+		CircuitBreakers []services.BreakerState `json:"circuit_breakers"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &health))
+	assert.Equal(t, "degraded", health.Status)
+	require.Len(t, health.CircuitBreakers, 1)
+	assert.Equal(t, "anthropic", health.CircuitBreakers[0].Provider)
+	assert.Equal(t, server.URL, health.CircuitBreakers[0].Endpoint)
+	assert.Equal(t, services.CIRCUIT_OPEN, health.CircuitBreakers[0].State)
+	fingerprint := health.CircuitBreakers[0].Fingerprint
+	assert.Equal(t, models.Fingerprint("sk-ant-REDACTED"), fingerprint)
+
+	w = httptest.NewRecorder()
+	routes.HandleRequests().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics?format=prometheus", nil))
+	assert.Contains(t, w.Body.String(), `llm_gateway_circuit_breaker_state{provider="anthropic",endpoint="`+server.URL+`",api_key_fingerprint="`+fingerprint+`"} 2`)
+}
+
+// TestCircuitBreaker_FailsOver verifies that a fallback key fails over immediately from a target whose circuit is open
+func TestCircuitBreaker_FailsOver(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the target whose circuit is open was called")
+	}))
+	defer failing.Close()
+	working := newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"id": "msg_1",
+		"role": "assistant",
+		"content": [{"type": "text", "text": "Hello!"}],
+		"stop_reason": "end_turn",
+		"usage": {"input_tokens": 10, "output_tokens": 2}
+	}`)
+	os.Setenv("OPENAI_BASE_URL", failing.URL)
+	os.Setenv("ANTHROPIC_ENDPOINT", working.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+	defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+	client := newGatewayClient(t, "vk_user3_fallback")
+	breakers := setCircuitBreakerConfig(t, services.CircuitBreakerConfig{
+		ErrorRate: 0.5, MinRequests: 1, Window: time.Minute, OpenDuration: time.Minute, HalfOpenProbes: 1,
+	})
+	key := services.NewBreakerKey("openai", failing.URL, "sk-real-openai-key-123")
+	require.NoError(t, breakers.Allow(key))
+	breakers.Record(key, true, false)
+
+	completion, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:    "gpt-4o",
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hello")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello!", completion.Choices[0].Message.Content)
+	assert.Equal(t, 1, services.GetMetricsService().GetStats()["total_failovers"])
+}
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/middleware"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupConfigCopy loads a copy of the keys configuration, so the tests can modify it
+func setupConfigCopy(t *testing.T) string {
+	os.Setenv("IS_TEST", "1")
+
+	b, err := os.ReadFile("../internal/configs/keys.json")
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, b, 0600))
+	require.NoError(t, configs.LoadConfigFile(path))
+
+	t.Cleanup(configs.LoadConfig)
+	return path
+}
+
+// TestLoadConfigFile_InvalidFileRejected verifies that a file which fails to parse or validate
+// is rejected and the previous config stays active
+func TestLoadConfigFile_InvalidFileRejected(t *testing.T) {
+	path := setupConfigCopy(t)
+
+	testCases := map[string]string{
+		"malformed json":   `{"virtual_keys": {`,
+		"missing keys":     `{"pricing": {}}`,
+		"unroutable key":   `{"virtual_keys": {"vk_new": {"provider": "openai"}}}`,
+		"malformed window": `{"virtual_keys": {"vk_new": {"provider": "openai", "api_key": "sk-x", "window": "daily"}}}`,
+		"malformed prices": `{"virtual_keys": {}, "pricing": {"gpt-4o": {"input": "cheap"}}}`,
+	}
+	for name, content := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+			assert.Error(t, configs.ReloadConfig())
+			assert.NotNil(t, configs.VirtualKeyConfig("vk_user1_openai"))
+			assert.Nil(t, configs.VirtualKeyConfig("vk_new"))
+		})
+	}
+}
+
+// TestSnapshot_KeptByInFlightRequests verifies that a reload doesn't change a snapshot already in use
+func TestSnapshot_KeptByInFlightRequests(t *testing.T) {
+	path := setupConfigCopy(t)
+	snapshot := configs.Current()
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"virtual_keys": {"vk_new": {"provider": "openai", "api_key": "sk-x"}}}`), 0600))
+	require.NoError(t, configs.ReloadConfig())
+
+	assert.NotNil(t, snapshot.VirtualKeyConfig("vk_user1_openai"))
+	assert.Nil(t, snapshot.VirtualKeyConfig("vk_new"))
+	assert.Nil(t, configs.VirtualKeyConfig("vk_user1_openai"))
+	assert.NotNil(t, configs.VirtualKeyConfig("vk_new"))
+}
+
+// TestConfigSnapshot_CapturedOncePerRequest verifies that a request keeps reading the snapshot it captured
+// when the config is reloaded while it runs
+func TestConfigSnapshot_CapturedOncePerRequest(t *testing.T) {
+	path := setupConfigCopy(t)
+	gin.SetMode(gin.TestMode)
+
+	var before, after *configs.Snapshot
+	r := gin.New()
+	r.Use(middleware.ConfigSnapshot())
+	r.GET("/", func(c *gin.Context) {
+		before = configs.FromContext(c)
+		require.NoError(t, os.WriteFile(path, []byte(`{"virtual_keys": {"vk_new": {"provider": "openai", "api_key": "sk-x"}}}`), 0600))
+		require.NoError(t, configs.ReloadConfig())
+		after = configs.FromContext(c)
+		c.Status(http.StatusOK)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Same(t, before, after)
+	assert.NotNil(t, after.VirtualKeyConfig("vk_user1_openai"))
+	assert.Nil(t, after.VirtualKeyConfig("vk_new"))
+	assert.NotSame(t, after, configs.Current())
+}
+
+// TestWatchConfig_ReloadsOnChange verifies that the watched file is reloaded when it is written or replaced,
+// and that an invalid change is ignored
+func TestWatchConfig_ReloadsOnChange(t *testing.T) {
+	path := setupConfigCopy(t)
+	stop, err := configs.WatchConfig()
+	require.NoError(t, err)
+	defer stop()
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	updated := strings.Replace(string(b), `"vk_user1_openai"`, `"vk_user5_openai"`, 1)
+	require.NoError(t, os.WriteFile(path, []byte(updated), 0600))
+
+	assert.Eventually(t, func() bool {
+		return configs.VirtualKeyConfig("vk_user5_openai") != nil
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Nil(t, configs.VirtualKeyConfig("vk_user1_openai"))
+
+	// Replaced through a rename, with an invalid key set
+	tmp := path + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, []byte(`{"virtual_keys": {"vk_broken": {}}}`), 0600))
+	require.NoError(t, os.Rename(tmp, path))
+
+	time.Sleep(200 * time.Millisecond)
+	assert.NotNil(t, configs.VirtualKeyConfig("vk_user5_openai"))
+	assert.Nil(t, configs.VirtualKeyConfig("vk_broken"))
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/http/validators"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/providers"
+	"qualifire-home-assignment/internal/services"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newOpenAIFallbackServer starts a fake OpenAI server which fails with the given status for the "failing-key" api key
+func newOpenAIFallbackServer(failureStatus int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") == "Bearer failing-key" {
+			w.WriteHeader(failureStatus)
+			w.Write([]byte(`{"error": {"message": "upstream failure", "type": "server_error"}}`))
+			return
+		}
+		w.Write([]byte(`{
+			"id": "chatcmpl-123",
+			"model": "gpt-4o-mini",
+			"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "Hello!"}}]
+		}`))
+	}))
+}
+
+// newFallbackRequest creates a proxy request with a chain of the given api keys
+func newFallbackRequest(apiKeys ...string) models.ProxyRequest {
+	targets := make([]models.Target, 0, len(apiKeys))
+	for i, apiKey := range apiKeys {
+		targets = append(targets, models.Target{Provider: "openai", ApiKey: apiKey, Model: []string{"gpt-4o", "gpt-4o-mini"}[i%2]})
+	}
+	return models.ProxyRequest{
+		Provider:   targets[0].Provider,
+		ApiKey:     targets[0].ApiKey,
+		Messages:   []models.Message{{Role: "user", Content: "Hello"}},
+		Model:      "gpt-4o",
+		VirtualKey: "test-key-fallback",
+		Targets:    targets,
+	}
+}
+
+// TestFactory_Fallback verifies that the Factory returns the fallback provider for a chain of targets
+func TestFactory_Fallback(t *testing.T) {
+	provider := providers.Factory(newFallbackRequest("key-1", "key-2"))
+
+	assert.IsType(t, providers.Fallback{}, provider)
+}
+
+// TestFallback_SendRequest_FailsOver verifies that a retryable failure moves the request to the next target,
+// the answering target is reported and the failover is counted
+func TestFallback_SendRequest_FailsOver(t *testing.T) {
+	server := newOpenAIFallbackServer(http.StatusServiceUnavailable)
+	defer server.Close()
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+	metricsService := services.GetMetricsService()
+	metricsService.Reset()
+
+	result := providers.Factory(newFallbackRequest("failing-key", "working-key")).SendRequest(context.Background())
+
+	assert.Equal(t, "Hello!", result.Choices[0].Content)
+	assert.Equal(t, "openai", result.Provider)
+	assert.Equal(t, "gpt-4o-mini", result.Model)
+	stats := metricsService.GetStats()
+	assert.Equal(t, 1, stats["total_failovers"])
+	assert.Equal(t, 1, stats["failovers_per_provider"].(map[string]int)["openai"])
+}
+
+// TestFallback_SendRequest_NonRetryable verifies that a non-retryable failure is returned without trying the next target
+func TestFallback_SendRequest_NonRetryable(t *testing.T) {
+	server := newOpenAIFallbackServer(http.StatusBadRequest)
+	defer server.Close()
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+	metricsService := services.GetMetricsService()
+	metricsService.Reset()
+
+	defer func() {
+		r := recover()
+		assert.IsType(t, errors.ApiProvider{}, r)
+		assert.Equal(t, http.StatusBadRequest, r.(errors.ApiProvider).StatusCode)
+		assert.Equal(t, 0, metricsService.GetStats()["total_failovers"])
+	}()
+
+	providers.Factory(newFallbackRequest("failing-key", "working-key")).SendRequest(context.Background())
+}
+
+// TestFallback_SendRequest_AllTargetsFail verifies that the error of the last target is returned when the whole chain fails
+func TestFallback_SendRequest_AllTargetsFail(t *testing.T) {
+	server := newOpenAIFallbackServer(http.StatusBadGateway)
+	defer server.Close()
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+
+	defer func() {
+		r := recover()
+		assert.IsType(t, errors.ApiProvider{}, r)
+		assert.Equal(t, http.StatusBadGateway, r.(errors.ApiProvider).StatusCode)
+	}()
+
+	providers.Factory(newFallbackRequest("failing-key", "failing-key")).SendRequest(context.Background())
+}
+
+// TestGetTargets_SingleProvider verifies that a key with a single provider is mapped to a chain of one target
+func TestGetTargets_SingleProvider(t *testing.T) {
+	targets := validators.GetTargets(map[string]interface{}{
+		"provider": "openai",
+		"api_key":  "sk-test",
+	})
+
+	assert.Equal(t, []models.Target{{Provider: "openai", ApiKey: "sk-test"}}, targets)
+}
+
+// TestGetTargets_Chain verifies that the targets of a key are kept in their declared order
+func TestGetTargets_Chain(t *testing.T) {
+	setupTest()
+
+	keyInfo, _ := validators.GetKeyInfo(configs.Current(), &validators.ChatCompletion{AuthToken: "Bearer vk_user3_fallback"})
+	targets := validators.GetTargets(keyInfo)
+
+	assert.Len(t, targets, 2)
+	assert.Equal(t, "openai", targets[0].Provider)
+	assert.Equal(t, "gpt-4o", targets[0].Model)
+	assert.Equal(t, "anthropic", targets[1].Provider)
+}
@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"qualifire-home-assignment/internal/http/errors"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/providers"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// geminiProvider returns the gateway provider sending the messages to Gemini
+func geminiProvider(messages []models.Message, options models.ChatOptions) providers.Provider {
+	return providers.Factory(models.ProxyRequest{
+		Provider: "gemini",
+		ApiKey:   "gemini-test-key",
+		Messages: messages,
+		Model:    "gemini-2.0-flash",
+		Options:  options,
+	})
+}
+
+const geminiHello = `{
+	"candidates": [{"index": 0, "content": {"role": "model", "parts": [{"text": "Bonjour"}]}, "finishReason": "STOP"}],
+	"usageMetadata": {"promptTokenCount": 20, "candidatesTokenCount": 3, "totalTokenCount": 23},
+	"responseId": "resp_1"
+}`
+
+// TestGemini_Request verifies that the system and developer messages become the system instruction, that the
+// assistant turns become model turns, that the tool messages become function responses named after their call,
+// and that the generation parameters are sent
+func TestGemini_Request(t *testing.T) {
+	upstream := newFakeUpstream(t, http.StatusOK, "application/json", geminiHello)
+	upstream.SetEndpoint(t, "GEMINI_ENDPOINT")
+	temperature, maxTokens := 0.2, int64(64)
+
+	geminiProvider([]models.Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "developer", Content: "Answer in French."},
+		{Role: "user", Content: "Weather in Paris?"},
+		{Role: "assistant", ToolCalls: []models.ToolCall{{ID: "call_1", Type: "function", Function: models.FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}}}},
+		{Role: "tool", ToolCallID: "call_1", Content: "sunny"},
+		{Role: "user", Parts: []models.ContentPart{{Type: models.CONTENT_PART_IMAGE, ImageURL: &models.ImageURL{URL: "data:image/png;base64,aGVsbG8="}}}},
+	}, models.ChatOptions{
+		Temperature: &temperature,
+		MaxTokens:   &maxTokens,
+		Stop:        []string{"END"},
+		Tools:       []models.Tool{{Type: "function", Function: models.FunctionDefinition{Name: "get_weather", Parameters: map[string]interface{}{"type": "object"}}}},
+		ToolChoice:  &models.ToolChoice{Mode: models.TOOL_CHOICE_REQUIRED},
+	}).SendRequest(context.Background())
+
+	assert.Equal(t, "/v1beta/models/gemini-2.0-flash:generateContent", upstream.Last().URL)
+	assert.Equal(t, "gemini-test-key", upstream.Last().Header.Get("x-goog-api-key"))
+	assert.Equal(t, map[string]interface{}{"parts": []interface{}{
+		map[string]interface{}{"text": "Be brief."},
+		map[string]interface{}{"text": "Answer in French."},
+	}}, upstream.Last().Body["systemInstruction"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"role": "user", "parts": []interface{}{map[string]interface{}{"text": "Weather in Paris?"}}},
+		map[string]interface{}{"role": "model", "parts": []interface{}{
+			map[string]interface{}{"functionCall": map[string]interface{}{"name": "get_weather", "args": map[string]interface{}{"city": "Paris"}}},
+		}},
+		map[string]interface{}{"role": "user", "parts": []interface{}{
+			map[string]interface{}{"functionResponse": map[string]interface{}{"name": "get_weather", "response": map[string]interface{}{"content": "sunny"}}},
+			map[string]interface{}{"inlineData": map[string]interface{}{"mimeType": "image/png", "data": "aGVsbG8="}},
+		}},
+	}, upstream.Last().Body["contents"])
+	assert.Equal(t, map[string]interface{}{"temperature": 0.2, "maxOutputTokens": float64(64), "stopSequences": []interface{}{"END"}}, upstream.Last().Body["generationConfig"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"functionDeclarations": []interface{}{
+		map[string]interface{}{"name": "get_weather", "parametersJsonSchema": map[string]interface{}{"type": "object"}},
+	}}}, upstream.Last().Body["tools"])
+	assert.Equal(t, map[string]interface{}{"functionCallingConfig": map[string]interface{}{"mode": "ANY"}}, upstream.Last().Body["toolConfig"])
+}
+
+// TestGemini_Response verifies that the text and function call parts, the finish reason and the usage metadata
+// of the response are parsed, the thinking tokens being counted as completion tokens
+func TestGemini_Response(t *testing.T) {
+	newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"candidates": [{"index": 0, "content": {"role": "model", "parts": [
+			{"text": "Thinking about the weather", "thought": true},
+			{"text": "Let me check."},
+			{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}
+		]}, "finishReason": "STOP"}],
+		"usageMetadata": {"promptTokenCount": 30, "candidatesTokenCount": 12, "thoughtsTokenCount": 8, "cachedContentTokenCount": 10},
+		"responseId": "resp_2"
+	}`).SetEndpoint(t, "GEMINI_ENDPOINT")
+
+	result := geminiProvider([]models.Message{{Role: "user", Content: "Weather in Paris?"}}, models.ChatOptions{}).SendRequest(context.Background())
+
+	require.Len(t, result.Choices, 1)
+	assert.Equal(t, "resp_2", result.ID)
+	assert.Equal(t, "Let me check.", result.Choices[0].Content)
+	assert.Equal(t, "tool_calls", result.Choices[0].FinishReason)
+	require.Len(t, result.Choices[0].ToolCalls, 1)
+	assert.Contains(t, result.Choices[0].ToolCalls[0].ID, "call_")
+	assert.Equal(t, "get_weather", result.Choices[0].ToolCalls[0].Function.Name)
+	assert.JSONEq(t, `{"city": "Paris"}`, result.Choices[0].ToolCalls[0].Function.Arguments)
+	assert.Equal(t, 30, result.Usage.PromptTokens)
+	assert.Equal(t, 20, result.Usage.CompletionTokens)
+	assert.Equal(t, 10, result.Usage.CachedTokens)
+	assert.Equal(t, 50, result.TokensUsed)
+}
+
+// TestGemini_Stream verifies that the streamed text fragments are forwarded and aggregated with the usage of the last chunk
+func TestGemini_Stream(t *testing.T) {
+	upstream := newFakeUpstream(t, http.StatusOK, "text/event-stream",
+		"data: {\"candidates\": [{\"index\": 0, \"content\": {\"role\": \"model\", \"parts\": [{\"text\": \"Bon\"}]}}]}\n\n"+
+			"data: {\"candidates\": [{\"index\": 0, \"content\": {\"role\": \"model\", \"parts\": [{\"text\": \"jour\"}]}, \"finishReason\": \"MAX_TOKENS\"}], "+
+			"\"usageMetadata\": {\"promptTokenCount\": 20, \"candidatesTokenCount\": 2}}\n\n")
+	upstream.SetEndpoint(t, "GEMINI_ENDPOINT")
+
+	var deltas []string
+	result := geminiProvider([]models.Message{{Role: "user", Content: "Hi"}}, models.ChatOptions{}).StreamRequest(context.Background(), func(chunk providers.StreamChunk) {
+		deltas = append(deltas, chunk.Delta.Content)
+	})
+
+	assert.Equal(t, "/v1beta/models/gemini-2.0-flash:streamGenerateContent?alt=sse", upstream.Last().URL)
+	assert.Equal(t, []string{"Bon", "jour"}, deltas)
+	require.Len(t, result.Choices, 1)
+	assert.Equal(t, "Bonjour", result.Choices[0].Content)
+	assert.Equal(t, "length", result.Choices[0].FinishReason)
+	assert.Equal(t, 22, result.TokensUsed)
+}
+
+// TestGemini_Errors verifies that the Gemini error payloads are propagated with their message and status,
+// and that blocked prompts and image URLs are rejected
+func TestGemini_Errors(t *testing.T) {
+	send := func() {
+		geminiProvider([]models.Message{{Role: "user", Content: "Hi"}}, models.ChatOptions{}).SendRequest(context.Background())
+	}
+
+	newFakeUpstream(t, http.StatusTooManyRequests, "application/json", `{"error": {"code": 429, "message": "Resource has been exhausted", "status": "RESOURCE_EXHAUSTED"}}`).SetEndpoint(t, "GEMINI_ENDPOINT")
+	err := recoverProviderError(send)
+	assert.Equal(t, http.StatusTooManyRequests, err.StatusCode)
+	assert.Equal(t, "Resource has been exhausted", err.Message)
+
+	newFakeUpstream(t, http.StatusBadGateway, "text/html", `<html>Bad Gateway</html>`).SetEndpoint(t, "GEMINI_ENDPOINT")
+	err = recoverProviderError(send)
+	assert.Equal(t, http.StatusBadGateway, err.StatusCode)
+	assert.Equal(t, "502 Bad Gateway", err.Message)
+
+	newFakeUpstream(t, http.StatusOK, "application/json", `{"promptFeedback": {"blockReason": "SAFETY"}}`).SetEndpoint(t, "GEMINI_ENDPOINT")
+	err = recoverProviderError(send)
+	assert.Equal(t, http.StatusBadRequest, err.StatusCode)
+	assert.Equal(t, "gemini blocked the prompt: SAFETY", err.Message)
+
+	upstream := newFakeUpstream(t, http.StatusOK, "application/json", geminiHello)
+	upstream.SetEndpoint(t, "GEMINI_ENDPOINT")
+	err = recoverProviderError(func() {
+		geminiProvider([]models.Message{{Role: "user", Parts: []models.ContentPart{
+			{Type: models.CONTENT_PART_IMAGE, ImageURL: &models.ImageURL{URL: "https://example.com/cat.png"}},
+		}}}, models.ChatOptions{}).SendRequest(context.Background())
+	})
+	assert.Equal(t, http.StatusBadRequest, err.StatusCode)
+	assert.Empty(t, upstream.Requests)
+}
+
+// TestGemini_UnansweredToolMessage verifies that a tool message which answers no earlier tool call is rejected
+// before calling Gemini, since its function response would have no name
+func TestGemini_UnansweredToolMessage(t *testing.T) {
+	upstream := newFakeUpstream(t, http.StatusOK, "application/json", geminiHello)
+	upstream.SetEndpoint(t, "GEMINI_ENDPOINT")
+
+	var err errors.Error
+	func() {
+		defer func() { err = recover().(errors.Error) }()
+		geminiProvider([]models.Message{
+			{Role: "user", Content: "Weather in Paris?"},
+			{Role: "tool", ToolCallID: "call_unknown", Content: "sunny"},
+		}, models.ChatOptions{}).SendRequest(context.Background())
+	}()
+
+	assert.Equal(t, "VALIDATION_ERROR", err.Code)
+	assert.Equal(t, http.StatusBadRequest, err.StatusCode)
+	assert.Empty(t, upstream.Requests)
+}
@@ -0,0 +1,224 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/routes"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/providers"
+	"qualifire-home-assignment/internal/services"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// acquireKeys acquires and releases n keys of the pool, and returns the chosen keys in order
+func acquireKeys(t *testing.T, keys []models.UpstreamKey, balancing string, n int) []string {
+	pool := services.GetKeyPoolService()
+	chosen := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		apiKey, ok := pool.Acquire("openai", keys, balancing, nil)
+		require.True(t, ok)
+		pool.Release("openai", apiKey, 0, 0)
+		chosen = append(chosen, apiKey)
+	}
+	return chosen
+}
+
+// TestKeyPool_Balancing verifies the round robin, weighted and least in flight choices of the upstream keys
+func TestKeyPool_Balancing(t *testing.T) {
+	pool := services.GetKeyPoolService()
+	pool.Reset()
+	defer pool.Reset()
+	keys := []models.UpstreamKey{{ApiKey: "sk-a"}, {ApiKey: "sk-b", Weight: 3}, {ApiKey: "sk-c"}}
+
+	assert.Equal(t, []string{"sk-a", "sk-b", "sk-c", "sk-a"}, acquireKeys(t, keys, models.BALANCING_ROUND_ROBIN, 4))
+	assert.Equal(t, []string{"sk-b", "sk-a", "sk-b", "sk-c", "sk-b"}, acquireKeys(t, keys, models.BALANCING_WEIGHTED, 5))
+
+	busy, _ := pool.Acquire("openai", keys, models.BALANCING_LEAST_IN_FLIGHT, nil)
+	defer pool.Release("openai", busy, 0, 0)
+	for _, apiKey := range acquireKeys(t, keys, models.BALANCING_LEAST_IN_FLIGHT, 4) {
+		assert.NotEqual(t, busy, apiKey)
+	}
+
+	_, ok := pool.Acquire("openai", keys, "", map[string]bool{"sk-a": true, "sk-b": true, "sk-c": true})
+	assert.False(t, ok)
+}
+
+// TestKeyPool_RateLimitedKeyCoolsDown verifies that a rate limited key is skipped until its cooldown elapses,
+// unless all the keys of the pool are cooling down
+func TestKeyPool_RateLimitedKeyCoolsDown(t *testing.T) {
+	pool := services.GetKeyPoolService()
+	pool.Reset()
+	cooldown := pool.GetCooldown()
+	pool.SetCooldown(50 * time.Millisecond)
+	defer func() {
+		pool.SetCooldown(cooldown)
+		pool.Reset()
+	}()
+	keys := []models.UpstreamKey{{ApiKey: "sk-a"}, {ApiKey: "sk-b"}}
+
+	apiKey, _ := pool.Acquire("openai", keys, "", nil)
+	require.Equal(t, "sk-a", apiKey)
+	pool.Release("openai", apiKey, http.StatusTooManyRequests, 0)
+	assert.Equal(t, []string{"sk-b", "sk-b"}, acquireKeys(t, keys, "", 2))
+
+	apiKey, _ = pool.Acquire("openai", keys, "", nil)
+	pool.Release("openai", apiKey, http.StatusTooManyRequests, 0)
+	assert.Equal(t, []string{"sk-a"}, acquireKeys(t, keys, "", 1), "the key which cools down first")
+
+	time.Sleep(60 * time.Millisecond)
+	assert.ElementsMatch(t, []string{"sk-a", "sk-b"}, acquireKeys(t, keys, "", 2))
+}
+
+// setupRateLimitedPool starts a fake OpenAI server which rate limits the upstream key sk-pool-key-0001,
+// and returns a client of a virtual key pooling it with sk-pool-key-0002 and the upstream keys used in order
+func setupRateLimitedPool(t *testing.T) (openai.Client, *[]string) {
+	var mu sync.Mutex
+	var used []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		mu.Lock()
+		used = append(used, apiKey)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if apiKey == "sk-pool-key-0001" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error": {"message": "Rate limit reached", "type": "requests", "code": "rate_limit_exceeded"}}`))
+			return
+		}
+		w.Write([]byte(`{
+			"id": "chatcmpl-1",
+			"model": "gpt-4o",
+			"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "Hello!"}}],
+			"usage": {"prompt_tokens": 10, "completion_tokens": 2, "total_tokens": 12}
+		}`))
+	}))
+	t.Cleanup(server.Close)
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	t.Cleanup(func() { os.Unsetenv("OPENAI_BASE_URL") })
+
+	client := newGatewayClient(t, "vk_pool")
+	path := setupConfigCopy(t)
+	require.NoError(t, os.WriteFile(path, []byte(`{"virtual_keys": {
+		"vk_pool": {"provider": "openai", "api_keys": [{"api_key": "sk-pool-key-0001"}, {"api_key": "sk-pool-key-0002"}]}
+	}}`), 0600))
+	require.NoError(t, configs.ReloadConfig())
+	return client, &used
+}
+
+// TestKeyPool_Gateway verifies that a virtual key with a pool of upstream keys moves to another key
+// when one is rate limited, and that the usage of every upstream key is reported in /metrics
+func TestKeyPool_Gateway(t *testing.T) {
+	client, used := setupRateLimitedPool(t)
+
+	for i := 0; i < 2; i++ {
+		completion, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+			Model:    "gpt-4o",
+			Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hello")},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "Hello!", completion.Choices[0].Message.Content)
+	}
+	assert.Equal(t, []string{"sk-pool-key-0001", "sk-pool-key-0002", "sk-pool-key-0002"}, *used, "the rate limited key cools down")
+
+	w := httptest.NewRecorder()
+	routes.HandleRequests().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	var stats struct {
+		UpstreamKeys []services.UpstreamKeyUsage `json:"upstream_keys"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	require.Len(t, stats.UpstreamKeys, 2)
+	assert.Equal(t, "************0001", stats.UpstreamKeys[0].ApiKey)
+	assert.Equal(t, models.Fingerprint("sk-pool-key-0001"), stats.UpstreamKeys[0].Fingerprint)
+	assert.Equal(t, 1, stats.UpstreamKeys[0].RateLimited)
+	assert.NotNil(t, stats.UpstreamKeys[0].CoolingDownUntil)
+	assert.Equal(t, "************0002", stats.UpstreamKeys[1].ApiKey)
+	assert.Equal(t, 2, stats.UpstreamKeys[1].Requests)
+	assert.Equal(t, 24, stats.UpstreamKeys[1].Tokens)
+	assert.Equal(t, 0, stats.UpstreamKeys[1].InFlight)
+
+	w = httptest.NewRecorder()
+	routes.HandleRequests().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics?format=prometheus", nil))
+	assert.Contains(t, w.Body.String(), `llm_gateway_upstream_key_requests_total{provider="openai",api_key_fingerprint="`+models.Fingerprint("sk-pool-key-0002")+`"} 2`)
+	assert.Contains(t, w.Body.String(), `llm_gateway_upstream_key_rate_limited_total{provider="openai",api_key_fingerprint="`+models.Fingerprint("sk-pool-key-0001")+`"} 1`)
+}
+
+// TestKeyPool_RateLimitedKeyNotRetried verifies that a rate limited key of a pool isn't retried by the transport,
+// the pool moving to another key at once
+func TestKeyPool_RateLimitedKeyNotRetried(t *testing.T) {
+	os.Setenv("PROVIDER_MAX_RETRIES", "2")
+	os.Setenv("PROVIDER_RETRY_BASE_DELAY_MS", "1")
+	defer os.Unsetenv("PROVIDER_MAX_RETRIES")
+	defer os.Unsetenv("PROVIDER_RETRY_BASE_DELAY_MS")
+	client, used := setupRateLimitedPool(t)
+
+	_, err := complete(client, "gpt-4o")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sk-pool-key-0001", "sk-pool-key-0002"}, *used)
+}
+
+// TestKeyPool_OpenCircuitSkipped verifies that a pool moves to another key when the circuit breaker of
+// the chosen key is open, instead of failing the request
+func TestKeyPool_OpenCircuitSkipped(t *testing.T) {
+	client, used := setupRateLimitedPool(t)
+	breakers := setCircuitBreakerConfig(t, services.CircuitBreakerConfig{
+		ErrorRate: 0.5, MinRequests: 1, Window: time.Minute, OpenDuration: time.Minute, HalfOpenProbes: 1,
+	})
+	key := services.NewBreakerKey("openai", os.Getenv("OPENAI_BASE_URL"), "sk-pool-key-0001")
+	require.NoError(t, breakers.Allow(key))
+	breakers.Record(key, true, false)
+
+	for i := 0; i < 2; i++ {
+		completion, err := complete(client, "gpt-4o")
+		require.NoError(t, err)
+		assert.Equal(t, "Hello!", completion.Choices[0].Message.Content)
+	}
+	assert.Equal(t, []string{"sk-pool-key-0002", "sk-pool-key-0002"}, *used, "the key whose circuit is open isn't sent to")
+}
+
+// TestKeyPool_ReleasedOnPanic verifies that a key is released when the request panics with an error
+// which isn't a provider error, so it isn't counted in flight for good
+func TestKeyPool_ReleasedOnPanic(t *testing.T) {
+	pool := services.GetKeyPoolService()
+	pool.Reset()
+	defer pool.Reset()
+
+	assert.Panics(t, func() {
+		providers.Factory(models.ProxyRequest{
+			Provider: "gemini",
+			ApiKeys:  []models.UpstreamKey{{ApiKey: "gemini-pool-key-1"}},
+			Model:    "gemini-2.0-flash",
+			Messages: []models.Message{{Role: "tool", ToolCallID: "call_unknown", Content: "sunny"}},
+		}).SendRequest(context.Background())
+	})
+
+	usage := pool.GetUsage()
+	require.Len(t, usage, 1)
+	assert.Equal(t, 0, usage[0].InFlight)
+	assert.Equal(t, 1, usage[0].Errors)
+}
+
+// TestKeyPool_Validation verifies that the upstream keys of a pool and its balancing are validated
+func TestKeyPool_Validation(t *testing.T) {
+	valid := models.VirtualKey{Provider: "openai", ApiKeys: []models.UpstreamKey{{ApiKey: "sk-a", Weight: 2}}, Balancing: models.BALANCING_WEIGHTED}
+	assert.NoError(t, valid.Validate())
+
+	assert.ErrorContains(t, models.VirtualKey{Provider: "openai", ApiKeys: []models.UpstreamKey{{ApiKey: "sk-a"}}, Balancing: "random"}.Validate(), "balancing")
+	assert.ErrorContains(t, models.VirtualKey{Provider: "openai", ApiKeys: []models.UpstreamKey{{ApiKey: ""}}}.Validate(), "requires an api_key")
+	assert.ErrorContains(t, models.VirtualKey{Targets: []models.Target{{Provider: "openai", ApiKeys: []models.UpstreamKey{{ApiKey: "sk-a", Weight: -1}}}}}.Validate(), "negative")
+
+	masked := valid.Masked()
+	assert.Equal(t, "****", masked.ApiKeys[0].ApiKey)
+	assert.Equal(t, "sk-a", valid.ApiKeys[0].ApiKey)
+}
@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/routes"
+	"qualifire-home-assignment/internal/services"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sendChatCompletion sends a request to the legacy chat completions endpoint with the current config
+func sendChatCompletion(virtualKey string, body []byte) *httptest.ResponseRecorder {
+	services.GetQuotaService().Reset()
+	req := httptest.NewRequest(http.MethodPost, "/chat/completions", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+virtualKey)
+	w := httptest.NewRecorder()
+	routes.HandleRequests().ServeHTTP(w, req)
+	return w
+}
+
+// decodeBody decodes the JSON body of a response
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), w.Body.String())
+	return body
+}
+
+// TestLimits_LongContentAccepted verifies that a message content is no longer capped at 255 characters
+func TestLimits_LongContentAccepted(t *testing.T) {
+	server := newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"id": "chatcmpl-1",
+		"model": "gpt-4o",
+		"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "Done."}}],
+		"usage": {"prompt_tokens": 300, "completion_tokens": 2, "total_tokens": 302}
+	}`)
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+	client := newGatewayClient(t, "vk_user1_openai")
+
+	content := strings.Repeat("a", 1000)
+	_, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:    "gpt-4o",
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage(content)},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, content, server.Last().Body["messages"].([]interface{})[0].(map[string]interface{})["content"])
+}
+
+// TestLimits_GlobalLimits verifies that the global limits are enforced and that the errors name the exceeded limit
+func TestLimits_GlobalLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      string
+		value    float64
+		messages []openai.ChatCompletionMessageParamUnion
+		limit    string
+		actual   float64
+	}{
+		{
+			name:     "max messages",
+			env:      "MAX_MESSAGES",
+			value:    2,
+			messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("a"), openai.AssistantMessage("b"), openai.UserMessage("c")},
+			limit:    "max_messages",
+			actual:   3,
+		},
+		{
+			name:     "max message chars",
+			env:      "MAX_MESSAGE_CHARS",
+			value:    10,
+			messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("short"), openai.UserMessage("héllo wörld!")},
+			limit:    "max_message_chars",
+			actual:   12,
+		},
+		{
+			name:     "max input tokens",
+			env:      "MAX_INPUT_TOKENS",
+			value:    10,
+			messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage(strings.Repeat("hello ", 50))},
+			limit:    "max_input_tokens",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv(tt.env, strconv.Itoa(int(tt.value)))
+			defer os.Unsetenv(tt.env)
+			client := newGatewayClient(t, "vk_user1_openai")
+
+			_, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+				Model:    "gpt-4o",
+				Messages: tt.messages,
+			})
+
+			var apiErr *openai.Error
+			require.ErrorAs(t, err, &apiErr)
+			assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+			assert.Equal(t, tt.limit, apiErr.Param)
+			assert.Contains(t, apiErr.Message, tt.limit+" exceeded")
+
+			var body map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(apiErr.RawJSON()), &body))
+			limit := body["limit"].(map[string]interface{})
+			assert.Equal(t, tt.limit, limit["name"])
+			assert.Equal(t, tt.value, limit["max"])
+			if tt.actual > 0 {
+				assert.Equal(t, tt.actual, limit["actual"])
+			} else {
+				assert.Greater(t, limit["actual"], limit["max"])
+			}
+		})
+	}
+}
+
+// TestLimits_PerKeyLimits verifies that the limits of a key override the global limits, in the legacy error format
+func TestLimits_PerKeyLimits(t *testing.T) {
+	server := newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"id": "chatcmpl-1",
+		"model": "gpt-4o",
+		"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "Done."}}]
+	}`)
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+	path := setupConfigCopy(t)
+	require.NoError(t, os.WriteFile(path, []byte(`{"virtual_keys": {
+		"vk_small": {"provider": "openai", "api_key": "sk-x", "max_messages": 1, "max_body_bytes": 200},
+		"vk_large": {"provider": "openai", "api_key": "sk-x"}
+	}}`), 0600))
+	require.NoError(t, configs.ReloadConfig())
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":    "gpt-4o",
+		"messages": []map[string]string{{"role": "user", "content": "a"}, {"role": "user", "content": "b"}},
+	})
+	w := sendChatCompletion("vk_small", body)
+	require.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	assert.Equal(t, map[string]interface{}{"name": "max_messages", "max": 1.0, "actual": 2.0}, decodeBody(t, w)["limit"])
+
+	body, _ = json.Marshal(map[string]interface{}{
+		"model":    "gpt-4o",
+		"messages": []map[string]string{{"role": "user", "content": strings.Repeat("a", 300)}},
+	})
+	w = sendChatCompletion("vk_small", body)
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	body413 := decodeBody(t, w)
+	assert.Equal(t, "VALIDATION_ERROR", body413["code"])
+	assert.Equal(t, "max_body_bytes", body413["limit"].(map[string]interface{})["name"])
+
+	// The other keys keep the global limits
+	w = sendChatCompletion("vk_large", body)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+// TestLimits_BodyOfUnknownLength verifies that a body without a Content-Length is cut at the limit
+func TestLimits_BodyOfUnknownLength(t *testing.T) {
+	os.Setenv("MAX_BODY_BYTES", "100")
+	defer os.Unsetenv("MAX_BODY_BYTES")
+	newGatewayClient(t, "vk_user1_openai")
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "`+strings.Repeat("a", 200)+`"}]}`))
+	req.ContentLength = -1
+	req.Header.Set("Authorization", "Bearer vk_user1_openai")
+	w := httptest.NewRecorder()
+	routes.HandleRequests().ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	assert.Equal(t, "max_body_bytes", decodeBody(t, w)["error"].(map[string]interface{})["param"])
+}
@@ -1,11 +1,14 @@
 package tests
 
 import (
+	"bytes"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"qualifire-home-assignment/internal/configs"
 	"qualifire-home-assignment/internal/http/errors"
 	"qualifire-home-assignment/internal/http/middleware"
+	"qualifire-home-assignment/internal/models"
 	"qualifire-home-assignment/internal/services"
 	"testing"
 	"time"
@@ -86,6 +89,8 @@ func TestMetricsMiddleware_QuotaIncrement(t *testing.T) {
 
 	router := setupMetricsTestRouter()
 	router.POST("/tests/chat/completions", func(c *gin.Context) {
+		// The controllers count the request when they reserve it, the middleware charges its tokens
+		quotaService.ReserveRequest(configs.Current(), "test-key")
 		c.Set("provider", "openai")
 		c.Set("token_count", 250)
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
@@ -250,3 +255,87 @@ func TestMetricsMiddleware_VirtualKeyExtraction(t *testing.T) {
 	json.Unmarshal(w.Body.Bytes(), &response)
 	assert.Equal(t, "my-test-key", response["key"])
 }
+
+// TestMetricsService_WritePrometheus Test that request counters, latency histograms and failovers are exposed with their labels
+func TestMetricsService_WritePrometheus(t *testing.T) {
+	metricsService := services.GetMetricsService()
+	metricsService.Reset()
+
+	metricsService.RecordRequest(services.RequestMetric{
+		Provider:   "openai",
+		VirtualKey: "vk_user1_openai",
+		Model:      "gpt-4o",
+		Duration:   200 * time.Millisecond,
+		Status:     http.StatusOK,
+		Tokens:     42,
+		CostUSD:    0.125,
+	})
+	metricsService.RecordFailover(services.FailoverMetric{
+		VirtualKey:   "vk_user3_fallback",
+		FromProvider: "openai",
+		ToProvider:   "anthropic",
+		Status:       http.StatusServiceUnavailable,
+	})
+
+	var buf bytes.Buffer
+	metricsService.WritePrometheus(&buf)
+	output := buf.String()
+
+	labels := `provider="openai",virtual_key_fingerprint="` + models.Fingerprint("vk_user1_openai") + `",model="gpt-4o",status="200"`
+	assert.Contains(t, output, "# TYPE llm_gateway_requests_total counter")
+	assert.Contains(t, output, "llm_gateway_requests_total{"+labels+"} 1\n")
+	assert.Contains(t, output, "llm_gateway_tokens_total{"+labels+"} 42\n")
+	assert.Contains(t, output, "llm_gateway_spend_usd_total{"+labels+"} 0.125\n")
+	assert.Contains(t, output, "# TYPE llm_gateway_request_duration_seconds histogram")
+	assert.Contains(t, output, "llm_gateway_request_duration_seconds_bucket{"+labels+`,le="0.1"} 0`+"\n")
+	assert.Contains(t, output, "llm_gateway_request_duration_seconds_bucket{"+labels+`,le="0.25"} 1`+"\n")
+	assert.Contains(t, output, "llm_gateway_request_duration_seconds_bucket{"+labels+`,le="+Inf"} 1`+"\n")
+	assert.Contains(t, output, "llm_gateway_request_duration_seconds_count{"+labels+"} 1\n")
+	assert.Contains(t, output, `llm_gateway_failovers_total{virtual_key_fingerprint="`+models.Fingerprint("vk_user3_fallback")+`",from_provider="openai",to_provider="anthropic"} 1`)
+	assert.NotContains(t, output, "vk_user1_openai", "the virtual keys are bearer credentials")
+	assert.NotContains(t, output, "vk_user3_fallback", "the virtual keys are bearer credentials")
+}
+
+// TestQuotaService_WritePrometheus Test that quota usage and limits are exposed as gauges per virtual key
+func TestQuotaService_WritePrometheus(t *testing.T) {
+	quotaService := services.GetQuotaService()
+	quotaService.Reset()
+	quotaService.SetLimits(100, 10000, time.Hour)
+
+	quotaService.IncrementUsage(configs.Current(), "test-key", 250, 0.5)
+
+	var buf bytes.Buffer
+	quotaService.WritePrometheus(&buf)
+	output := buf.String()
+	labels := `virtual_key_fingerprint="` + models.Fingerprint("test-key") + `"`
+
+	assert.Contains(t, output, "# TYPE llm_gateway_quota_tokens_used gauge")
+	assert.Contains(t, output, "llm_gateway_quota_requests_used{"+labels+"} 1")
+	assert.Contains(t, output, "llm_gateway_quota_requests_limit{"+labels+"} 100")
+	assert.Contains(t, output, "llm_gateway_quota_tokens_used{"+labels+"} 250")
+	assert.Contains(t, output, "llm_gateway_quota_tokens_limit{"+labels+"} 10000")
+	assert.Contains(t, output, "llm_gateway_quota_spend_usd{"+labels+"} 0.5")
+	assert.NotContains(t, output, "llm_gateway_quota_spend_limit_usd{"+labels+"}")
+}
+
+// TestMetricsMiddleware_ModelTracking Test that the model stored in context is recorded with the request
+func TestMetricsMiddleware_ModelTracking(t *testing.T) {
+	metricsService := services.GetMetricsService()
+	metricsService.Reset()
+
+	router := setupMetricsTestRouter()
+	router.POST("/tests/chat/completions", func(c *gin.Context) {
+		c.Set("provider", "anthropic")
+		c.Set("model", "claude-3-5-sonnet-20240620")
+		c.Set("token_count", 10)
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	req := httptest.NewRequest("POST", "/tests/chat/completions", nil)
+	req.Header.Set("Authorization", "Bearer test-key")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	var buf bytes.Buffer
+	metricsService.WritePrometheus(&buf)
+	assert.Contains(t, buf.String(), `llm_gateway_requests_total{provider="anthropic",virtual_key_fingerprint="`+models.Fingerprint("test-key")+`",model="claude-3-5-sonnet-20240620",status="200"} 1`)
+}
@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/models"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// modelRoutingConfig declares global and per key model aliases, and a key restricted to the gpt-4o models
+const modelRoutingConfig = `{
+	"model_aliases": {
+		"cheap": {"provider": "openai", "model": "gpt-4o-mini"},
+		"fast": {"provider": "anthropic", "model": "claude-3-5-haiku-20241022"},
+		"big": {"provider": "openai", "model": "gpt-4-32k"}
+	},
+	"virtual_keys": {
+		"vk_aliases": {
+			"provider": "openai",
+			"api_key": "sk-key-of-the-virtual-key",
+			"model_aliases": {
+				"smart": {"provider": "openai", "model": "gpt-4o", "api_key": "sk-key-of-the-alias"},
+				"cheap": {"provider": "openai", "model": "gpt-4.1-nano"}
+			}
+		},
+		"vk_single_target": {
+			"targets": [{"provider": "openai", "api_key": "sk-key-of-the-target", "model": "gpt-4o-mini"}]
+		},
+		"vk_restricted": {
+			"provider": "openai",
+			"api_key": "sk-key-of-the-virtual-key",
+			"allowed_models": ["gpt-4o*"],
+			"denied_models": ["gpt-4o-realtime*"]
+		}
+	}
+}`
+
+// upstreamCall represents the model and the api key a request was sent upstream with
+type upstreamCall struct {
+	Model  string
+	ApiKey string
+}
+
+// newUpstreamServer starts a fake OpenAI-compatible upstream, which answers every request with the content
+// in a completion of the requested model
+func newUpstreamServer(t *testing.T, content string) *fakeUpstream {
+	return startFakeUpstream(t, func(request upstreamRequest, n int) upstreamResponse {
+		return upstreamResponse{Status: http.StatusOK, ContentType: "application/json", Body: `{
+			"id": "chatcmpl-1",
+			"model": "` + request.Model + `",
+			"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "` + content + `"}}],
+			"usage": {"prompt_tokens": 10, "completion_tokens": 2, "total_tokens": 12}
+		}`}
+	})
+}
+
+// setupKeysConfig loads the keys configuration, and returns a client of the virtual key
+func setupKeysConfig(t *testing.T, virtualKey string, config string) openai.Client {
+	client := newGatewayClient(t, virtualKey)
+	path := setupConfigCopy(t)
+	require.NoError(t, os.WriteFile(path, []byte(config), 0600))
+	require.NoError(t, configs.ReloadConfig())
+	return client
+}
+
+// setupUpstreamKey starts a fake OpenAI-compatible upstream, and returns a client of the virtual key whose
+// configuration is given with the upstream URL, and the requests the upstream received
+func setupUpstreamKey(t *testing.T, virtualKey string, content string, keyConfig func(url string) string) (openai.Client, *[]upstreamRequest) {
+	server := newUpstreamServer(t, content)
+	client := setupKeysConfig(t, virtualKey, `{"virtual_keys": {"`+virtualKey+`": `+keyConfig(server.URL)+`}}`)
+	return client, &server.Requests
+}
+
+// setupModelRouting loads the model routing configuration, and returns a client of the virtual key
+// and the requests received by a fake OpenAI server
+func setupModelRouting(t *testing.T, virtualKey string) (openai.Client, *[]upstreamRequest) {
+	server := newUpstreamServer(t, "Hello!")
+	server.SetEndpoint(t, "OPENAI_BASE_URL")
+	return setupKeysConfig(t, virtualKey, modelRoutingConfig), &server.Requests
+}
+
+// upstreamCalls returns the model and the bearer api key of every upstream request
+func upstreamCalls(requests []upstreamRequest) []upstreamCall {
+	calls := make([]upstreamCall, len(requests))
+	for i, request := range requests {
+		calls[i] = upstreamCall{Model: request.Model, ApiKey: strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")}
+	}
+	return calls
+}
+
+// complete sends a chat completion of the model
+func complete(client openai.Client, model string) (*openai.ChatCompletion, error) {
+	return client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:    model,
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hello")},
+	})
+}
+
+// TestModelRouting_Aliases verifies that the aliases resolve to their provider, model and api key,
+// the aliases of the virtual key taking precedence over the global ones
+func TestModelRouting_Aliases(t *testing.T) {
+	client, calls := setupModelRouting(t, "vk_aliases")
+
+	completion, err := complete(client, "smart")
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-4o", completion.Model)
+
+	_, err = complete(client, "Cheap")
+	require.NoError(t, err)
+	_, err = complete(client, "gpt-4.1")
+	require.NoError(t, err)
+
+	assert.Equal(t, []upstreamCall{
+		{Model: "gpt-4o", ApiKey: "sk-key-of-the-alias"},
+		{Model: "gpt-4.1-nano", ApiKey: "sk-key-of-the-virtual-key"},
+		{Model: "gpt-4.1", ApiKey: "sk-key-of-the-virtual-key"},
+	}, upstreamCalls(*calls))
+
+	_, err = complete(client, "fast")
+	var apiErr *openai.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Contains(t, apiErr.Message, "model fast routes to anthropic, which the virtual key has no api key for")
+}
+
+// TestModelRouting_AllowDeny verifies that the models which aren't allowed for the virtual key are rejected with a 403
+// before any provider is called, including through an alias
+func TestModelRouting_AllowDeny(t *testing.T) {
+	client, calls := setupModelRouting(t, "vk_restricted")
+
+	for _, model := range []string{"gpt-4-32k", "gpt-4o-realtime-preview", "big"} {
+		_, err := complete(client, model)
+		var apiErr *openai.Error
+		require.ErrorAs(t, err, &apiErr, model)
+		assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+		assert.Equal(t, "model_not_allowed", apiErr.Code)
+		assert.Equal(t, "permission_error", apiErr.Type)
+	}
+	assert.Empty(t, *calls)
+
+	_, err := complete(client, "gpt-4o-mini")
+	require.NoError(t, err)
+	assert.Len(t, *calls, 1)
+}
+
+// TestModelRouting_InvalidAliasRejected verifies that a config file with an alias which doesn't route to a model is rejected
+func TestModelRouting_InvalidAliasRejected(t *testing.T) {
+	path := setupConfigCopy(t)
+
+	for _, content := range []string{
+		`{"virtual_keys": {}, "model_aliases": {"fast": {"provider": "openai"}}}`,
+		`{"virtual_keys": {"vk_new": {"provider": "openai", "api_key": "sk-x", "model_aliases": {"fast": {"model": "gpt-4o-mini"}}}}}`,
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		assert.Error(t, configs.ReloadConfig(), content)
+	}
+}
+
+// TestMatchModel verifies the model patterns of the allow and deny lists
+func TestMatchModel(t *testing.T) {
+	assert.True(t, models.MatchModel("gpt-4o", "gpt-4o"))
+	assert.False(t, models.MatchModel("gpt-4o", "gpt-4o-mini"))
+	assert.True(t, models.MatchModel("gpt-4o*", "gpt-4o-mini"))
+	assert.True(t, models.MatchModel("*", "claude-3-opus"))
+	assert.True(t, models.MatchModel("claude-*-opus*", "claude-3-opus-20240229"))
+	assert.False(t, models.MatchModel("claude-*-opus*", "claude-3-5-sonnet"))
+	assert.False(t, models.IsModelAllowed("gpt-4o-mini", []string{"gpt-4o*"}, []string{"*-mini"}))
+	assert.True(t, models.IsModelAllowed("gpt-4o-mini", nil, nil))
+}
+
+// TestModelRouting_SingleTargetModel verifies that the model override of a key with a single target is applied,
+// although the request isn't routed by the Fallback provider
+func TestModelRouting_SingleTargetModel(t *testing.T) {
+	client, calls := setupModelRouting(t, "vk_single_target")
+
+	completion, err := complete(client, "gpt-4o")
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-4o-mini", completion.Model)
+	assert.Equal(t, []upstreamCall{{Model: "gpt-4o-mini", ApiKey: "sk-key-of-the-target"}}, upstreamCalls(*calls))
+}
@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"qualifire-home-assignment/internal/models"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testImageData    = "iVBORw0KGgo="
+	testDocumentData = "JVBERi0xLjQ="
+)
+
+// multimodalMessage returns a user message holding a text, a base64 image, an image URL and a PDF document
+func multimodalMessage() openai.ChatCompletionMessageParamUnion {
+	return openai.UserMessage([]openai.ChatCompletionContentPartUnionParam{
+		openai.TextContentPart("Compare the image with the report"),
+		openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: "data:image/png;base64," + testImageData, Detail: "low"}),
+		openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: "https://example.com/chart.png"}),
+		openai.FileContentPart(openai.ChatCompletionContentPartFileFileParam{
+			Filename: openai.String("report.pdf"),
+			FileData: openai.String("data:application/pdf;base64," + testDocumentData),
+		}),
+	})
+}
+
+// TestMessage_ContentParts verifies that a message content is either a string or a list of content parts
+func TestMessage_ContentParts(t *testing.T) {
+	var message models.Message
+	require.NoError(t, json.Unmarshal([]byte(`{"role": "user", "content": [
+		{"type": "text", "text": "Describe"},
+		{"type": "image_url", "image_url": {"url": "https://example.com/cat.png"}},
+		{"type": "text", "text": "briefly"}
+	]}`), &message))
+
+	assert.Equal(t, "Describe\nbriefly", message.Content)
+	require.Len(t, message.Parts, 3)
+	assert.Equal(t, "https://example.com/cat.png", message.Parts[1].ImageURL.URL)
+
+	b, err := json.Marshal(message)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"role": "user", "content": [
+		{"type": "text", "text": "Describe"},
+		{"type": "image_url", "image_url": {"url": "https://example.com/cat.png"}},
+		{"type": "text", "text": "briefly"}
+	]}`, string(b))
+
+	require.NoError(t, json.Unmarshal([]byte(`{"role": "user", "content": "Hello"}`), &message))
+	assert.Equal(t, "Hello", message.Content)
+	assert.Nil(t, message.Parts)
+}
+
+// TestMultimodal_OpenAI verifies that the content parts are forwarded to OpenAI as they are
+func TestMultimodal_OpenAI(t *testing.T) {
+	server := newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"id": "chatcmpl-1",
+		"model": "gpt-4o",
+		"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "They match."}}],
+		"usage": {"prompt_tokens": 900, "completion_tokens": 3, "total_tokens": 903}
+	}`)
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+	client := newGatewayClient(t, "vk_user1_openai")
+
+	completion, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:    "gpt-4o",
+		Messages: []openai.ChatCompletionMessageParamUnion{multimodalMessage()},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "They match.", completion.Choices[0].Message.Content)
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"type": "text", "text": "Compare the image with the report"},
+		map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "data:image/png;base64," + testImageData, "detail": "low"}},
+		map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "https://example.com/chart.png"}},
+		map[string]interface{}{"type": "file", "file": map[string]interface{}{"filename": "report.pdf", "file_data": "data:application/pdf;base64," + testDocumentData}},
+	}, server.Last().Body["messages"].([]interface{})[0].(map[string]interface{})["content"])
+}
+
+// TestMultimodal_Anthropic verifies that the content parts are translated to Anthropic image and document blocks
+func TestMultimodal_Anthropic(t *testing.T) {
+	server := newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"id": "msg_1",
+		"role": "assistant",
+		"content": [{"type": "text", "text": "They match."}],
+		"stop_reason": "end_turn",
+		"usage": {"input_tokens": 900, "output_tokens": 3}
+	}`)
+	os.Setenv("ANTHROPIC_ENDPOINT", server.URL)
+	defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+	client := newGatewayClient(t, "vk_user2_anthropic")
+
+	_, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:    "claude-3-5-sonnet-20240620",
+		Messages: []openai.ChatCompletionMessageParamUnion{multimodalMessage()},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []interface{}{map[string]interface{}{"role": "user", "content": []interface{}{
+		map[string]interface{}{"type": "text", "text": "Compare the image with the report"},
+		map[string]interface{}{"type": "image", "source": map[string]interface{}{"type": "base64", "media_type": "image/png", "data": testImageData}},
+		map[string]interface{}{"type": "image", "source": map[string]interface{}{"type": "url", "url": "https://example.com/chart.png"}},
+		map[string]interface{}{"type": "document", "source": map[string]interface{}{"type": "base64", "media_type": "application/pdf", "data": testDocumentData}},
+	}}}, server.Last().Body["messages"])
+}
+
+// TestMultimodal_AnthropicMessages verifies that the image and document blocks of the Anthropic messages endpoint
+// are translated to OpenAI content parts
+func TestMultimodal_AnthropicMessages(t *testing.T) {
+	server := newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"id": "chatcmpl-1",
+		"model": "gpt-4o",
+		"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "A cat."}}],
+		"usage": {"prompt_tokens": 900, "completion_tokens": 3, "total_tokens": 903}
+	}`)
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+
+	w := sendAnthropicMessage("vk_user1_openai", `{
+		"model": "gpt-4o",
+		"max_tokens": 64,
+		"messages": [{"role": "user", "content": [
+			{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "`+testImageData+`"}},
+			{"type": "document", "source": {"type": "base64", "media_type": "application/pdf", "data": "`+testDocumentData+`"}},
+			{"type": "text", "text": "What is it?"}
+		]}]
+	}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "data:image/png;base64," + testImageData}},
+		map[string]interface{}{"type": "file", "file": map[string]interface{}{"file_data": "data:application/pdf;base64," + testDocumentData}},
+		map[string]interface{}{"type": "text", "text": "What is it?"},
+	}, server.Last().Body["messages"].([]interface{})[0].(map[string]interface{})["content"])
+}
+
+// TestMultimodal_Validation verifies that unsupported and oversized parts are rejected
+func TestMultimodal_Validation(t *testing.T) {
+	os.Setenv("MAX_IMAGE_BYTES", "1024")
+	defer os.Unsetenv("MAX_IMAGE_BYTES")
+	client := newGatewayClient(t, "vk_user1_openai")
+
+	tests := []struct {
+		name    string
+		message openai.ChatCompletionMessageParamUnion
+		error   string
+	}{
+		{
+			name: "unsupported image type",
+			message: openai.UserMessage([]openai.ChatCompletionContentPartUnionParam{
+				openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: "data:image/bmp;base64," + testImageData}),
+			}),
+			error: "unsupported image type image/bmp",
+		},
+		{
+			name: "oversized image",
+			message: openai.UserMessage([]openai.ChatCompletionContentPartUnionParam{
+				openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: "data:image/png;base64," + strings.Repeat("A", 2000)}),
+			}),
+			error: "exceeds the limit of 1024 bytes",
+		},
+		{
+			name: "document which isn't a PDF",
+			message: openai.UserMessage([]openai.ChatCompletionContentPartUnionParam{
+				openai.FileContentPart(openai.ChatCompletionContentPartFileFileParam{FileData: openai.String("data:text/plain;base64,aGVsbG8=")}),
+			}),
+			error: "base64 data URL of a PDF document",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+				Model:    "gpt-4o",
+				Messages: []openai.ChatCompletionMessageParamUnion{tt.message},
+			})
+
+			var apiErr *openai.Error
+			require.ErrorAs(t, err, &apiErr)
+			assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+			assert.Contains(t, apiErr.Message, tt.error)
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package tests
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/routes"
+	"qualifire-home-assignment/internal/services"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/option"
+	"github.com/openai/openai-go/v2/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newGatewayClient starts the gateway and returns an official OpenAI client pointed at it
+func newGatewayClient(t *testing.T, virtualKey string) openai.Client {
+	os.Setenv("IS_TEST", "1")
+	gin.SetMode(gin.TestMode)
+	configs.LoadConfig()
+	services.GetMetricsService().Reset()
+	services.GetQuotaService().Reset()
+	services.GetQuotaService().SetLimits(100, 100000, time.Hour)
+	services.GetCircuitBreakerService().Reset()
+	services.GetKeyPoolService().Reset()
+
+	gateway := httptest.NewServer(routes.HandleRequests())
+	t.Cleanup(gateway.Close)
+
+	return openai.NewClient(
+		option.WithBaseURL(gateway.URL+"/v1/"),
+		option.WithAPIKey(virtualKey),
+		option.WithMaxRetries(0),
+	)
+}
+
+// TestOpenAIChatCompletions_OpenAI verifies that the official client works against the gateway, that the
+// generation parameters are forwarded and that every choice is returned
+func TestOpenAIChatCompletions_OpenAI(t *testing.T) {
+	server := newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"id": "chatcmpl-upstream",
+		"object": "chat.completion",
+		"model": "gpt-4o",
+		"choices": [
+			{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "{\"a\":1}"}},
+			{"index": 1, "finish_reason": "length", "message": {"role": "assistant", "content": "{\"a\":"}}
+		],
+		"usage": {"prompt_tokens": 20, "completion_tokens": 10, "total_tokens": 30}
+	}`)
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+	client := newGatewayClient(t, "vk_user1_openai")
+
+	completion, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:          "gpt-4o",
+		Messages:       []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hello")},
+		Temperature:    openai.Float(0.2),
+		TopP:           openai.Float(0.9),
+		MaxTokens:      openai.Int(50),
+		Stop:           openai.ChatCompletionNewParamsStopUnion{OfString: openai.String("END")},
+		N:              openai.Int(2),
+		Seed:           openai.Int(42),
+		User:           openai.String("user-1"),
+		ResponseFormat: openai.ChatCompletionNewParamsResponseFormatUnion{OfJSONObject: &shared.ResponseFormatJSONObjectParam{}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "chatcmpl-upstream", completion.ID)
+	assert.Equal(t, "chat.completion", string(completion.Object))
+	assert.Equal(t, "gpt-4o", completion.Model)
+	require.Len(t, completion.Choices, 2)
+	assert.Equal(t, `{"a":1}`, completion.Choices[0].Message.Content)
+	assert.Equal(t, "stop", completion.Choices[0].FinishReason)
+	assert.Equal(t, int64(1), completion.Choices[1].Index)
+	assert.Equal(t, "length", completion.Choices[1].FinishReason)
+	assert.Equal(t, int64(30), completion.Usage.TotalTokens)
+
+	assert.Equal(t, 0.2, server.Last().Body["temperature"])
+	assert.Equal(t, 0.9, server.Last().Body["top_p"])
+	assert.Equal(t, float64(50), server.Last().Body["max_tokens"])
+	assert.Equal(t, []interface{}{"END"}, server.Last().Body["stop"])
+	assert.Equal(t, float64(2), server.Last().Body["n"])
+	assert.Equal(t, float64(42), server.Last().Body["seed"])
+	assert.Equal(t, "user-1", server.Last().Body["user"])
+	assert.Equal(t, map[string]interface{}{"type": "json_object"}, server.Last().Body["response_format"])
+}
+
+// TestOpenAIChatCompletions_Anthropic verifies that an Anthropic response is returned in the OpenAI schema
+func TestOpenAIChatCompletions_Anthropic(t *testing.T) {
+	server := newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"id": "msg_123",
+		"role": "assistant",
+		"content": "Hello!",
+		"stop_reason": "max_tokens",
+		"usage": {"input_tokens": 12, "output_tokens": 5}
+	}`)
+	os.Setenv("ANTHROPIC_ENDPOINT", server.URL)
+	defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+	client := newGatewayClient(t, "vk_user2_anthropic")
+
+	completion, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:       "claude-3-5-sonnet-20240620",
+		Messages:    []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hello")},
+		Temperature: openai.Float(0.5),
+		MaxTokens:   openai.Int(5),
+		Stop:        openai.ChatCompletionNewParamsStopUnion{OfStringArray: []string{"END", "STOP"}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "msg_123", completion.ID)
+	assert.Equal(t, "claude-3-5-sonnet-20240620", completion.Model)
+	require.Len(t, completion.Choices, 1)
+	assert.Equal(t, "assistant", string(completion.Choices[0].Message.Role))
+	assert.Equal(t, "Hello!", completion.Choices[0].Message.Content)
+	assert.Equal(t, "length", completion.Choices[0].FinishReason)
+	assert.Equal(t, int64(12), completion.Usage.PromptTokens)
+	assert.Equal(t, int64(17), completion.Usage.TotalTokens)
+
+	assert.Equal(t, 0.5, server.Last().Body["temperature"])
+	assert.Equal(t, float64(5), server.Last().Body["max_tokens"])
+	assert.Equal(t, []interface{}{"END", "STOP"}, server.Last().Body["stop_sequences"])
+}
+
+// TestOpenAIChatCompletions_Stream verifies that the official client accumulates a stream served by Anthropic,
+// including the usage chunk
+func TestOpenAIChatCompletions_Stream(t *testing.T) {
+	server := newAnthropicStreamServer("Hi", " there")
+	defer server.Close()
+	os.Setenv("ANTHROPIC_ENDPOINT", server.URL)
+	defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+	client := newGatewayClient(t, "vk_user2_anthropic")
+
+	stream := client.Chat.Completions.NewStreaming(context.Background(), openai.ChatCompletionNewParams{
+		Model:         "claude-3-5-sonnet-20240620",
+		Messages:      []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hello")},
+		StreamOptions: openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)},
+	})
+	defer stream.Close()
+
+	acc := openai.ChatCompletionAccumulator{}
+	for stream.Next() {
+		chunk := stream.Current()
+		acc.AddChunk(chunk)
+		assert.Equal(t, "chat.completion.chunk", string(chunk.Object))
+	}
+	require.NoError(t, stream.Err())
+
+	require.Len(t, acc.Choices, 1)
+	assert.Equal(t, "Hi there", acc.Choices[0].Message.Content)
+	assert.Equal(t, "stop", acc.Choices[0].FinishReason)
+	assert.Equal(t, int64(25), acc.Usage.PromptTokens)
+	assert.Equal(t, int64(9), acc.Usage.CompletionTokens)
+}
+
+// TestOpenAIChatCompletions_ValidationError verifies that errors are returned in the OpenAI error format
+func TestOpenAIChatCompletions_ValidationError(t *testing.T) {
+	client := newGatewayClient(t, "vk_user1_openai")
+
+	_, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:       "gpt-4o",
+		Messages:    []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hello")},
+		Temperature: openai.Float(3),
+	})
+
+	var apiErr *openai.Error
+	require.True(t, stderrors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Equal(t, "invalid_request_error", apiErr.Type)
+	assert.Equal(t, "validation_error", apiErr.Code)
+}
+
+// TestOpenAIChatCompletions_AnthropicSeveralChoices verifies that n > 1 is rejected for Anthropic
+func TestOpenAIChatCompletions_AnthropicSeveralChoices(t *testing.T) {
+	client := newGatewayClient(t, "vk_user2_anthropic")
+
+	_, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:    "claude-3-5-sonnet-20240620",
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hello")},
+		N:        openai.Int(2),
+	})
+
+	var apiErr *openai.Error
+	require.True(t, stderrors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Equal(t, "llm_provider_error", apiErr.Code)
+}
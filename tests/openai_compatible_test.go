@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/services"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOpenAICompatible_BaseURLAndHeaders verifies that a key routed to an OpenAI-compatible server is sent to its
+// base URL with its custom headers, without authorization when it has no api key, and is accounted for in the metrics
+func TestOpenAICompatible_BaseURLAndHeaders(t *testing.T) {
+	os.Setenv("OPENAI_API_KEY", "sk-public-openai-key")
+	defer os.Unsetenv("OPENAI_API_KEY")
+	client, requests := setupUpstreamKey(t, "vk_local", "Hello from llama!", func(url string) string {
+		return `{"provider": "openai_compatible", "base_url": "` + url + `/v1", "headers": {"X-Tenant": "team-a"}}`
+	})
+
+	completion, err := complete(client, "llama3.1:8b")
+	require.NoError(t, err)
+	assert.Equal(t, "Hello from llama!", completion.Choices[0].Message.Content)
+	require.Len(t, *requests, 1)
+	assert.Equal(t, "/v1/chat/completions", (*requests)[0].Path)
+	assert.Empty(t, (*requests)[0].Header.Get("Authorization"))
+	assert.Equal(t, "team-a", (*requests)[0].Header.Get("X-Tenant"))
+
+	stats := services.GetMetricsService().GetStats()
+	assert.Equal(t, map[string]int{"openai_compatible": 1}, stats["requests_per_provider"])
+	assert.Equal(t, 10, stats["total_prompt_tokens"])
+}
+
+// TestOpenAICompatible_ApiKey verifies that the api key of an OpenAI-compatible server is sent as a bearer token
+func TestOpenAICompatible_ApiKey(t *testing.T) {
+	client, requests := setupUpstreamKey(t, "vk_vllm", "Hello from vLLM!", func(url string) string {
+		return `{"provider": "openai_compatible", "base_url": "` + url + `/v1", "api_key": "vllm-secret-token"}`
+	})
+
+	_, err := complete(client, "meta-llama/Llama-3.1-8B-Instruct")
+	require.NoError(t, err)
+	require.Len(t, *requests, 1)
+	assert.Equal(t, "Bearer vllm-secret-token", (*requests)[0].Header.Get("Authorization"))
+}
+
+// TestOpenAICompatible_Validation verifies that the openai_compatible provider requires an absolute http(s) base URL,
+// which the other providers don't accept, and that the custom headers are masked
+func TestOpenAICompatible_Validation(t *testing.T) {
+	router, _ := setupAdminKeys(t)
+
+	testCases := map[string]string{
+		"missing base_url":     `{"provider":"openai_compatible"}`,
+		"relative base_url":    `{"provider":"openai_compatible","base_url":"localhost:11434/v1"}`,
+		"base_url of openai":   `{"provider":"openai","api_key":"sk-x","base_url":"http://localhost:11434/v1"}`,
+		"target base_url":      `{"targets":[{"provider":"openai_compatible","base_url":"ftp://localhost/v1"}]}`,
+		"target without route": `{"targets":[{"provider":"openai_compatible"}]}`,
+	}
+	for name, body := range testCases {
+		w := adminRequest(router, "POST", "/admin/keys", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+	}
+
+	w := adminRequest(router, "POST", "/admin/keys", `{"provider":"openai_compatible","base_url":"http://localhost:8000/v1","headers":{"X-Api-Token":"local-secret-token"}}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "local-secret-token")
+
+	var created models.VirtualKey
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "http://localhost:8000/v1", created.BaseURL)
+	assert.Equal(t, "**************oken", created.Headers["X-Api-Token"])
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"os"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/services"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGetModelPrice_ExactMatch verifies that a model is priced by its exact name
+func TestGetModelPrice_ExactMatch(t *testing.T) {
+	price, ok := services.GetModelPrice(configs.Current(), "gpt-4o-mini")
+
+	assert.True(t, ok)
+	assert.Equal(t, 0.15, price.Input)
+	assert.Equal(t, 0.6, price.Output)
+}
+
+// TestGetModelPrice_PrefixMatch verifies that dated model versions use the price of
+// the longest model name they start with
+func TestGetModelPrice_PrefixMatch(t *testing.T) {
+	price, ok := services.GetModelPrice(configs.Current(), "gpt-4o-mini-2024-07-18")
+	assert.True(t, ok)
+	assert.Equal(t, 0.15, price.Input)
+
+	price, ok = services.GetModelPrice(configs.Current(), "claude-3-5-sonnet-20240620")
+	assert.True(t, ok)
+	assert.Equal(t, 3.0, price.Input)
+	assert.Equal(t, 15.0, price.Output)
+}
+
+// TestGetModelPrice_Unknown verifies that unknown models have no price
+func TestGetModelPrice_Unknown(t *testing.T) {
+	_, ok := services.GetModelPrice(configs.Current(), "unknown-model")
+	assert.False(t, ok)
+	assert.Equal(t, 0.0, services.CalculateCost(configs.Current(), "unknown-model", models.Usage{PromptTokens: 1000}))
+}
+
+// TestGetModelPrice_Configured verifies that the pricing section of the keys configuration adds models
+func TestGetModelPrice_Configured(t *testing.T) {
+	os.Setenv("IS_TEST", "1")
+	configs.LoadConfig()
+
+	price, ok := services.GetModelPrice(configs.Current(), "ft:gpt-4o-mini:acme")
+
+	assert.True(t, ok)
+	assert.Equal(t, 0.3, price.Input)
+	assert.Equal(t, 1.2, price.Output)
+	assert.Equal(t, 0.15, price.CachedInput)
+}
+
+// TestCalculateCost verifies that prompt, cached and completion tokens are priced separately
+func TestCalculateCost(t *testing.T) {
+	// gpt-4o: 1,000,000 uncached prompt tokens at $2.5, 1,000,000 cached at $1.25 and 500,000 completion at $10
+	cost := services.CalculateCost(configs.Current(), "gpt-4o", models.Usage{
+		PromptTokens:     2_000_000,
+		CachedTokens:     1_000_000,
+		CompletionTokens: 500_000,
+	})
+
+	assert.InDelta(t, 2.5+1.25+5, cost, 1e-9)
+}
+
+// TestCalculateCost_CachedInputDefaultsToInput verifies that cached tokens of a model without
+// a cached input price are priced as regular prompt tokens
+func TestCalculateCost_CachedInputDefaultsToInput(t *testing.T) {
+	cost := services.CalculateCost(configs.Current(), "gpt-4", models.Usage{PromptTokens: 1000, CachedTokens: 1000})
+
+	assert.InDelta(t, 0.03, cost, 1e-9)
+}
+
+// TestQuotaService_CheckQuota_SpendBudgetExceeded verifies that a key is rejected once its
+// spend reaches its budget, and that the spend survives the reset of the window
+func TestQuotaService_CheckQuota_SpendBudgetExceeded(t *testing.T) {
+	os.Setenv("IS_TEST", "1")
+	configs.LoadConfig()
+	service := services.GetQuotaService()
+	service.Reset()
+	service.SetLimits(100, 100000, time.Hour)
+
+	limits := service.GetLimits(configs.Current(), "vk_admin_openai")
+	assert.Equal(t, 50.0, limits.MaxSpendUSD)
+
+	service.IncrementUsage(configs.Current(), "vk_admin_openai", 10, 49.5)
+	allowed, _ := service.CheckQuota(configs.Current(), "vk_admin_openai")
+	assert.True(t, allowed)
+
+	service.IncrementUsage(configs.Current(), "vk_admin_openai", 10, 0.5)
+	allowed, reason := service.CheckQuota(configs.Current(), "vk_admin_openai")
+	assert.False(t, allowed)
+	assert.Equal(t, "spend budget exceeded", reason)
+	assert.Equal(t, 50.0, service.GetSpend("vk_admin_openai"))
+}
+
+// TestMetricsService_Spend verifies that the spend is reported per provider and per key, by the fingerprint of the key
+func TestMetricsService_Spend(t *testing.T) {
+	metricsService := services.GetMetricsService()
+	metricsService.Reset()
+
+	metricsService.RecordRequest(services.RequestMetric{Provider: "openai", VirtualKey: "vk_user1_openai", Model: "gpt-4o", Status: 200, CostUSD: 0.25})
+	metricsService.RecordRequest(services.RequestMetric{Provider: "anthropic", VirtualKey: "vk_user2_anthropic", Model: "claude-3-5-sonnet", Status: 200, CostUSD: 0.5})
+
+	stats := metricsService.GetStats()
+	assert.Equal(t, 0.75, stats["total_spend_usd"])
+	assert.Equal(t, 0.25, stats["spend_per_provider_usd"].(map[string]float64)["openai"])
+	assert.Equal(t, 0.5, stats["spend_per_key_usd"].(map[string]float64)[models.Fingerprint("vk_user2_anthropic")])
+}
@@ -1,6 +1,10 @@
 package tests
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"qualifire-home-assignment/internal/models"
 	"qualifire-home-assignment/internal/providers"
 	"testing"
@@ -105,12 +109,94 @@ func TestMessage_Structure(t *testing.T) {
 // are properly set and contain the expected message data
 func TestResponse_Structure(t *testing.T) {
 	resp := providers.Response{
-		Choices: []providers.Message{
-			{Role: "assistant", Content: "Hello!"},
+		Choices: []providers.Choice{
+			{Message: providers.Message{Role: "assistant", Content: "Hello!"}, FinishReason: "stop"},
 		},
 	}
 
 	assert.Len(t, resp.Choices, 1)
 	assert.Equal(t, "assistant", resp.Choices[0].Role)
 	assert.Equal(t, "Hello!", resp.Choices[0].Content)
+	assert.Equal(t, "stop", resp.Choices[0].FinishReason)
+}
+
+// newOpenAIServer starts a fake OpenAI server answering with the given chat completion body
+func newOpenAIServer(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+}
+
+// TestOpenAI_SendRequest_ProviderUsage verifies that the usage reported by OpenAI is used instead of the estimation
+func TestOpenAI_SendRequest_ProviderUsage(t *testing.T) {
+	server := newOpenAIServer(`{
+		"id": "chatcmpl-123",
+		"model": "gpt-4o",
+		"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "Hello!"}}],
+		"usage": {"prompt_tokens": 120, "completion_tokens": 30, "total_tokens": 150, "prompt_tokens_details": {"cached_tokens": 100}}
+	}`)
+	defer server.Close()
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+
+	result := providers.Factory(models.ProxyRequest{
+		Provider: "openai",
+		ApiKey:   "test-key",
+		Messages: []models.Message{{Role: "user", Content: "Hello"}},
+		Model:    "gpt-4o",
+	}).SendRequest(context.Background())
+
+	assert.Equal(t, models.Usage{PromptTokens: 120, CompletionTokens: 30, CachedTokens: 100}, result.Usage)
+	assert.Equal(t, 150, result.TokensUsed)
+}
+
+// TestOpenAI_SendRequest_EstimatedUsage verifies that the tokens are estimated when OpenAI doesn't report usage
+func TestOpenAI_SendRequest_EstimatedUsage(t *testing.T) {
+	server := newOpenAIServer(`{
+		"id": "chatcmpl-123",
+		"model": "gpt-4o",
+		"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "Hello!"}}]
+	}`)
+	defer server.Close()
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+
+	result := providers.Factory(models.ProxyRequest{
+		Provider: "openai",
+		ApiKey:   "test-key",
+		Messages: []models.Message{{Role: "user", Content: "Hello"}},
+		Model:    "gpt-4o",
+	}).SendRequest(context.Background())
+
+	assert.True(t, result.Usage.Estimated)
+	assert.Equal(t, 8, result.Usage.PromptTokens)
+	assert.Equal(t, 2, result.Usage.CompletionTokens)
+	assert.Equal(t, 10, result.TokensUsed)
+}
+
+// TestAnthropic_SendRequest_ProviderUsage verifies that the Anthropic usage is mapped,
+// where the prompt tokens include the cached ones
+func TestAnthropic_SendRequest_ProviderUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"role": "assistant",
+			"content": "Hello!",
+			"usage": {"input_tokens": 20, "output_tokens": 15, "cache_creation_input_tokens": 5, "cache_read_input_tokens": 75}
+		}`))
+	}))
+	defer server.Close()
+	os.Setenv("ANTHROPIC_ENDPOINT", server.URL)
+	defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+
+	result := providers.Factory(models.ProxyRequest{
+		Provider: "anthropic",
+		ApiKey:   "test-key",
+		Messages: []models.Message{{Role: "user", Content: "Hello"}},
+		Model:    "claude-3-5-sonnet-20240620",
+	}).SendRequest(context.Background())
+
+	assert.Equal(t, models.Usage{PromptTokens: 100, CompletionTokens: 15, CachedTokens: 75}, result.Usage)
+	assert.Equal(t, 115, result.TokensUsed)
 }
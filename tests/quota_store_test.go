@@ -0,0 +1,175 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/services"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNewQuotaStore_Memory verifies that the in-memory store is used by default
+func TestNewQuotaStore_Memory(t *testing.T) {
+	os.Unsetenv("QUOTA_STORE")
+
+	assert.IsType(t, &services.MemoryQuotaStore{}, services.NewQuotaStore())
+}
+
+// TestNewQuotaStore_File verifies that the file store is used when configured
+func TestNewQuotaStore_File(t *testing.T) {
+	os.Setenv("QUOTA_STORE", "file")
+	defer os.Unsetenv("QUOTA_STORE")
+
+	assert.IsType(t, &services.FileQuotaStore{}, services.NewQuotaStore())
+}
+
+// TestMemoryQuotaStore_Increment verifies that the in-memory store accumulates the usage in the current window
+func TestMemoryQuotaStore_Increment(t *testing.T) {
+	store := services.NewMemoryQuotaStore()
+
+	store.Increment("key-1", 1, 10, 0, time.Hour)
+	usage, err := store.Increment("key-1", 1, 15, 0, time.Hour)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, usage.RequestCount)
+	assert.Equal(t, 25, usage.TokenUsage)
+}
+
+// TestFileQuotaStore_SurvivesRestart verifies that the usage written by one store instance
+// is seen by a new instance on the same file
+func TestFileQuotaStore_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+
+	services.NewFileQuotaStore(path).Increment("key-1", 1, 40, 0, time.Hour)
+	usage, err := services.NewFileQuotaStore(path).Get("key-1", time.Hour)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, usage.RequestCount)
+	assert.Equal(t, 40, usage.TokenUsage)
+}
+
+// TestFileQuotaStore_WindowReset verifies that the stored usage is reset after its window expired
+func TestFileQuotaStore_WindowReset(t *testing.T) {
+	store := services.NewFileQuotaStore(filepath.Join(t.TempDir(), "quota.json"))
+
+	store.Increment("key-1", 1, 40, 0, 50*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	usage, _ := store.Get("key-1", 50*time.Millisecond)
+
+	assert.Equal(t, 0, usage.RequestCount)
+	assert.Equal(t, 0, usage.TokenUsage)
+}
+
+// TestFileQuotaStore_SharedConcurrentAccess verifies that concurrent updates from separate store instances,
+// as used by separate gateway instances, are applied atomically
+func TestFileQuotaStore_SharedConcurrentAccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	stores := []*services.FileQuotaStore{services.NewFileQuotaStore(path), services.NewFileQuotaStore(path)}
+
+	var wg sync.WaitGroup
+	concurrency := 50
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(store *services.FileQuotaStore) {
+			defer wg.Done()
+			store.Increment("key-concurrent", 1, 10, 0, time.Hour)
+		}(stores[i%2])
+	}
+	wg.Wait()
+
+	usage, err := stores[0].Get("key-concurrent", time.Hour)
+
+	assert.NoError(t, err)
+	assert.Equal(t, concurrency, usage.RequestCount)
+	assert.Equal(t, concurrency*10, usage.TokenUsage)
+}
+
+// TestQuotaStore_ReserveConcurrent verifies that concurrent reservations, from one store or from separate store
+// instances sharing a file as separate gateway instances do, admit exactly the maximum number of requests
+func TestQuotaStore_ReserveConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	memory := services.NewMemoryQuotaStore()
+	stores := map[string][]services.QuotaStore{
+		"memory": {memory, memory},
+		"file":   {services.NewFileQuotaStore(path), services.NewFileQuotaStore(path)},
+	}
+	limits := services.QuotaLimits{MaxRequests: 5, MaxTokens: 100000, WindowPeriod: time.Hour}
+
+	for name, instances := range stores {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		admitted := 0
+		for i := 0; i < 40; i++ {
+			wg.Add(1)
+			go func(store services.QuotaStore) {
+				defer wg.Done()
+				reason, err := store.Reserve("key-concurrent", limits)
+				assert.NoError(t, err, name)
+				if reason == "" {
+					mu.Lock()
+					admitted++
+					mu.Unlock()
+				}
+			}(instances[i%2])
+		}
+		wg.Wait()
+
+		assert.Equal(t, limits.MaxRequests, admitted, name)
+		usage, _ := instances[0].Get("key-concurrent", time.Hour)
+		assert.Equal(t, limits.MaxRequests, usage.RequestCount, name)
+		reason, _ := instances[1].Reserve("key-concurrent", limits)
+		assert.Equal(t, "request quota exceeded", reason, name)
+	}
+}
+
+// TestFileQuotaStore_KeysAndReset verifies that the tracked keys are listed and cleared by a reset
+func TestFileQuotaStore_KeysAndReset(t *testing.T) {
+	store := services.NewFileQuotaStore(filepath.Join(t.TempDir(), "quota.json"))
+
+	store.Increment("key-2", 1, 10, 0, time.Hour)
+	store.Increment("key-1", 1, 10, 0, time.Hour)
+	keys, _ := store.Keys()
+	assert.Equal(t, []string{"key-1", "key-2"}, keys)
+
+	store.Reset()
+	keys, _ = store.Keys()
+	assert.Empty(t, keys)
+}
+
+// TestQuotaStore_Move verifies that both stores transfer the usage and spend of a key to another key
+func TestQuotaStore_Move(t *testing.T) {
+	stores := map[string]services.QuotaStore{
+		"memory": services.NewMemoryQuotaStore(),
+		"file":   services.NewFileQuotaStore(filepath.Join(t.TempDir(), "quota.json")),
+	}
+	for name, store := range stores {
+		store.Increment("key-old", 1, 40, 0.5, time.Hour)
+		assert.NoError(t, store.Move("key-old", "key-new"), name)
+
+		usage, _ := store.Get("key-new", time.Hour)
+		assert.Equal(t, 1, usage.RequestCount, name)
+		assert.Equal(t, 40, usage.TokenUsage, name)
+		assert.Equal(t, 0.5, usage.SpendUSD, name)
+		keys, _ := store.Keys()
+		assert.Equal(t, []string{"key-new"}, keys, name)
+	}
+}
+
+// TestQuotaService_FileStore verifies that the quota service enforces limits through the file store
+func TestQuotaService_FileStore(t *testing.T) {
+	service := services.GetQuotaService()
+	service.SetStore(services.NewFileQuotaStore(filepath.Join(t.TempDir(), "quota.json")))
+	defer service.SetStore(services.NewMemoryQuotaStore())
+	service.SetLimits(2, 100000, time.Hour)
+
+	service.IncrementRequest("test-key-1", 10)
+	service.IncrementRequest("test-key-1", 10)
+	allowed, reason := service.CheckQuota(configs.Current(), "test-key-1")
+
+	assert.False(t, allowed)
+	assert.Equal(t, "request quota exceeded", reason)
+}
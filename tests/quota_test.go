@@ -1,6 +1,8 @@
 package tests
 
 import (
+	"os"
+	"qualifire-home-assignment/internal/configs"
 	"qualifire-home-assignment/internal/services"
 	"sync"
 	"testing"
@@ -25,7 +27,7 @@ func TestQuotaService_CheckQuota_Success(t *testing.T) {
 	service.Reset()
 	service.SetLimits(100, 100000, time.Hour)
 
-	allowed, reason := service.CheckQuota("test-key-1")
+	allowed, reason := service.CheckQuota(configs.Current(), "test-key-1")
 
 	assert.True(t, allowed)
 	assert.Empty(t, reason)
@@ -43,7 +45,7 @@ func TestQuotaService_CheckQuota_RequestLimitExceeded(t *testing.T) {
 		service.IncrementRequest("test-key-1", 10)
 	}
 
-	allowed, reason := service.CheckQuota("test-key-1")
+	allowed, reason := service.CheckQuota(configs.Current(), "test-key-1")
 
 	assert.False(t, allowed)
 	assert.Equal(t, "request quota exceeded", reason)
@@ -59,7 +61,7 @@ func TestQuotaService_CheckQuota_TokenLimitExceeded(t *testing.T) {
 	// Increment tokens to limit
 	service.IncrementRequest("test-key-1", 1001)
 
-	allowed, reason := service.CheckQuota("test-key-1")
+	allowed, reason := service.CheckQuota(configs.Current(), "test-key-1")
 
 	assert.False(t, allowed)
 	assert.Equal(t, "token quota exceeded", reason)
@@ -172,3 +174,53 @@ func TestQuotaService_Reset(t *testing.T) {
 	assert.Equal(t, 0, requests)
 	assert.Equal(t, 0, tokens)
 }
+
+// TestQuotaService_GetLimits_KeyOverrides verifies that limits declared for a virtual key
+// in the keys configuration override the global limits
+func TestQuotaService_GetLimits_KeyOverrides(t *testing.T) {
+	os.Setenv("IS_TEST", "1")
+	configs.LoadConfig()
+	service := services.GetQuotaService()
+	service.SetLimits(100, 100000, time.Hour)
+
+	limits := service.GetLimits(configs.Current(), "vk_admin_openai")
+
+	assert.Equal(t, 10000, limits.MaxRequests)
+	assert.Equal(t, 10000000, limits.MaxTokens)
+	assert.Equal(t, 24*time.Hour, limits.WindowPeriod)
+}
+
+// TestQuotaService_GetLimits_GlobalDefaults verifies that keys without declared limits
+// use the global limits
+func TestQuotaService_GetLimits_GlobalDefaults(t *testing.T) {
+	os.Setenv("IS_TEST", "1")
+	configs.LoadConfig()
+	service := services.GetQuotaService()
+	service.SetLimits(5, 1000, time.Minute)
+
+	limits := service.GetLimits(configs.Current(), "vk_user1_openai")
+
+	assert.Equal(t, 5, limits.MaxRequests)
+	assert.Equal(t, 1000, limits.MaxTokens)
+	assert.Equal(t, time.Minute, limits.WindowPeriod)
+}
+
+// TestQuotaService_CheckQuota_KeyLimits verifies that a key with its own limits isn't
+// rejected by the global limits
+func TestQuotaService_CheckQuota_KeyLimits(t *testing.T) {
+	os.Setenv("IS_TEST", "1")
+	configs.LoadConfig()
+	service := services.GetQuotaService()
+	service.Reset()
+	service.SetLimits(1, 100000, time.Hour)
+
+	service.IncrementRequest("vk_admin_openai", 10)
+	service.IncrementRequest("vk_user1_openai", 10)
+
+	allowedAdmin, _ := service.CheckQuota(configs.Current(), "vk_admin_openai")
+	allowedUser, reason := service.CheckQuota(configs.Current(), "vk_user1_openai")
+
+	assert.True(t, allowedAdmin)
+	assert.False(t, allowedUser)
+	assert.Equal(t, "request quota exceeded", reason)
+}
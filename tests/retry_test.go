@@ -0,0 +1,244 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/transports"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/openai/openai-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingAttempt is a failed answer of the fake provider
+type failingAttempt struct {
+	status  int
+	headers map[string]string
+}
+
+// newFlakyServer starts a fake provider which fails the first attempts and then answers with the given body.
+// It records the body of every attempt
+func newFlakyServer(t *testing.T, body string, failures ...failingAttempt) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var attempts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		attempts = append(attempts, string(b))
+		attempt := len(attempts)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if attempt <= len(failures) {
+			for name, value := range failures[attempt-1].headers {
+				w.Header().Set(name, value)
+			}
+			w.WriteHeader(failures[attempt-1].status)
+			w.Write([]byte(`{"type": "error", "error": {"type": "overloaded_error", "message": "Overloaded"}}`))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &attempts
+}
+
+// setRetryPolicy configures the retry policy for the test
+func setRetryPolicy(t *testing.T, maxRetries string, budget string) {
+	for name, value := range map[string]string{
+		"PROVIDER_MAX_RETRIES":         maxRetries,
+		"PROVIDER_RETRY_BASE_DELAY_MS": "1",
+		"PROVIDER_RETRY_BUDGET":        budget,
+	} {
+		os.Setenv(name, value)
+		t.Cleanup(func() { os.Unsetenv(name) })
+	}
+}
+
+// TestRetry_AnthropicOverloaded verifies that the hand-written Anthropic client retries an overloaded provider
+// and that every attempt sends the same body
+func TestRetry_AnthropicOverloaded(t *testing.T) {
+	setRetryPolicy(t, "2", "10")
+	server, attempts := newFlakyServer(t, `{
+		"id": "msg_1",
+		"role": "assistant",
+		"content": [{"type": "text", "text": "Hello!"}],
+		"stop_reason": "end_turn",
+		"usage": {"input_tokens": 10, "output_tokens": 2}
+	}`, failingAttempt{status: 529}, failingAttempt{status: http.StatusTooManyRequests, headers: map[string]string{"Retry-After": "0"}})
+	os.Setenv("ANTHROPIC_ENDPOINT", server.URL)
+	defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+	client := newGatewayClient(t, "vk_user2_anthropic")
+
+	completion, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:    "claude-3-5-sonnet-20240620",
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hello")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello!", completion.Choices[0].Message.Content)
+	require.Len(t, *attempts, 3)
+	assert.Equal(t, (*attempts)[0], (*attempts)[2])
+}
+
+// TestRetry_OpenAIRetryAfterMS verifies that the OpenAI client is retried by the shared transport
+func TestRetry_OpenAIRetryAfterMS(t *testing.T) {
+	setRetryPolicy(t, "1", "10")
+	server, attempts := newFlakyServer(t, cachedCompletion, failingAttempt{status: http.StatusServiceUnavailable, headers: map[string]string{"retry-after-ms": "5"}})
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+	client := newGatewayClient(t, "vk_user1_openai")
+
+	completion, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:    "gpt-4o",
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("What is the capital of France?")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Paris.", completion.Choices[0].Message.Content)
+	assert.Len(t, *attempts, 2)
+}
+
+// TestRetry_NotRetried verifies that the statuses outside the retry-on list, and the exhausted attempts,
+// return the provider error
+func TestRetry_NotRetried(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures []failingAttempt
+		status   int
+		attempts int
+	}{
+		{name: "bad request", failures: []failingAttempt{{status: http.StatusBadRequest}}, status: http.StatusBadRequest, attempts: 1},
+		{name: "exhausted attempts", failures: []failingAttempt{{status: 529}, {status: 529}, {status: 529}}, status: 529, attempts: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRetryPolicy(t, "1", "10")
+			server, attempts := newFlakyServer(t, "{}", tt.failures...)
+			os.Setenv("ANTHROPIC_ENDPOINT", server.URL)
+			defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+			client := newGatewayClient(t, "vk_user2_anthropic")
+
+			_, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+				Model:    "claude-3-5-sonnet-20240620",
+				Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hello")},
+			})
+			var apiErr *openai.Error
+			require.ErrorAs(t, err, &apiErr)
+			assert.Equal(t, tt.status, apiErr.StatusCode)
+			assert.Len(t, *attempts, tt.attempts)
+		})
+	}
+}
+
+// TestRetry_Budget verifies that a Retry-After beyond the time budget isn't waited for
+func TestRetry_Budget(t *testing.T) {
+	setRetryPolicy(t, "3", "1")
+	server, attempts := newFlakyServer(t, "{}", failingAttempt{status: http.StatusTooManyRequests, headers: map[string]string{"Retry-After": "30"}})
+	os.Setenv("ANTHROPIC_ENDPOINT", server.URL)
+	defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+	client := newGatewayClient(t, "vk_user2_anthropic")
+
+	start := time.Now()
+	_, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:    "claude-3-5-sonnet-20240620",
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hello")},
+	})
+	var apiErr *openai.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+	assert.Len(t, *attempts, 1)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+// TestRetryTransport_TransportError verifies that the transport errors are retried with a backoff
+func TestRetryTransport_TransportError(t *testing.T) {
+	calls := 0
+	transport := &transports.RetryTransport{
+		Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+			assert.Equal(t, calls, transports.AttemptFromContext(req.Context()))
+			if calls < 3 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+		}),
+		Policy: transports.RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: time.Second, Budget: time.Second},
+		Req:    models.ProxyRequest{Provider: "openai"},
+	}
+
+	start := time.Now()
+	req, _ := http.NewRequest(http.MethodPost, "http://provider.test", strings.NewReader("{}"))
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 3, calls)
+	// The jittered delays are at least half of 10ms and 20ms
+	assert.GreaterOrEqual(t, time.Since(start), 15*time.Millisecond)
+}
+
+// TestRetryAfter verifies the parsing of the retry-after-ms and Retry-After headers
+func TestRetryAfter(t *testing.T) {
+	delay, ok := transports.RetryAfter(http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"3"}})
+	assert.True(t, ok)
+	assert.Equal(t, 250*time.Millisecond, delay)
+
+	delay, ok = transports.RetryAfter(http.Header{"Retry-After": {"3"}})
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, delay)
+
+	delay, ok = transports.RetryAfter(http.Header{"Retry-After": {time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)}})
+	assert.True(t, ok)
+	assert.InDelta(t, time.Minute.Seconds(), delay.Seconds(), 2)
+
+	_, ok = transports.RetryAfter(http.Header{})
+	assert.False(t, ok)
+}
+
+// roundTripFunc adapts a function to an http.RoundTripper
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// TestLoggingTransport_LogsEveryAttempt verifies that the response of every attempt is logged with its body,
+// including the failed attempts which the retry transport discards
+func TestLoggingTransport_LogsEveryAttempt(t *testing.T) {
+	var output bytes.Buffer
+	previous := color.Output
+	color.Output = &output
+	defer func() { color.Output = previous }()
+
+	calls := 0
+	transport := &transports.RetryTransport{
+		Base: &transports.LoggingTransport{Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+			if calls == 1 {
+				return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(strings.NewReader(`{"error": "overloaded"}`))}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"id": "chatcmpl-1"}`))}, nil
+		})},
+		Policy: transports.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Budget: time.Second, RetryOn: []int{http.StatusServiceUnavailable}},
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "http://provider.test", strings.NewReader("{}"))
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	_, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	assert.Equal(t, 2, calls)
+	assert.Contains(t, output.String(), `"error": "overloaded"`)
+	assert.Contains(t, output.String(), `"id": "chatcmpl-1"`)
+	assert.Equal(t, 1, strings.Count(output.String(), `"id": "chatcmpl-1"`), "a response is logged once")
+}
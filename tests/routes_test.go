@@ -43,6 +43,24 @@ func TestHandleRequests_MetricsEndpoint(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "average_response_time_ms")
 }
 
+// TestHandleRequests_MetricsEndpoint_Prometheus verifies that Prometheus scrapers get the metrics
+// in the text exposition format
+func TestHandleRequests_MetricsEndpoint_Prometheus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := routes.HandleRequests()
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, w.Body.String(), "# TYPE llm_gateway_requests_total counter")
+	assert.Contains(t, w.Body.String(), "# TYPE llm_gateway_quota_tokens_used gauge")
+}
+
 // TestHandleRequests_ChatCompletionsEndpoint verifies that the chat completions
 // endpoint exists and responds to POST requests, ensuring the endpoint is
 // accessible even with invalid input
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/routes"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/services"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixedEmbedder embeds the known texts to fixed vectors, and any other text to a vector orthogonal to them
+type fixedEmbedder map[string][]float64
+
+// Embed returns the vector of the text
+func (e fixedEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	if vector, ok := e[text]; ok {
+		return vector, nil
+	}
+	return []float64{0, 0, 1}, nil
+}
+
+// setupSemanticCache starts a fake OpenAI provider and loads keys which enable the semantic cache
+func setupSemanticCache(t *testing.T) *[]upstreamRequest {
+	server := newFakeUpstream(t, http.StatusOK, "application/json", cachedCompletion)
+	server.SetEndpoint(t, "OPENAI_BASE_URL")
+
+	path := setupConfigCopy(t)
+	require.NoError(t, os.WriteFile(path, []byte(`{"virtual_keys": {
+		"vk_support": {"provider": "openai", "api_key": "sk-x", "semantic_cache": true},
+		"vk_strict": {"provider": "openai", "api_key": "sk-x", "semantic_cache": true, "semantic_cache_threshold": 0.99},
+		"vk_other": {"provider": "openai", "api_key": "sk-x", "semantic_cache": true}
+	}}`), 0600))
+	require.NoError(t, configs.ReloadConfig())
+
+	services.GetMetricsService().Reset()
+	services.GetCacheService().Reset()
+	services.GetSemanticCacheService().Reset()
+	return &server.Requests
+}
+
+// askSupport sends a deterministic question to the OpenAI-compatible endpoint and returns its cache status and similarity
+func askSupport(t *testing.T, virtualKey string, model string, question string) (string, string) {
+	return askWithSystemPrompt(t, virtualKey, model, "You are a support bot", question)
+}
+
+// askWithSystemPrompt sends a deterministic question after the system prompt and returns its cache status and similarity
+func askWithSystemPrompt(t *testing.T, virtualKey string, model string, system string, question string) (string, string) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":       model,
+		"messages":    []map[string]string{{"role": "system", "content": system}, {"role": "user", "content": question}},
+		"temperature": 0,
+	})
+	services.GetQuotaService().Reset()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+virtualKey)
+	w := httptest.NewRecorder()
+	routes.HandleRequests().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	return w.Header().Get("X-Cache"), w.Header().Get("X-Cache-Similarity")
+}
+
+// TestLocalEmbedder verifies that the local embedder is deterministic and that rephrased questions are more similar
+// than unrelated ones
+func TestLocalEmbedder(t *testing.T) {
+	embedder := services.LocalEmbedder{Dimensions: 512}
+	embed := func(text string) []float64 {
+		vector, err := embedder.Embed(context.Background(), text)
+		require.NoError(t, err)
+		return vector
+	}
+
+	question := embed("What is the capital of France?")
+	assert.Equal(t, question, embed("What is the capital of France?"))
+	assert.InDelta(t, 1, services.CosineSimilarity(question, question), 1e-9)
+	assert.Greater(t, services.CosineSimilarity(question, embed("What is the capital city of France?")), 0.9)
+	assert.Less(t, services.CosineSimilarity(question, embed("What is the capital of Spain?")), 0.9)
+	assert.Less(t, services.CosineSimilarity(question, embed("How do I reset my password?")), 0.1)
+}
+
+// TestSemanticCache_RephrasedQuestion verifies that a rephrased question is served the answer of the original one,
+// while a different question of the same shape isn't
+func TestSemanticCache_RephrasedQuestion(t *testing.T) {
+	captured := setupSemanticCache(t)
+
+	status, _ := askSupport(t, "vk_support", "gpt-4o", "What is the capital of France?")
+	assert.Equal(t, models.CACHE_MISS, status)
+
+	status, similarity := askSupport(t, "vk_support", "gpt-4o", "What is the capital city of France?")
+	assert.Equal(t, models.CACHE_SEMANTIC_HIT, status)
+	assert.NotEmpty(t, similarity)
+
+	status, _ = askSupport(t, "vk_support", "gpt-4o", "What is the capital of Spain?")
+	assert.Equal(t, models.CACHE_MISS, status)
+
+	// The exact question is still served by the exact cache
+	status, _ = askSupport(t, "vk_support", "gpt-4o", "What is the capital of France?")
+	assert.Equal(t, models.CACHE_HIT, status)
+
+	assert.Len(t, *captured, 2)
+	stats := services.GetMetricsService().GetStats()
+	assert.Equal(t, 1, stats["semantic_cache_hits"])
+	assert.Equal(t, 1, stats["cache_hits"])
+	assert.Equal(t, 2, stats["cache_misses"])
+}
+
+// TestSemanticCache_Scope verifies that the responses are only shared within the same virtual key and model,
+// and that a key may raise the similarity threshold
+func TestSemanticCache_Scope(t *testing.T) {
+	captured := setupSemanticCache(t)
+
+	askSupport(t, "vk_support", "gpt-4o", "What is the capital of France?")
+	status, _ := askSupport(t, "vk_other", "gpt-4o", "What is the capital city of France?")
+	assert.Equal(t, models.CACHE_MISS, status, "another key")
+	status, _ = askSupport(t, "vk_support", "gpt-4o-mini", "What is the capital city of France?")
+	assert.Equal(t, models.CACHE_MISS, status, "another model")
+
+	askSupport(t, "vk_strict", "gpt-4o", "What is the capital of Italy?")
+	status, _ = askSupport(t, "vk_strict", "gpt-4o", "What is the capital city of Italy?")
+	assert.Equal(t, models.CACHE_MISS, status, "a similarity below the threshold of the key")
+
+	assert.Len(t, *captured, 5)
+}
+
+// TestSemanticCache_Conversation verifies that the responses are only shared between the requests whose system prompt
+// and earlier turns are the same, only the last user message being compared by similarity
+func TestSemanticCache_Conversation(t *testing.T) {
+	captured := setupSemanticCache(t)
+
+	askSupport(t, "vk_support", "gpt-4o", "What is the capital of France?")
+	status, _ := askWithSystemPrompt(t, "vk_support", "gpt-4o", "Answer like a pirate", "What is the capital city of France?")
+	assert.Equal(t, models.CACHE_MISS, status, "another system prompt")
+	status, _ = askSupport(t, "vk_support", "gpt-4o", "What is the capital city of France?")
+	assert.Equal(t, models.CACHE_SEMANTIC_HIT, status)
+
+	assert.Len(t, *captured, 2)
+}
+
+// TestSemanticCache_PluggableEmbedder verifies that the semantic cache searches with the embedder it is given
+func TestSemanticCache_PluggableEmbedder(t *testing.T) {
+	captured := setupSemanticCache(t)
+	cache := services.GetSemanticCacheService()
+	cache.SetEmbedder(fixedEmbedder{
+		"How do I reset my password?": {1, 0, 0},
+		"I forgot my password, help!": {0.96, 0.28, 0},
+	})
+	defer cache.SetEmbedder(services.NewEmbedder())
+
+	askSupport(t, "vk_support", "gpt-4o", "How do I reset my password?")
+	status, similarity := askSupport(t, "vk_support", "gpt-4o", "I forgot my password, help!")
+	assert.Equal(t, models.CACHE_SEMANTIC_HIT, status)
+	assert.Equal(t, "0.9600", similarity)
+	assert.Len(t, *captured, 1)
+}
@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"qualifire-home-assignment/internal/configs"
+	"qualifire-home-assignment/internal/http/routes"
+	"qualifire-home-assignment/internal/models"
+	"qualifire-home-assignment/internal/providers"
+	"qualifire-home-assignment/internal/services"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// newOpenAIStreamServer starts a fake OpenAI server streaming the given content deltas
+func newOpenAIStreamServer(deltas ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, delta := range deltas {
+			fmt.Fprintf(w, "data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", delta)
+		}
+		fmt.Fprint(w, "data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4o\",\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":4,\"total_tokens\":16}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}
+
+// newAnthropicStreamServer starts a fake Anthropic server streaming the given text deltas
+func newAnthropicStreamServer(deltas ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"model\":\"claude-3-5-sonnet-20240620\",\"usage\":{\"input_tokens\":25,\"output_tokens\":1}}}\n\n")
+		for _, delta := range deltas {
+			fmt.Fprintf(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":%q}}\n\n", delta)
+		}
+		fmt.Fprint(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":9}}\n\n")
+		fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	}))
+}
+
+// TestOpenAI_StreamRequest verifies that OpenAI chunks are normalized and aggregated into the final response
+func TestOpenAI_StreamRequest(t *testing.T) {
+	server := newOpenAIStreamServer("Hello", ", world")
+	defer server.Close()
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+
+	provider := providers.Factory(models.ProxyRequest{
+		Provider: "openai",
+		ApiKey:   "test-key",
+		Messages: []models.Message{{Role: "user", Content: "Hello"}},
+		Model:    "gpt-4o",
+	})
+
+	var chunks []providers.StreamChunk
+	result := provider.StreamRequest(context.Background(), func(chunk providers.StreamChunk) {
+		chunks = append(chunks, chunk)
+	})
+
+	assert.Len(t, chunks, 3)
+	assert.Equal(t, "openai", chunks[0].Provider)
+	assert.Equal(t, "Hello", chunks[0].Delta.Content)
+	assert.Equal(t, "stop", chunks[2].FinishReason)
+	assert.Equal(t, "Hello, world", result.Choices[0].Content)
+	assert.Equal(t, models.Usage{PromptTokens: 12, CompletionTokens: 4}, result.Usage)
+}
+
+// TestAnthropic_StreamRequest verifies that Anthropic events are normalized and aggregated into the final response
+func TestAnthropic_StreamRequest(t *testing.T) {
+	server := newAnthropicStreamServer("Hi", " there")
+	defer server.Close()
+	os.Setenv("ANTHROPIC_ENDPOINT", server.URL)
+	defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+
+	provider := providers.Factory(models.ProxyRequest{
+		Provider: "anthropic",
+		ApiKey:   "test-key",
+		Messages: []models.Message{{Role: "user", Content: "Hello"}},
+		Model:    "claude-3-5-sonnet-20240620",
+	})
+
+	var chunks []providers.StreamChunk
+	result := provider.StreamRequest(context.Background(), func(chunk providers.StreamChunk) {
+		chunks = append(chunks, chunk)
+	})
+
+	assert.Len(t, chunks, 3)
+	assert.Equal(t, "anthropic", chunks[0].Provider)
+	assert.Equal(t, "claude-3-5-sonnet-20240620", chunks[0].Model)
+	assert.Equal(t, " there", chunks[1].Delta.Content)
+	assert.Equal(t, "stop", chunks[2].FinishReason)
+	assert.Equal(t, "Hi there", result.Choices[0].Content)
+	assert.Equal(t, models.Usage{PromptTokens: 25, CompletionTokens: 9}, result.Usage)
+}
+
+// TestAnthropic_StreamRequest_ClientDisconnect verifies that canceling the context stops the upstream call
+// and still returns the partial response for token accounting
+func TestAnthropic_StreamRequest_ClientDisconnect(t *testing.T) {
+	upstreamCanceled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"partial\"}}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		close(upstreamCanceled)
+	}))
+	defer server.Close()
+	os.Setenv("ANTHROPIC_ENDPOINT", server.URL)
+	defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+
+	provider := providers.Factory(models.ProxyRequest{
+		Provider: "anthropic",
+		ApiKey:   "test-key",
+		Messages: []models.Message{{Role: "user", Content: "Hello"}},
+		Model:    "claude-3-5-sonnet-20240620",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := provider.StreamRequest(ctx, func(chunk providers.StreamChunk) {
+		cancel()
+	})
+
+	select {
+	case <-upstreamCanceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream request wasn't canceled")
+	}
+	assert.Equal(t, "partial", result.Choices[0].Content)
+}
+
+// TestChatCompletions_Stream verifies the whole streaming flow through the routes,
+// including server-sent events output, metrics recording and quota accounting
+func TestChatCompletions_Stream(t *testing.T) {
+	os.Setenv("IS_TEST", "1")
+	gin.SetMode(gin.TestMode)
+	configs.LoadConfig()
+	services.GetMetricsService().Reset()
+	services.GetQuotaService().Reset()
+
+	server := newAnthropicStreamServer("Hi", " there")
+	defer server.Close()
+	os.Setenv("ANTHROPIC_ENDPOINT", server.URL)
+	defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+
+	payload := map[string]interface{}{
+		"messages": []map[string]string{
+			{"role": "user", "content": "Hello"},
+		},
+		"model":  "claude-3-5-sonnet-20240620",
+		"stream": true,
+	}
+	body, _ := json.Marshal(payload)
+	req := httptest.NewRequest("POST", "/chat/completions", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer vk_user2_anthropic")
+	w := httptest.NewRecorder()
+
+	routes.HandleRequests().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/event-stream")
+	assert.Contains(t, w.Body.String(), `"content":"Hi"`)
+	assert.Contains(t, w.Body.String(), "data:[DONE]")
+
+	stats := services.GetMetricsService().GetStats()
+	assert.Equal(t, 1, stats["total_requests"])
+	requests, tokens := services.GetQuotaService().GetUsage("vk_user2_anthropic")
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, 34, tokens)
+}
@@ -62,7 +62,7 @@ func TestCalculateRequestTokens_SingleMessage(t *testing.T) {
 }
 
 // TestCalculateRequestTokens_MultipleMessages verifies token calculation for multiple messages
-// Tests that three messages plus base tokens sum to 31 tokens total
+// Tests that three messages plus base tokens sum to 34 tokens total
 func TestCalculateRequestTokens_MultipleMessages(t *testing.T) {
 	messages := []models.Message{
 		{Role: "system", Content: "You are a helpful assistant"},
@@ -72,12 +72,12 @@ func TestCalculateRequestTokens_MultipleMessages(t *testing.T) {
 
 	tokens := utils.CalculateRequestTokens(messages, "gpt-3.5-turbo")
 
-	// Message 1: 1 + 6 + 3 = 10
-	// Message 2: 1 + 4 + 3 = 8
-	// Message 3: 1 + 6 + 3 = 10
+	// Message 1: 1 + 5 + 3 = 9
+	// Message 2: 1 + 6 + 3 = 10
+	// Message 3: 1 + 8 + 3 = 12
 	// Base: 3
-	// Total: 31 tokens
-	assert.Equal(t, 31, tokens)
+	// Total: 34 tokens
+	assert.Equal(t, 34, tokens)
 }
 
 // TestCalculateRequestTokens_EmptyMessage verifies token calculation for empty message
@@ -111,28 +111,26 @@ func TestCalculateRequestTokens_LongConversation(t *testing.T) {
 }
 
 // TestCalculateResponseTokens_Empty verifies token calculation for empty response
-// Tests that empty response results in 3 tokens for formatting overhead
+// Tests that empty response results in 0 tokens
 func TestCalculateResponseTokens_Empty(t *testing.T) {
-	tokens := utils.CalculateResponseTokens("")
-	assert.Equal(t, 3, tokens) // Just formatting overhead
+	tokens := utils.CalculateResponseTokens("", "gpt-3.5-turbo")
+	assert.Equal(t, 0, tokens)
 }
 
 // TestCalculateResponseTokens_ShortResponse verifies token calculation for minimal response
-// Tests that "Yes" results in 4 tokens total (1 content + 3 formatting)
+// Tests that "Yes" results in 1 token
 func TestCalculateResponseTokens_ShortResponse(t *testing.T) {
-	tokens := utils.CalculateResponseTokens("Yes")
-	// 0 (3 chars / 4) + 3 (formatting) = 3 tokens (minimum 1 for content)
-	assert.Equal(t, 4, tokens)
+	tokens := utils.CalculateResponseTokens("Yes", "gpt-3.5-turbo")
+	assert.Equal(t, 1, tokens)
 }
 
 // TestCalculateResponseTokens_LongResponse verifies token calculation for detailed response
-// Tests that long response results in approximately 35 tokens with delta of 5
+// Tests that long response results in its reference count of 19 tokens
 func TestCalculateResponseTokens_LongResponse(t *testing.T) {
 	response := "This is a detailed response that contains multiple sentences and provides comprehensive information about the topic at hand."
-	tokens := utils.CalculateResponseTokens(response)
+	tokens := utils.CalculateResponseTokens(response, "gpt-3.5-turbo")
 
-	// ~130 chars / 4 = ~32 tokens + 3 formatting = ~35 tokens
-	assert.InDelta(t, 35, tokens, 5)
+	assert.Equal(t, 19, tokens)
 }
 
 // TestTokenEstimation_Accuracy verifies token estimation accuracy across multiple cases
@@ -157,3 +155,55 @@ func TestTokenEstimation_Accuracy(t *testing.T) {
 		})
 	}
 }
+
+// TestCountTokens_ReferenceCounts verifies the BPE token counts against the reference counts
+// published by OpenAI for the cl100k and o200k encodings
+func TestCountTokens_ReferenceCounts(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		model    string
+		expected int
+	}{
+		{"cl100k english", "tiktoken is great!", "gpt-4", 6},
+		{"cl100k long word", "antidisestablishmentarianism", "gpt-4", 6},
+		{"cl100k math", "2 + 2 = 4", "gpt-3.5-turbo", 7},
+		{"cl100k japanese", "お誕生日おめでとう", "gpt-4", 9},
+		{"o200k english", "tiktoken is great!", "gpt-4o", 6},
+		{"o200k japanese", "お誕生日おめでとう", "gpt-4o", 8},
+		{"o200k emoji", "👋🌍", "gpt-4o-mini", 4},
+		{"cl100k emoji", "👋🌍", "gpt-4", 6},
+		{"cl100k code", "func main() {\n\tfmt.Println(\"hi\")\n}", "gpt-4", 10},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, utils.CountTokens(tc.text, tc.model))
+		})
+	}
+}
+
+// TestCountTokens_Empty verifies that an empty text has no tokens
+func TestCountTokens_Empty(t *testing.T) {
+	assert.Equal(t, 0, utils.CountTokens("", "gpt-4o"))
+}
+
+// TestEncodingForModel verifies that models are mapped to their encodings by name and prefix
+func TestEncodingForModel(t *testing.T) {
+	testCases := map[string]string{
+		"gpt-4o":                     utils.O200K_BASE,
+		"gpt-4o-2024-05-13":          utils.O200K_BASE,
+		"gpt-4.1-mini":               utils.O200K_BASE,
+		"o3-mini":                    utils.O200K_BASE,
+		"gpt-4":                      utils.CL100K_BASE,
+		"gpt-3.5-turbo-0125":         utils.CL100K_BASE,
+		"claude-3-5-sonnet-20240620": utils.CL100K_BASE,
+		"unknown-model":              utils.CL100K_BASE,
+	}
+
+	for model, expected := range testCases {
+		t.Run(model, func(t *testing.T) {
+			assert.Equal(t, expected, utils.EncodingForModel(model))
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// weatherTool is the function the tests let the model call
+var weatherTool = openai.ChatCompletionFunctionTool(shared.FunctionDefinitionParam{
+	Name:        "get_weather",
+	Description: openai.String("Returns the weather of a city"),
+	Parameters: shared.FunctionParameters{
+		"type":       "object",
+		"properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}},
+	},
+})
+
+// runAgentLoop runs a client-side agent loop which answers every call of get_weather until the model answers in text
+func runAgentLoop(t *testing.T, client openai.Client, model string) string {
+	params := openai.ChatCompletionNewParams{
+		Model:    model,
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("What's the weather in Paris?")},
+		Tools:    []openai.ChatCompletionToolUnionParam{weatherTool},
+	}
+
+	for i := 0; i < 3; i++ {
+		completion, err := client.Chat.Completions.New(context.Background(), params)
+		require.NoError(t, err)
+
+		message := completion.Choices[0].Message
+		if len(message.ToolCalls) == 0 {
+			return message.Content
+		}
+		assert.Equal(t, "tool_calls", completion.Choices[0].FinishReason)
+
+		params.Messages = append(params.Messages, message.ToParam())
+		for _, toolCall := range message.ToolCalls {
+			assert.Equal(t, "get_weather", toolCall.Function.Name)
+			assert.JSONEq(t, `{"city":"Paris"}`, toolCall.Function.Arguments)
+			params.Messages = append(params.Messages, openai.ToolMessage("Sunny, 24C", toolCall.ID))
+		}
+	}
+	t.Fatal("the agent loop didn't end")
+	return ""
+}
+
+// TestTools_AgentLoop_OpenAI verifies that a client-side agent loop works against OpenAI
+func TestTools_AgentLoop_OpenAI(t *testing.T) {
+	server := newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"id": "chatcmpl-1",
+		"model": "gpt-4o",
+		"choices": [{"index": 0, "finish_reason": "tool_calls", "message": {"role": "assistant", "content": null,
+			"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]}}],
+		"usage": {"prompt_tokens": 20, "completion_tokens": 10, "total_tokens": 30}
+	}`, `{
+		"id": "chatcmpl-2",
+		"model": "gpt-4o",
+		"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "It's sunny in Paris."}}],
+		"usage": {"prompt_tokens": 40, "completion_tokens": 10, "total_tokens": 50}
+	}`)
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+
+	answer := runAgentLoop(t, newGatewayClient(t, "vk_user1_openai"), "gpt-4o")
+
+	assert.Equal(t, "It's sunny in Paris.", answer)
+	require.Len(t, server.Bodies(), 2)
+	assert.Equal(t, "get_weather", server.Bodies()[0]["tools"].([]interface{})[0].(map[string]interface{})["function"].(map[string]interface{})["name"])
+
+	messages := server.Bodies()[1]["messages"].([]interface{})
+	require.Len(t, messages, 3)
+	assistant := messages[1].(map[string]interface{})
+	assert.Equal(t, "call_1", assistant["tool_calls"].([]interface{})[0].(map[string]interface{})["id"])
+	assert.Equal(t, map[string]interface{}{"role": "tool", "tool_call_id": "call_1", "content": "Sunny, 24C"}, messages[2])
+}
+
+// TestTools_AgentLoop_Anthropic verifies that the same agent loop works against Anthropic,
+// whose tool_use and tool_result blocks are translated from and to the OpenAI function calling
+func TestTools_AgentLoop_Anthropic(t *testing.T) {
+	server := newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"id": "msg_1",
+		"role": "assistant",
+		"content": [
+			{"type": "text", "text": "Let me check."},
+			{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+		],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 20, "output_tokens": 10}
+	}`, `{
+		"id": "msg_2",
+		"role": "assistant",
+		"content": [{"type": "text", "text": "It's sunny in Paris."}],
+		"stop_reason": "end_turn",
+		"usage": {"input_tokens": 40, "output_tokens": 10}
+	}`)
+	os.Setenv("ANTHROPIC_ENDPOINT", server.URL)
+	defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+
+	answer := runAgentLoop(t, newGatewayClient(t, "vk_user2_anthropic"), "claude-3-5-sonnet-20240620")
+
+	assert.Equal(t, "It's sunny in Paris.", answer)
+	require.Len(t, server.Bodies(), 2)
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"name":         "get_weather",
+		"description":  "Returns the weather of a city",
+		"input_schema": map[string]interface{}{"type": "object", "properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}}},
+	}}, server.Bodies()[0]["tools"])
+
+	messages := server.Bodies()[1]["messages"].([]interface{})
+	require.Len(t, messages, 3)
+	assert.Equal(t, map[string]interface{}{"role": "assistant", "content": []interface{}{
+		map[string]interface{}{"type": "text", "text": "Let me check."},
+		map[string]interface{}{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": map[string]interface{}{"city": "Paris"}},
+	}}, messages[1])
+	assert.Equal(t, map[string]interface{}{"role": "user", "content": []interface{}{
+		map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_1", "content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "Sunny, 24C"},
+		}},
+	}}, messages[2])
+}
+
+// TestTools_ToolChoice_Anthropic verifies that the tool choice and the parallel tool calls option are translated
+func TestTools_ToolChoice_Anthropic(t *testing.T) {
+	server := newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"id": "msg_1",
+		"role": "assistant",
+		"content": [{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 20, "output_tokens": 10}
+	}`)
+	os.Setenv("ANTHROPIC_ENDPOINT", server.URL)
+	defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+	client := newGatewayClient(t, "vk_user2_anthropic")
+
+	completion, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:             "claude-3-5-sonnet-20240620",
+		Messages:          []openai.ChatCompletionMessageParamUnion{openai.UserMessage("What's the weather in Paris?")},
+		Tools:             []openai.ChatCompletionToolUnionParam{weatherTool},
+		ToolChoice:        openai.ToolChoiceOptionFunctionToolChoice(openai.ChatCompletionNamedToolChoiceFunctionParam{Name: "get_weather"}),
+		ParallelToolCalls: openai.Bool(false),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{"type": "tool", "name": "get_weather", "disable_parallel_tool_use": true}, server.Last().Body["tool_choice"])
+	assert.Empty(t, completion.Choices[0].Message.Content)
+	assert.Equal(t, "toolu_1", completion.Choices[0].Message.ToolCalls[0].ID)
+}
+
+// TestTools_Stream_Anthropic verifies that streamed tool_use blocks are relayed as OpenAI tool call deltas
+func TestTools_Stream_Anthropic(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"model\":\"claude-3-5-sonnet-20240620\",\"usage\":{\"input_tokens\":25,\"output_tokens\":1}}}\n\n")
+		fmt.Fprint(w, "event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n")
+		fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Let me check.\"}}\n\n")
+		fmt.Fprint(w, "event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"get_weather\",\"input\":{}}}\n\n")
+		fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"city\\\":\"}}\n\n")
+		fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"\\\"Paris\\\"}\"}}\n\n")
+		fmt.Fprint(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"},\"usage\":{\"output_tokens\":9}}\n\n")
+		fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	}))
+	defer server.Close()
+	os.Setenv("ANTHROPIC_ENDPOINT", server.URL)
+	defer os.Unsetenv("ANTHROPIC_ENDPOINT")
+	client := newGatewayClient(t, "vk_user2_anthropic")
+
+	stream := client.Chat.Completions.NewStreaming(context.Background(), openai.ChatCompletionNewParams{
+		Model:    "claude-3-5-sonnet-20240620",
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("What's the weather in Paris?")},
+		Tools:    []openai.ChatCompletionToolUnionParam{weatherTool},
+	})
+	accumulator := openai.ChatCompletionAccumulator{}
+	for stream.Next() {
+		accumulator.AddChunk(stream.Current())
+	}
+	require.NoError(t, stream.Err())
+
+	message := accumulator.Choices[0].Message
+	assert.Equal(t, "Let me check.", message.Content)
+	require.Len(t, message.ToolCalls, 1)
+	assert.Equal(t, "toolu_1", message.ToolCalls[0].ID)
+	assert.Equal(t, "get_weather", message.ToolCalls[0].Function.Name)
+	assert.JSONEq(t, `{"city":"Paris"}`, message.ToolCalls[0].Function.Arguments)
+	assert.Equal(t, "tool_calls", accumulator.Choices[0].FinishReason)
+}
+
+// TestTools_AnthropicMessages_OpenAI verifies that the tool blocks of the Anthropic messages endpoint
+// are translated to the OpenAI function calling, and the tool calls back to tool_use blocks
+func TestTools_AnthropicMessages_OpenAI(t *testing.T) {
+	server := newFakeUpstream(t, http.StatusOK, "application/json", `{
+		"id": "chatcmpl-1",
+		"model": "gpt-4o",
+		"choices": [{"index": 0, "finish_reason": "tool_calls", "message": {"role": "assistant", "content": null,
+			"tool_calls": [{"id": "call_2", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Rome\"}"}}]}}],
+		"usage": {"prompt_tokens": 20, "completion_tokens": 10, "total_tokens": 30}
+	}`)
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+
+	w := sendAnthropicMessage("vk_user1_openai", `{
+		"model": "gpt-4o",
+		"max_tokens": 64,
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"tool_choice": {"type": "any"},
+		"messages": [
+			{"role": "user", "content": "What's the weather in Paris and Rome?"},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": {"city": "Paris"}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "call_1", "content": "Sunny"}, {"type": "text", "text": "And Rome?"}]}
+		]
+	}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	assert.Equal(t, "required", server.Last().Body["tool_choice"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"role": "user", "content": "What's the weather in Paris and Rome?"},
+		map[string]interface{}{"role": "assistant", "tool_calls": []interface{}{map[string]interface{}{
+			"id": "call_1", "type": "function", "function": map[string]interface{}{"name": "get_weather", "arguments": `{"city":"Paris"}`},
+		}}},
+		map[string]interface{}{"role": "tool", "tool_call_id": "call_1", "content": "Sunny"},
+		map[string]interface{}{"role": "user", "content": "And Rome?"},
+	}, server.Last().Body["messages"])
+
+	var message map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &message))
+	assert.Equal(t, "tool_use", message["stop_reason"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"type": "tool_use", "id": "call_2", "name": "get_weather", "input": map[string]interface{}{"city": "Rome"},
+	}}, message["content"])
+}
+
+// TestTools_AnthropicMessages_Stream verifies that streamed OpenAI tool calls are relayed as tool_use blocks
+func TestTools_AnthropicMessages_Stream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"type\":\"function\",\"function\":{\"name\":\"get_weather\",\"arguments\":\"\"}}]}}]}\n\n")
+		fmt.Fprint(w, "data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"{\\\"city\\\":\\\"Paris\\\"}\"}}]}}]}\n\n")
+		fmt.Fprint(w, "data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"tool_calls\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+	os.Setenv("OPENAI_BASE_URL", server.URL)
+	defer os.Unsetenv("OPENAI_BASE_URL")
+
+	w := sendAnthropicMessage("vk_user1_openai", `{
+		"model": "gpt-4o",
+		"max_tokens": 64,
+		"stream": true,
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"messages": [{"role": "user", "content": "What's the weather in Paris?"}]
+	}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	assert.Contains(t, body, `"content_block":{"id":"call_1","input":{},"name":"get_weather","type":"tool_use"}`)
+	assert.Contains(t, body, `"delta":{"partial_json":"{\"city\":\"Paris\"}","type":"input_json_delta"}`)
+	assert.Contains(t, body, `"stop_reason":"tool_use"`)
+	assert.Equal(t, 1, strings.Count(body, "event:content_block_start"))
+	assert.Equal(t, 1, strings.Count(body, "event:content_block_stop"))
+}
+
+// TestTools_Validation verifies that malformed tool messages and tool choices are rejected
+func TestTools_Validation(t *testing.T) {
+	client := newGatewayClient(t, "vk_user1_openai")
+
+	_, err := client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model: "gpt-4o",
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.UserMessage("What's the weather in Paris?"),
+			openai.ToolMessage("Sunny", ""),
+		},
+	})
+	var apiErr *openai.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Contains(t, apiErr.Message, "ToolCallID")
+
+	_, err = client.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:      "gpt-4o",
+		Messages:   []openai.ChatCompletionMessageParamUnion{openai.UserMessage("What's the weather in Paris?")},
+		Tools:      []openai.ChatCompletionToolUnionParam{weatherTool},
+		ToolChoice: openai.ToolChoiceOptionFunctionToolChoice(openai.ChatCompletionNamedToolChoiceFunctionParam{Name: "get_time"}),
+	})
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Contains(t, apiErr.Message, "get_time")
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// upstreamRequest represents a request received by a fake upstream
+type upstreamRequest struct {
+	// URL is the path and query of the request
+	URL        string
+	Path       string
+	ApiVersion string
+	Header     http.Header
+	// Body is the decoded JSON body of the request, nil if it isn't JSON
+	Body  map[string]interface{}
+	Model string
+}
+
+// upstreamResponse represents the answer of a fake upstream
+type upstreamResponse struct {
+	Status      int
+	ContentType string
+	Body        string
+}
+
+// fakeUpstream is a fake provider which records the requests it receives
+type fakeUpstream struct {
+	*httptest.Server
+	Requests []upstreamRequest
+}
+
+// newFakeUpstream starts a fake provider which answers the successive requests with the given status, content type
+// and bodies in turn, the last body answering all the later requests
+func newFakeUpstream(t *testing.T, status int, contentType string, bodies ...string) *fakeUpstream {
+	return startFakeUpstream(t, func(request upstreamRequest, n int) upstreamResponse {
+		return upstreamResponse{Status: status, ContentType: contentType, Body: bodies[min(n, len(bodies)-1)]}
+	})
+}
+
+// startFakeUpstream starts a fake provider which answers the nth request, counted from zero, with the reply
+func startFakeUpstream(t *testing.T, reply func(request upstreamRequest, n int) upstreamResponse) *fakeUpstream {
+	upstream := &fakeUpstream{}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		request := upstreamRequest{
+			URL:        r.URL.String(),
+			Path:       r.URL.Path,
+			ApiVersion: r.URL.Query().Get("api-version"),
+			Header:     r.Header.Clone(),
+		}
+		_ = json.Unmarshal(b, &request.Body)
+		request.Model, _ = request.Body["model"].(string)
+		upstream.Requests = append(upstream.Requests, request)
+
+		response := reply(request, len(upstream.Requests)-1)
+		w.Header().Set("Content-Type", response.ContentType)
+		w.WriteHeader(response.Status)
+		w.Write([]byte(response.Body))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// Last returns the last request the upstream received
+func (u *fakeUpstream) Last() upstreamRequest {
+	if len(u.Requests) == 0 {
+		return upstreamRequest{}
+	}
+	return u.Requests[len(u.Requests)-1]
+}
+
+// Bodies returns the bodies of the requests the upstream received
+func (u *fakeUpstream) Bodies() []map[string]interface{} {
+	bodies := make([]map[string]interface{}, len(u.Requests))
+	for i, request := range u.Requests {
+		bodies[i] = request.Body
+	}
+	return bodies
+}
+
+// SetEndpoint points the provider endpoint environment variable at the upstream for the test
+func (u *fakeUpstream) SetEndpoint(t *testing.T, name string) {
+	os.Setenv(name, u.URL)
+	t.Cleanup(func() { os.Unsetenv(name) })
+}